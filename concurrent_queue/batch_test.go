@@ -0,0 +1,198 @@
+package concurrent_queue
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// batchQueues 返回容量为capacity的各种批量队列，元素都是int，DelayQueue中的元素立刻到期
+func batchQueues(capacity int) []struct {
+	name string
+	q    BatchQueue[int]
+} {
+	return []struct {
+		name string
+		q    BatchQueue[int]
+	}{
+		{name: "fifo", q: NewConcurrentQueue[int](capacity)},
+		{name: "priority", q: NewPriorityQueue[int](compareInt, PriorityQueueWithCapacity[int](capacity))},
+		{name: "delay", q: delayInts{NewDelayQueue[Delayed[int]](DelayQueueWithCapacity[Delayed[int]](capacity))}},
+	}
+}
+
+// delayInts 把DelayQueue适配成int的队列，入队的元素立刻到期，值越小的元素越早到期
+type delayInts struct {
+	*DelayQueue[Delayed[int]]
+}
+
+func dueInt(v int) Delayed[int] {
+	return Delayed[int]{Value: v, At: time.Unix(0, int64(v))}
+}
+
+func (d delayInts) EnQueue(ctx context.Context, data int) error {
+	return d.DelayQueue.EnQueue(ctx, dueInt(data))
+}
+
+func (d delayInts) DeQueue(ctx context.Context) (int, error) {
+	item, err := d.DelayQueue.DeQueue(ctx)
+	return item.Value, err
+}
+
+func (d delayInts) EnQueueBatch(ctx context.Context, data []int) error {
+	items := make([]Delayed[int], 0, len(data))
+	for _, v := range data {
+		items = append(items, dueInt(v))
+	}
+	return d.DelayQueue.EnQueueBatch(ctx, items)
+}
+
+func (d delayInts) DeQueueBatch(ctx context.Context, limit int, maxWait time.Duration) ([]int, error) {
+	items, err := d.DelayQueue.DeQueueBatch(ctx, limit, maxWait)
+	return values(items), err
+}
+
+func (d delayInts) Drain() []int {
+	return values(d.DelayQueue.Drain())
+}
+
+func values(items []Delayed[int]) []int {
+	if items == nil {
+		return nil
+	}
+	res := make([]int, 0, len(items))
+	for _, item := range items {
+		res = append(res, item.Value)
+	}
+	return res
+}
+
+func TestBatchQueue(t *testing.T) {
+	for _, tc := range batchQueues(4) {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			q := tc.q
+			assert.Equal(t, ErrBatchTooLarge, q.EnQueueBatch(ctx, []int{1, 2, 3, 4, 5}))
+			require.NoError(t, q.EnQueueBatch(ctx, []int{1, 2, 3}))
+			require.NoError(t, q.EnQueueBatch(ctx, nil))
+
+			// 空位不足时阻塞，不会只放进去一部分
+			timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+			defer cancel()
+			assert.Equal(t, context.DeadlineExceeded, q.EnQueueBatch(timeout, []int{4, 5}))
+			assert.Equal(t, uint64(3), q.Len())
+
+			// 足够的时候不等待
+			start := time.Now()
+			res, err := q.DeQueueBatch(ctx, 2, time.Hour)
+			require.NoError(t, err)
+			assert.Equal(t, []int{1, 2}, res)
+			assert.Less(t, time.Since(start), time.Second)
+
+			// 不够的时候等满maxWait之后取出剩下的
+			start = time.Now()
+			res, err = q.DeQueueBatch(ctx, 2, 20*time.Millisecond)
+			require.NoError(t, err)
+			assert.Equal(t, []int{3}, res)
+			assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+			res, err = q.DeQueueBatch(ctx, 2, 0)
+			require.NoError(t, err)
+			assert.Empty(t, res)
+			res, err = q.DeQueueBatch(ctx, 0, time.Hour)
+			require.NoError(t, err)
+			assert.Empty(t, res)
+
+			require.NoError(t, q.EnQueueBatch(ctx, []int{4, 5, 6}))
+			assert.Equal(t, []int{4, 5, 6}, q.Drain())
+			assert.Empty(t, q.Drain())
+			assert.True(t, q.IsEmpty())
+		})
+	}
+}
+
+func TestBatchQueue_Wait(t *testing.T) {
+	for _, tc := range batchQueues(8) {
+		t.Run(tc.name, func(t *testing.T) {
+			q := tc.q
+			res := make(chan []int)
+			go func() {
+				vals, err := q.DeQueueBatch(context.Background(), 3, time.Second)
+				assert.NoError(t, err)
+				res <- vals
+			}()
+
+			// 等待的过程中凑够了一批就立刻返回
+			for i := 1; i <= 4; i++ {
+				time.Sleep(5 * time.Millisecond)
+				require.NoError(t, q.EnQueue(context.Background(), i))
+			}
+			select {
+			case vals := <-res:
+				assert.Equal(t, []int{1, 2, 3}, vals)
+			case <-time.After(500 * time.Millisecond):
+				t.Fatal("凑够一批之后没有返回")
+			}
+
+			// ctx结束时不会取出任何元素
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			_, err := q.DeQueueBatch(ctx, 2, time.Hour)
+			assert.Equal(t, context.DeadlineExceeded, err)
+			assert.Equal(t, uint64(1), q.Len())
+		})
+	}
+}
+
+func TestBatchQueue_Atomic(t *testing.T) {
+	q := NewConcurrentQueue[int](64)
+	const producers, batches, size = 4, 50, 8
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for b := 0; b < batches; b++ {
+				batch := make([]int, size)
+				for i := range batch {
+					batch[i] = (p*batches+b)*size + i
+				}
+				assert.NoError(t, q.EnQueueBatch(context.Background(), batch))
+			}
+		}(p)
+	}
+
+	// 每一批元素在队列中是连续的，按照批的大小取出来的正好是完整的一批
+	for i := 0; i < producers*batches; i++ {
+		vals, err := q.DeQueueBatch(context.Background(), size, time.Second)
+		require.NoError(t, err)
+		require.Len(t, vals, size)
+		for j := range vals {
+			assert.Equal(t, vals[0]+j, vals[j])
+		}
+		assert.Zero(t, vals[0]%size)
+	}
+	wg.Wait()
+}
+
+func TestDelayQueue_DeQueueBatch(t *testing.T) {
+	q := NewDelayQueue[Delayed[int]]()
+	ctx := context.Background()
+	require.NoError(t, q.EnQueueBatch(ctx, []Delayed[int]{
+		NewDelayed(1, 0), NewDelayed(2, 20*time.Millisecond), NewDelayed(3, time.Hour),
+	}))
+
+	// 只取出已经到期的元素，等待的过程中到期的元素也会被取出
+	items, err := q.DeQueueBatch(ctx, 3, 100*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, values(items))
+	items, err = q.DeQueueBatch(ctx, 1, 10*time.Millisecond)
+	require.NoError(t, err)
+	assert.Empty(t, items)
+	// Drain会取出没有到期的元素
+	assert.Equal(t, []int{3}, values(q.Drain()))
+}
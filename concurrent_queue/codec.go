@@ -0,0 +1,24 @@
+package concurrent_queue
+
+import "encoding/json"
+
+// Codec 元素的编解码器，DiskQueue把元素写到磁盘的时候使用
+type Codec[T any] interface {
+	Encode(val T) ([]byte, error)
+	Decode(data []byte) (T, error)
+}
+
+// JSONCodec 使用JSON编解码元素，DiskQueue默认的编解码器
+type JSONCodec[T any] struct{}
+
+var _ Codec[any] = JSONCodec[any]{}
+
+func (JSONCodec[T]) Encode(val T) ([]byte, error) {
+	return json.Marshal(val)
+}
+
+func (JSONCodec[T]) Decode(data []byte) (T, error) {
+	var val T
+	err := json.Unmarshal(data, &val)
+	return val, err
+}
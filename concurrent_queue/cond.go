@@ -0,0 +1,58 @@
+package concurrent_queue
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// cond 和sync.Cond类似的条件变量，区别是Wait可以响应ctx
+type cond struct {
+	L sync.Locker
+	// Broadcast的时候关闭并替换
+	ch chan struct{}
+}
+
+func newCond(l sync.Locker) *cond {
+	return &cond{
+		L:  l,
+		ch: make(chan struct{}),
+	}
+}
+
+// Wait 释放锁，等待Broadcast或者ctx结束，返回之前重新获取锁，ctx结束时返回ctx.Err()。
+// 和sync.Cond一样，被唤醒之后需要重新检查条件，调用方需要持有c.L
+func (c *cond) Wait(ctx context.Context) error {
+	ch := c.ch
+	c.L.Unlock()
+	defer c.L.Lock()
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WaitTimeout 和Wait一样，最多等待d，超时的时候返回nil
+func (c *cond) WaitTimeout(ctx context.Context, d time.Duration) error {
+	ch := c.ch
+	c.L.Unlock()
+	defer c.L.Lock()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return nil
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Broadcast 唤醒所有等待中的goroutine，调用方需要持有c.L
+func (c *cond) Broadcast() {
+	close(c.ch)
+	c.ch = make(chan struct{})
+}
@@ -0,0 +1,247 @@
+package concurrent_queue
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Delayable DelayQueue中的元素，到了ReadyAt之后才能出队
+type Delayable interface {
+	ReadyAt() time.Time
+}
+
+// Delayed 给任意的值加上出队时间，可以直接作为DelayQueue的元素
+type Delayed[V any] struct {
+	Value V
+	At    time.Time
+}
+
+func (d Delayed[V]) ReadyAt() time.Time {
+	return d.At
+}
+
+// NewDelayed 创建一个delay之后才能出队的元素
+func NewDelayed[V any](val V, delay time.Duration) Delayed[V] {
+	return Delayed[V]{Value: val, At: time.Now().Add(delay)}
+}
+
+var _ BatchQueue[Delayed[any]] = (*DelayQueue[Delayed[any]])(nil)
+
+// DelayQueue 延时队列，并发安全，元素按照ReadyAt的顺序出队。
+// DeQueue阻塞到最早的元素到期，期间加入了更早到期的元素时会被唤醒，重新等待新的队头；
+// 设置了容量并且队列已满时EnQueue阻塞。可以用作重试、延时投递等场景的调度器
+type DelayQueue[T Delayable] struct {
+	mu   sync.Mutex
+	heap binaryHeap[T]
+	// 容量，小于等于0表示不限制
+	capacity int
+	notFull  *cond
+	// 队头发生变化或者加入了已经到期的元素的时候广播，包括从空队列变成非空
+	headChanged *cond
+	// 获取当前时间，测试时可以替换
+	now func() time.Time
+}
+
+type DelayQueueOption[T Delayable] func(q *DelayQueue[T])
+
+// DelayQueueWithCapacity 设置队列的容量，默认不限制
+func DelayQueueWithCapacity[T Delayable](capacity int) DelayQueueOption[T] {
+	return func(q *DelayQueue[T]) {
+		q.capacity = capacity
+	}
+}
+
+func NewDelayQueue[T Delayable](opts ...DelayQueueOption[T]) *DelayQueue[T] {
+	q := &DelayQueue[T]{
+		now: time.Now,
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	q.heap = newBinaryHeap(func(src, dst T) int {
+		return src.ReadyAt().Compare(dst.ReadyAt())
+	}, q.capacity)
+	q.notFull = newCond(&q.mu)
+	q.headChanged = newCond(&q.mu)
+	return q
+}
+
+// EnQueue 元素入队，队列已满时阻塞到有空位，ctx结束时返回ctx.Err()
+func (q *DelayQueue[T]) EnQueue(ctx context.Context, data T) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.isFull() {
+		if err := q.notFull.Wait(ctx); err != nil {
+			return err
+		}
+	}
+
+	q.push(data)
+	return nil
+}
+
+// push 加入一个元素，需要的时候唤醒等待中的DeQueue，调用方需要持有q.mu
+func (q *DelayQueue[T]) push(data T) {
+	// 新的元素比原来的队头更早到期时，等待中的DeQueue需要重新计算等待时间；
+	// 新的元素已经到期时，等待凑够一批的DeQueueBatch可能可以返回了
+	if q.heap.push(data) || !data.ReadyAt().After(q.now()) {
+		q.headChanged.Broadcast()
+	}
+}
+
+// EnQueueBatch 批量入队，设置了容量并且空位不足时阻塞到所有元素都能放下，元素个数超过容量时返回ErrBatchTooLarge
+func (q *DelayQueue[T]) EnQueueBatch(ctx context.Context, data []T) error {
+	if q.capacity > 0 && len(data) > q.capacity {
+		return ErrBatchTooLarge
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.capacity > 0 && q.heap.len()+len(data) > q.capacity {
+		if err := q.notFull.Wait(ctx); err != nil {
+			return err
+		}
+	}
+
+	for _, val := range data {
+		q.push(val)
+	}
+	return nil
+}
+
+// DeQueueBatch 按照到期的顺序批量出队，只会取出已经到期的元素，到期的元素不足limit个时最多等待maxWait
+func (q *DelayQueue[T]) DeQueueBatch(ctx context.Context, limit int, maxWait time.Duration) ([]T, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	deadline := q.now().Add(maxWait)
+	for {
+		due, next := q.due(limit)
+		if due >= limit {
+			break
+		}
+		now := q.now()
+		wait := deadline.Sub(now)
+		if wait <= 0 {
+			break
+		}
+		// 下一个元素到期的时候重新统计
+		if !next.IsZero() {
+			wait = min(wait, next.Sub(now))
+		}
+		if err := q.headChanged.WaitTimeout(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+
+	var res []T
+	now := q.now()
+	for len(res) < limit && q.heap.len() > 0 && !q.heap.peek().ReadyAt().After(now) {
+		res = append(res, q.heap.pop())
+	}
+	if len(res) > 0 {
+		q.notFull.Broadcast()
+	}
+	return res, nil
+}
+
+// due 统计已经到期的元素个数，统计到limit个时停止，同时返回没有到期的元素中最早的到期时间，调用方需要持有q.mu
+func (q *DelayQueue[T]) due(limit int) (int, time.Time) {
+	now := q.now()
+	var (
+		cnt  int
+		next time.Time
+	)
+	// 没有到期的元素的子节点也不会到期，不需要继续遍历
+	stack := []int{0}
+	for len(stack) > 0 && cnt < limit {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if i >= q.heap.len() {
+			continue
+		}
+		at := q.heap.data[i].ReadyAt()
+		if at.After(now) {
+			if next.IsZero() || at.Before(next) {
+				next = at
+			}
+			continue
+		}
+		cnt++
+		stack = append(stack, 2*i+1, 2*i+2)
+	}
+	return cnt, next
+}
+
+// Drain 按照到期的顺序取出所有的元素，包括还没有到期的元素
+func (q *DelayQueue[T]) Drain() []T {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.heap.len() == 0 {
+		return nil
+	}
+	res := make([]T, 0, q.heap.len())
+	for q.heap.len() > 0 {
+		res = append(res, q.heap.pop())
+	}
+	q.notFull.Broadcast()
+	return res
+}
+
+// DeQueue 最早到期的元素出队，队列为空或者还没有元素到期时阻塞，ctx结束时返回ctx.Err()
+func (q *DelayQueue[T]) DeQueue(ctx context.Context) (T, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		var err error
+		if q.heap.len() == 0 {
+			err = q.headChanged.Wait(ctx)
+		} else if wait := q.heap.peek().ReadyAt().Sub(q.now()); wait > 0 {
+			err = q.headChanged.WaitTimeout(ctx, wait)
+		} else {
+			data := q.heap.pop()
+			q.notFull.Broadcast()
+			return data, nil
+		}
+		if err != nil {
+			var t T
+			return t, err
+		}
+	}
+}
+
+// Peek 返回最早到期的元素但是不出队，不管它是否已经到期，队列为空时返回ErrEmptyQueue
+func (q *DelayQueue[T]) Peek() (T, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.heap.len() == 0 {
+		var t T
+		return t, ErrEmptyQueue
+	}
+	return q.heap.peek(), nil
+}
+
+func (q *DelayQueue[T]) IsFull() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.isFull()
+}
+
+func (q *DelayQueue[T]) isFull() bool {
+	return q.capacity > 0 && q.heap.len() >= q.capacity
+}
+
+func (q *DelayQueue[T]) IsEmpty() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.heap.len() == 0
+}
+
+// Len 队列中的元素个数，包括还没有到期的元素
+func (q *DelayQueue[T]) Len() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return uint64(q.heap.len())
+}
@@ -0,0 +1,154 @@
+package concurrent_queue
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDelayQueue(t *testing.T) {
+	testCases := []struct {
+		name   string
+		delays []time.Duration
+		want   []int
+	}{
+		{
+			name:   "in order",
+			delays: []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond},
+			want:   []int{0, 1, 2},
+		},
+		{
+			name:   "reversed",
+			delays: []time.Duration{30 * time.Millisecond, 20 * time.Millisecond, 10 * time.Millisecond},
+			want:   []int{2, 1, 0},
+		},
+		{
+			name:   "already due",
+			delays: []time.Duration{20 * time.Millisecond, -time.Second, 0},
+			want:   []int{1, 2, 0},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			q := NewDelayQueue[Delayed[int]]()
+			for i, d := range tc.delays {
+				require.NoError(t, q.EnQueue(ctx, NewDelayed(i, d)))
+			}
+			assert.Equal(t, uint64(len(tc.delays)), q.Len())
+
+			var got []int
+			for !q.IsEmpty() {
+				item, err := q.DeQueue(ctx)
+				require.NoError(t, err)
+				// 不会在到期之前出队
+				assert.False(t, time.Now().Before(item.At))
+				got = append(got, item.Value)
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestDelayQueue_Timeout(t *testing.T) {
+	q := NewDelayQueue[Delayed[int]]()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := q.DeQueue(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// 还没有到期的元素不会出队，也不会因为超时被删除
+	require.NoError(t, q.EnQueue(context.Background(), NewDelayed(1, time.Hour)))
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = q.DeQueue(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	item, err := q.Peek()
+	require.NoError(t, err)
+	assert.Equal(t, 1, item.Value)
+}
+
+func TestDelayQueue_WakeUp(t *testing.T) {
+	q := NewDelayQueue[Delayed[string]]()
+	require.NoError(t, q.EnQueue(context.Background(), NewDelayed("late", time.Hour)))
+	res := make(chan string)
+	go func() {
+		item, err := q.DeQueue(context.Background())
+		assert.NoError(t, err)
+		res <- item.Value
+	}()
+
+	// 加入更早到期的元素之后，等待中的DeQueue重新等待新的队头
+	time.Sleep(10 * time.Millisecond)
+	start := time.Now()
+	require.NoError(t, q.EnQueue(context.Background(), NewDelayed("early", 20*time.Millisecond)))
+	select {
+	case val := <-res:
+		assert.Equal(t, "early", val)
+		assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	case <-time.After(time.Second):
+		t.Fatal("没有被唤醒")
+	}
+	assert.Equal(t, uint64(1), q.Len())
+}
+
+func TestDelayQueue_Capacity(t *testing.T) {
+	q := NewDelayQueue[Delayed[int]](DelayQueueWithCapacity[Delayed[int]](1))
+	require.NoError(t, q.EnQueue(context.Background(), NewDelayed(1, 0)))
+	assert.True(t, q.IsFull())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, q.EnQueue(ctx, NewDelayed(2, 0)))
+
+	// 出队之后阻塞中的EnQueue可以继续
+	done := make(chan error)
+	go func() {
+		done <- q.EnQueue(context.Background(), NewDelayed(2, 0))
+	}()
+	item, err := q.DeQueue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, item.Value)
+	require.NoError(t, <-done)
+}
+
+func TestDelayQueue_Concurrent(t *testing.T) {
+	q := NewDelayQueue[Delayed[int]]()
+	const producers, consumers, n = 4, 4, 50
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				assert.NoError(t, q.EnQueue(context.Background(), NewDelayed(p*n+i, time.Duration(i%5)*time.Millisecond)))
+			}
+		}(p)
+	}
+
+	var mu sync.Mutex
+	seen := map[int]struct{}{}
+	for c := 0; c < consumers; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < producers*n/consumers; i++ {
+				item, err := q.DeQueue(context.Background())
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.False(t, time.Now().Before(item.At))
+				mu.Lock()
+				seen[item.Value] = struct{}{}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, seen, producers*n)
+	assert.True(t, q.IsEmpty())
+}
@@ -0,0 +1,540 @@
+package concurrent_queue
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	DefaultSegmentSize = 16 << 20 // 默认单个段文件的大小
+
+	// 每条记录的头部：记录的总长度(4字节)、数据的CRC32校验和(4字节)
+	diskRecordHeaderSize = 8
+	diskSegmentSuffix    = ".seg"
+	diskMetaFile         = "meta"
+	// 元数据文件有两个槽位轮流写入，每个槽位：序号(8字节)、读位置所在的段(8字节)、段内偏移量(8字节)、校验和(4字节)
+	diskMetaSlotSize = 32
+)
+
+var (
+	ErrQueueClosed = errors.New("队列已关闭")
+	// ErrCorruptedSegment 段文件中的记录校验失败，这个段中剩下的元素会被跳过
+	ErrCorruptedSegment = errors.New("段文件已损坏")
+	ErrRecordTooLarge   = errors.New("元素编码之后超过了段文件的大小")
+	// ErrMmapNotSupported 当前平台不支持mmap，DiskQueue只能在unix平台(Linux、macOS、BSD等)上使用
+	ErrMmapNotSupported = errors.New("当前平台不支持mmap")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+var _ Queue[any] = (*DiskQueue[any])(nil)
+
+// diskSegment 内存映射的段文件，文件名是段的编号，编号单调递增
+type diskSegment struct {
+	id   uint64
+	path string
+	file *os.File
+	data []byte
+	// 段中还没有出队的元素个数
+	count int
+}
+
+// header 返回off处的记录的总长度，越界或者还没有写入记录时返回false
+func (s *diskSegment) header(off int64) (int64, bool) {
+	if off < 0 || off+diskRecordHeaderSize > int64(len(s.data)) {
+		return 0, false
+	}
+	size := int64(binary.BigEndian.Uint32(s.data[off:]))
+	return size, size >= diskRecordHeaderSize && off+size <= int64(len(s.data))
+}
+
+// record 返回off处的记录的总长度，记录不完整或者校验和对不上时返回false
+func (s *diskSegment) record(off int64) (int64, bool) {
+	size, ok := s.header(off)
+	if !ok {
+		return 0, false
+	}
+	sum := binary.BigEndian.Uint32(s.data[off+4:])
+	return size, crc32.Checksum(s.data[off+diskRecordHeaderSize:off+size], crcTable) == sum
+}
+
+// scan 从off开始遍历记录，返回最后一条记录的结束位置和记录的条数。
+// verify为true时同时校验数据，遇到第一条校验失败的记录就停下
+func (s *diskSegment) scan(off int64, verify bool) (int64, int) {
+	n := 0
+	for {
+		size, ok := s.header(off)
+		if ok && verify {
+			size, ok = s.record(off)
+		}
+		if !ok {
+			return off, n
+		}
+		off += size
+		n++
+	}
+}
+
+// truncate 截掉段中off之后没有写完的记录，否则之后写入的短记录后面会残留旧的数据
+func (s *diskSegment) truncate(off int64) {
+	if off >= int64(len(s.data)) {
+		return
+	}
+	tail := s.data[off:]
+	for i, b := range tail {
+		if b != 0 {
+			clear(tail[i:])
+			return
+		}
+	}
+}
+
+func (s *diskSegment) close() error {
+	return errors.Join(munmap(s.data), s.file.Close())
+}
+
+// DiskQueue 基于内存映射的段文件的持久化队列，进程重启之后可以继续消费没有出队的元素。
+// 只支持unix平台，其他平台上NewDiskQueue返回ErrMmapNotSupported。
+// 元素通过Codec编码之后追加到最后一个段，写满之后滚动到新的段，消费完的段会被删除。
+// 每条记录带有校验和，重启的时候会截掉最后一个段中没有写完的记录；
+// 读位置记录在元数据文件中，元数据损坏的时候可能会重复消费少量的元素。
+//
+// 队列的容量只受磁盘空间限制，EnQueue不会阻塞；队列为空时DeQueue阻塞，直到有元素入队、ctx结束或者队列关闭。
+// 同一个目录同时只能被一个DiskQueue打开
+type DiskQueue[T any] struct {
+	mu          sync.Mutex
+	dir         string
+	codec       Codec[T]
+	segmentSize int64
+	// 每次入队出队之后都刷盘
+	syncAlways bool
+
+	// segments[0]是正在读的段，最后一个是正在写的段，它们可能是同一个段
+	segments []*diskSegment
+	readOff  int64
+	writeOff int64
+	count    int
+
+	metaFile *os.File
+	meta     []byte
+	metaSeq  uint64
+
+	notEmpty *cond
+	closed   bool
+}
+
+type DiskQueueOption[T any] func(q *DiskQueue[T])
+
+// DiskQueueWithCodec 设置元素的编解码器，默认为JSONCodec
+func DiskQueueWithCodec[T any](codec Codec[T]) DiskQueueOption[T] {
+	return func(q *DiskQueue[T]) {
+		q.codec = codec
+	}
+}
+
+// DiskQueueWithSegmentSize 设置单个段文件的大小，默认为DefaultSegmentSize。
+// 编码之后的元素加上8字节的头部不能超过段的大小
+func DiskQueueWithSegmentSize[T any](size int64) DiskQueueOption[T] {
+	return func(q *DiskQueue[T]) {
+		q.segmentSize = size
+	}
+}
+
+// DiskQueueWithSync 每次入队出队之后都把修改刷到磁盘，机器宕机也不会丢失元素，但是慢很多。
+// 默认交给操作系统决定什么时候刷盘，进程崩溃不会丢失元素，机器宕机会丢
+func DiskQueueWithSync[T any]() DiskQueueOption[T] {
+	return func(q *DiskQueue[T]) {
+		q.syncAlways = true
+	}
+}
+
+// NewDiskQueue 打开dir中的队列，目录不存在时创建。
+// 段文件通过mmap读写，只支持unix平台，Windows等其他平台上直接返回ErrMmapNotSupported
+func NewDiskQueue[T any](dir string, opts ...DiskQueueOption[T]) (*DiskQueue[T], error) {
+	if !mmapSupported {
+		return nil, ErrMmapNotSupported
+	}
+	q := &DiskQueue[T]{
+		dir:         dir,
+		codec:       JSONCodec[T]{},
+		segmentSize: DefaultSegmentSize,
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	if q.segmentSize <= 0 {
+		q.segmentSize = DefaultSegmentSize
+	}
+	q.notEmpty = newCond(&q.mu)
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	if err := q.recover(); err != nil {
+		_ = q.closeFiles()
+		return nil, err
+	}
+	return q, nil
+}
+
+// recover 根据元数据和段文件恢复读写位置
+func (q *DiskQueue[T]) recover() error {
+	segID, off, ok, err := q.openMeta()
+	if err != nil {
+		return err
+	}
+	ids, err := q.listSegments()
+	if err != nil {
+		return err
+	}
+
+	kept := ids[:0]
+	for _, id := range ids {
+		// 读位置之前的段已经消费完了，上次删除之前可能崩溃了
+		if ok && id < segID {
+			if err = os.Remove(q.segmentPath(id)); err != nil {
+				return err
+			}
+			continue
+		}
+		kept = append(kept, id)
+	}
+	if len(kept) == 0 {
+		kept = append(kept, segID)
+	}
+	// 元数据记录的段已经不存在时从最早的段的开头读
+	if !ok || kept[0] != segID {
+		off = 0
+	}
+
+	for i, id := range kept {
+		seg, err := q.openSegment(id)
+		if err != nil {
+			return err
+		}
+		q.segments = append(q.segments, seg)
+		start := int64(0)
+		if i == 0 {
+			start = off
+		}
+		// 只有最后一个段可能有没写完的记录，前面的段在出队的时候校验
+		last := i == len(kept)-1
+		end, n := seg.scan(start, last)
+		seg.count = n
+		q.count += n
+		if last {
+			q.writeOff = end
+			seg.truncate(end)
+		}
+	}
+	q.readOff = off
+	q.compact()
+	return nil
+}
+
+// openMeta 打开元数据文件，返回最近一次记录的读位置，没有有效的记录时返回false
+func (q *DiskQueue[T]) openMeta() (uint64, int64, bool, error) {
+	f, err := os.OpenFile(filepath.Join(q.dir, diskMetaFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	q.metaFile = f
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if info.Size() > 2*diskMetaSlotSize {
+		if err = f.Truncate(2 * diskMetaSlotSize); err != nil {
+			return 0, 0, false, err
+		}
+	}
+	if err = preallocate(f, 2*diskMetaSlotSize); err != nil {
+		return 0, 0, false, err
+	}
+	q.meta, err = mmap(f, 2*diskMetaSlotSize)
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	// 两个槽位轮流写入，写到一半崩溃的时候另一个槽位还是完整的
+	var segID uint64
+	var off int64
+	found := false
+	for i := 0; i < 2; i++ {
+		slot := q.meta[i*diskMetaSlotSize : (i+1)*diskMetaSlotSize]
+		seq := binary.BigEndian.Uint64(slot)
+		if seq == 0 || seq <= q.metaSeq || crc32.Checksum(slot[:24], crcTable) != binary.BigEndian.Uint32(slot[24:]) {
+			continue
+		}
+		q.metaSeq = seq
+		segID = binary.BigEndian.Uint64(slot[8:])
+		off = int64(binary.BigEndian.Uint64(slot[16:]))
+		found = true
+	}
+	return segID, off, found, nil
+}
+
+// writeMeta 记录读位置，调用方需要持有q.mu
+func (q *DiskQueue[T]) writeMeta(segID uint64, off int64) error {
+	q.metaSeq++
+	start := int(q.metaSeq%2) * diskMetaSlotSize
+	slot := q.meta[start : start+diskMetaSlotSize]
+	binary.BigEndian.PutUint64(slot, q.metaSeq)
+	binary.BigEndian.PutUint64(slot[8:], segID)
+	binary.BigEndian.PutUint64(slot[16:], uint64(off))
+	binary.BigEndian.PutUint32(slot[24:], crc32.Checksum(slot[:24], crcTable))
+	if q.syncAlways {
+		return msync(q.meta, start, start+diskMetaSlotSize)
+	}
+	return nil
+}
+
+func (q *DiskQueue[T]) listSegments() ([]uint64, error) {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint64
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), diskSegmentSuffix)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		id, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func (q *DiskQueue[T]) segmentPath(id uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", id, diskSegmentSuffix))
+}
+
+func (q *DiskQueue[T]) openSegment(id uint64) (*diskSegment, error) {
+	path := q.segmentPath(id)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	size := info.Size()
+	// 新建的段，或者创建之后还没来得及设置大小就崩溃了。已有的段保持原来的大小
+	created := size < diskRecordHeaderSize
+	if created {
+		size = q.segmentSize
+	}
+	// 稀疏文件映射之后，磁盘已满时写入内存会触发SIGBUS，映射之前先分配好磁盘块
+	if err = preallocate(f, size); err != nil {
+		_ = f.Close()
+		if created {
+			_ = os.Remove(path)
+		}
+		return nil, err
+	}
+	data, err := mmap(f, int(size))
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &diskSegment{id: id, path: path, file: f, data: data}, nil
+}
+
+// EnQueue 元素编码之后追加到磁盘，不会阻塞
+func (q *DiskQueue[T]) EnQueue(ctx context.Context, data T) error {
+	payload, err := q.codec.Encode(data)
+	if err != nil {
+		return err
+	}
+	size := int64(diskRecordHeaderSize + len(payload))
+	if size > q.segmentSize {
+		return ErrRecordTooLarge
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+	seg := q.segments[len(q.segments)-1]
+	if q.writeOff+size > int64(len(seg.data)) {
+		if seg, err = q.roll(); err != nil {
+			return err
+		}
+	}
+
+	rec := seg.data[q.writeOff : q.writeOff+size]
+	copy(rec[diskRecordHeaderSize:], payload)
+	binary.BigEndian.PutUint32(rec[4:], crc32.Checksum(payload, crcTable))
+	// 长度最后写入，没有写完的记录长度为0或者校验和对不上，重启的时候会被截掉
+	binary.BigEndian.PutUint32(rec, uint32(size))
+	if q.syncAlways {
+		if err = msync(seg.data, int(q.writeOff), int(q.writeOff+size)); err != nil {
+			return err
+		}
+	}
+	q.writeOff += size
+	seg.count++
+	q.count++
+	q.notEmpty.Broadcast()
+	return nil
+}
+
+// roll 创建新的段用于写入，调用方需要持有q.mu
+func (q *DiskQueue[T]) roll() (*diskSegment, error) {
+	last := q.segments[len(q.segments)-1]
+	seg, err := q.openSegment(last.id + 1)
+	if err != nil {
+		return nil, err
+	}
+	q.segments = append(q.segments, seg)
+	q.writeOff = 0
+	// 旧的段可能已经消费完了
+	q.compact()
+	return seg, nil
+}
+
+// DeQueue 最早入队的元素出队，队列为空时阻塞到有元素入队，ctx结束时返回ctx.Err()。
+// 返回ErrCorruptedSegment或者解码失败时对应的元素已经出队，可以继续调用DeQueue
+func (q *DiskQueue[T]) DeQueue(ctx context.Context) (T, error) {
+	var t T
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.count == 0 && !q.closed {
+		if err := q.notEmpty.Wait(ctx); err != nil {
+			return t, err
+		}
+	}
+	if q.closed {
+		return t, ErrQueueClosed
+	}
+
+	payload, err := q.read()
+	if err != nil {
+		return t, err
+	}
+	return q.codec.Decode(payload)
+}
+
+// read 读出正在读的段中的下一条记录，调用方需要持有q.mu，并且保证队列不为空
+func (q *DiskQueue[T]) read() ([]byte, error) {
+	seg := q.segments[0]
+	size, ok := seg.record(q.readOff)
+	if !ok {
+		// 记录的长度也不可信，段中剩下的元素都读不出来了
+		q.count -= seg.count
+		seg.count = 0
+		if len(q.segments) == 1 {
+			q.readOff = q.writeOff
+		}
+		err := q.writeMeta(seg.id, q.readOff)
+		q.compact()
+		return nil, errors.Join(fmt.Errorf("%w: %s", ErrCorruptedSegment, seg.path), err)
+	}
+
+	// 复制一份，段被删除之后映射的内存就不能访问了
+	payload := bytes.Clone(seg.data[q.readOff+diskRecordHeaderSize : q.readOff+size])
+	q.readOff += size
+	seg.count--
+	q.count--
+	if err := q.writeMeta(seg.id, q.readOff); err != nil {
+		return nil, err
+	}
+	q.compact()
+	return payload, nil
+}
+
+// compact 删除已经消费完的段，正在写的段不会删除，调用方需要持有q.mu
+func (q *DiskQueue[T]) compact() {
+	for len(q.segments) > 1 && q.segments[0].count == 0 {
+		seg := q.segments[0]
+		q.segments = q.segments[1:]
+		q.readOff = 0
+		// 先记录新的读位置再删除，删除失败的话重启时会根据元数据重新删除
+		if err := q.writeMeta(q.segments[0].id, 0); err != nil {
+			return
+		}
+		_ = seg.close()
+		_ = os.Remove(seg.path)
+	}
+}
+
+func (q *DiskQueue[T]) IsFull() bool {
+	return false
+}
+
+func (q *DiskQueue[T]) IsEmpty() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.count == 0
+}
+
+func (q *DiskQueue[T]) Len() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return uint64(q.count)
+}
+
+// Sync 把所有的修改刷到磁盘
+func (q *DiskQueue[T]) Sync() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+	return q.sync()
+}
+
+func (q *DiskQueue[T]) sync() error {
+	var errs []error
+	for _, seg := range q.segments {
+		errs = append(errs, msync(seg.data, 0, len(seg.data)))
+	}
+	errs = append(errs, msync(q.meta, 0, len(q.meta)))
+	return errors.Join(errs...)
+}
+
+// Close 刷盘并且关闭所有的文件，阻塞在DeQueue上的调用方返回ErrQueueClosed
+func (q *DiskQueue[T]) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	q.notEmpty.Broadcast()
+	return errors.Join(q.sync(), q.closeFiles())
+}
+
+func (q *DiskQueue[T]) closeFiles() error {
+	var errs []error
+	for _, seg := range q.segments {
+		errs = append(errs, seg.close())
+	}
+	q.segments = nil
+	if q.meta != nil {
+		errs = append(errs, munmap(q.meta))
+		q.meta = nil
+	}
+	if q.metaFile != nil {
+		errs = append(errs, q.metaFile.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,322 @@
+//go:build unix
+
+package concurrent_queue
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// 单个数字的"msg-i"编码之后是7个字节，加上头部每条记录15个字节，32字节的段正好放两条
+const testRecordSize = 15
+
+func openDiskQueue(t *testing.T, dir string, opts ...DiskQueueOption[string]) *DiskQueue[string] {
+	q, err := NewDiskQueue[string](dir, append([]DiskQueueOption[string]{DiskQueueWithSegmentSize[string](32)}, opts...)...)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = q.Close()
+	})
+	return q
+}
+
+func enqueueN(t *testing.T, q Queue[string], from, n int) {
+	for i := from; i < from+n; i++ {
+		require.NoError(t, q.EnQueue(context.Background(), fmt.Sprintf("msg-%d", i)))
+	}
+}
+
+func dequeueN(t *testing.T, q Queue[string], n int) []string {
+	res := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		val, err := q.DeQueue(context.Background())
+		require.NoError(t, err)
+		res = append(res, val)
+	}
+	return res
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+diskSegmentSuffix))
+	require.NoError(t, err)
+	return files
+}
+
+func TestDiskQueue(t *testing.T) {
+	dir := t.TempDir()
+	q := openDiskQueue(t, dir)
+	enqueueN(t, q, 0, 5)
+	assert.Equal(t, uint64(5), q.Len())
+	assert.False(t, q.IsFull())
+	assert.Len(t, segmentFiles(t, dir), 3)
+
+	// 消费完的段会被删除
+	assert.Equal(t, []string{"msg-0", "msg-1", "msg-2"}, dequeueN(t, q, 3))
+	assert.Len(t, segmentFiles(t, dir), 2)
+	assert.Equal(t, []string{"msg-3", "msg-4"}, dequeueN(t, q, 2))
+	assert.Len(t, segmentFiles(t, dir), 1)
+	assert.True(t, q.IsEmpty())
+
+	// 写满的段消费完之后滚动的时候删除
+	enqueueN(t, q, 5, 2)
+	assert.Equal(t, []string{"msg-5", "msg-6"}, dequeueN(t, q, 2))
+	enqueueN(t, q, 7, 1)
+	assert.Len(t, segmentFiles(t, dir), 1)
+	assert.Equal(t, []string{"msg-7"}, dequeueN(t, q, 1))
+
+	assert.Equal(t, ErrRecordTooLarge, q.EnQueue(context.Background(), "a message larger than a segment"))
+}
+
+func TestDiskQueue_Restart(t *testing.T) {
+	dir := t.TempDir()
+	q := openDiskQueue(t, dir)
+	enqueueN(t, q, 0, 5)
+	assert.Equal(t, []string{"msg-0", "msg-1", "msg-2"}, dequeueN(t, q, 3))
+	require.NoError(t, q.Close())
+	assert.Equal(t, ErrQueueClosed, q.EnQueue(context.Background(), "msg"))
+	_, err := q.DeQueue(context.Background())
+	assert.Equal(t, ErrQueueClosed, err)
+
+	// 重启之后从上次的读位置继续
+	q = openDiskQueue(t, dir, DiskQueueWithSync[string]())
+	assert.Equal(t, uint64(2), q.Len())
+	enqueueN(t, q, 5, 1)
+	assert.Equal(t, []string{"msg-3", "msg-4", "msg-5"}, dequeueN(t, q, 3))
+	require.NoError(t, q.Close())
+
+	q = openDiskQueue(t, dir)
+	assert.True(t, q.IsEmpty())
+	assert.Len(t, segmentFiles(t, dir), 1)
+}
+
+// corrupt 在关闭的队列的段文件中写入数据，模拟崩溃或者磁盘损坏
+func corrupt(t *testing.T, path string, off int64, data []byte) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteAt(data, off)
+	require.NoError(t, err)
+}
+
+func TestDiskQueue_Recover(t *testing.T) {
+	header := make([]byte, diskRecordHeaderSize)
+	binary.BigEndian.PutUint32(header, testRecordSize)
+
+	testCases := []struct {
+		name string
+		// 写入第一个段的位置和数据，段中已经有两条记录
+		off  int64
+		data []byte
+
+		wantLen uint64
+	}{
+		{
+			name:    "intact",
+			wantLen: 2,
+		},
+		{
+			name:    "torn payload",
+			off:     testRecordSize + diskRecordHeaderSize + 1,
+			data:    []byte{'x'},
+			wantLen: 1,
+		},
+		{
+			name:    "torn checksum",
+			off:     testRecordSize + 4,
+			data:    []byte{0xff},
+			wantLen: 1,
+		},
+		{
+			name:    "header without payload",
+			off:     2 * testRecordSize,
+			data:    header,
+			wantLen: 2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			q := openDiskQueue(t, dir, DiskQueueWithSegmentSize[string](64))
+			enqueueN(t, q, 0, 2)
+			require.NoError(t, q.Close())
+			files := segmentFiles(t, dir)
+			require.Len(t, files, 1)
+			if tc.data != nil {
+				corrupt(t, files[0], tc.off, tc.data)
+			}
+
+			// 没有写完的记录被截掉，之后的写入覆盖它
+			q = openDiskQueue(t, dir, DiskQueueWithSegmentSize[string](64))
+			assert.Equal(t, tc.wantLen, q.Len())
+			enqueueN(t, q, 5, 1)
+			require.NoError(t, q.Close())
+
+			q = openDiskQueue(t, dir, DiskQueueWithSegmentSize[string](64))
+			require.Equal(t, tc.wantLen+1, q.Len())
+			want := []string{"msg-0", "msg-1"}[:tc.wantLen]
+			assert.Equal(t, append(want, "msg-5"), dequeueN(t, q, int(tc.wantLen)+1))
+		})
+	}
+}
+
+func TestDiskQueue_CorruptedSegment(t *testing.T) {
+	dir := t.TempDir()
+	q := openDiskQueue(t, dir)
+	enqueueN(t, q, 0, 5)
+	require.NoError(t, q.Close())
+	// 已经写满的段中的记录损坏，出队的时候才能发现
+	corrupt(t, segmentFiles(t, dir)[0], testRecordSize+diskRecordHeaderSize, []byte{'x'})
+
+	q = openDiskQueue(t, dir)
+	assert.Equal(t, uint64(5), q.Len())
+	assert.Equal(t, []string{"msg-0"}, dequeueN(t, q, 1))
+	_, err := q.DeQueue(context.Background())
+	assert.ErrorIs(t, err, ErrCorruptedSegment)
+	// 跳过损坏的段继续消费
+	assert.Equal(t, uint64(3), q.Len())
+	assert.Equal(t, []string{"msg-2", "msg-3", "msg-4"}, dequeueN(t, q, 3))
+}
+
+func TestDiskQueue_CorruptedMeta(t *testing.T) {
+	dir := t.TempDir()
+	q := openDiskQueue(t, dir, DiskQueueWithSegmentSize[string](64))
+	enqueueN(t, q, 0, 3)
+	assert.Equal(t, []string{"msg-0", "msg-1"}, dequeueN(t, q, 2))
+	require.NoError(t, q.Close())
+	// 最新的读位置写在第一个槽位，写到一半崩溃的时候使用另一个槽位中的旧位置
+	corrupt(t, filepath.Join(dir, diskMetaFile), 20, []byte{0xff})
+
+	q = openDiskQueue(t, dir, DiskQueueWithSegmentSize[string](64))
+	assert.Equal(t, []string{"msg-1", "msg-2"}, dequeueN(t, q, 2))
+}
+
+// allocated 文件实际分配的磁盘空间，稀疏文件中没有写过的部分不占用空间
+func allocated(t *testing.T, path string) int64 {
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	return info.Sys().(*syscall.Stat_t).Blocks * 512
+}
+
+func TestDiskQueue_Preallocate(t *testing.T) {
+	dir := t.TempDir()
+	q := openDiskQueue(t, dir, DiskQueueWithSegmentSize[string](1<<20))
+	enqueueN(t, q, 0, 1)
+
+	// 段和元数据文件映射之前已经分配了磁盘块，磁盘已满时在打开的时候返回错误而不是写入时触发SIGBUS
+	files := append(segmentFiles(t, dir), filepath.Join(dir, diskMetaFile))
+	require.Len(t, files, 2)
+	for _, file := range files {
+		info, err := os.Stat(file)
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, allocated(t, file), info.Size(), file)
+	}
+}
+
+func TestWriteZeros(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zeros")
+	require.NoError(t, os.WriteFile(path, []byte("data"), 0o644))
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	require.NoError(t, err)
+	defer f.Close()
+
+	// 只扩展文件，不修改已有的数据
+	require.NoError(t, writeZeros(f, 1<<20))
+	require.NoError(t, writeZeros(f, 10))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Len(t, data, 1<<20)
+	assert.Equal(t, "data", string(data[:4]))
+	assert.GreaterOrEqual(t, allocated(t, path), int64(1<<20))
+}
+
+type intCodec struct{}
+
+func (intCodec) Encode(val int) ([]byte, error) {
+	return []byte(strconv.Itoa(val)), nil
+}
+
+func (intCodec) Decode(data []byte) (int, error) {
+	return strconv.Atoi(string(data))
+}
+
+func TestDiskQueue_Concurrent(t *testing.T) {
+	q, err := NewDiskQueue[int](t.TempDir(), DiskQueueWithCodec[int](intCodec{}), DiskQueueWithSegmentSize[int](256))
+	require.NoError(t, err)
+	defer q.Close()
+
+	const producers, consumers, n = 4, 4, 200
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				assert.NoError(t, q.EnQueue(context.Background(), p*n+i))
+			}
+		}(p)
+	}
+
+	var mu sync.Mutex
+	var got []int
+	var cwg sync.WaitGroup
+	for c := 0; c < consumers; c++ {
+		cwg.Add(1)
+		go func() {
+			defer cwg.Done()
+			for i := 0; i < producers*n/consumers; i++ {
+				val, err := q.DeQueue(context.Background())
+				if !assert.NoError(t, err) {
+					return
+				}
+				mu.Lock()
+				got = append(got, val)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	cwg.Wait()
+
+	sort.Ints(got)
+	want := make([]int, producers*n)
+	for i := range want {
+		want[i] = i
+	}
+	assert.Equal(t, want, got)
+	assert.True(t, q.IsEmpty())
+}
+
+func TestDiskQueue_Blocking(t *testing.T) {
+	q := openDiskQueue(t, t.TempDir())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := q.DeQueue(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// 入队唤醒等待的DeQueue
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		assert.NoError(t, q.EnQueue(context.Background(), "msg-0"))
+	}()
+	assert.Equal(t, []string{"msg-0"}, dequeueN(t, q, 1))
+
+	// 关闭唤醒等待的DeQueue
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		assert.NoError(t, q.Close())
+	}()
+	_, err = q.DeQueue(context.Background())
+	assert.Equal(t, ErrQueueClosed, err)
+}
@@ -0,0 +1,76 @@
+package concurrent_queue
+
+// binaryHeap 二叉堆，compare认为排在前面的元素在堆顶，不是并发安全的
+type binaryHeap[T any] struct {
+	data    []T
+	compare Comparator[T]
+}
+
+func newBinaryHeap[T any](compare Comparator[T], capacity int) binaryHeap[T] {
+	return binaryHeap[T]{
+		data:    make([]T, 0, max(capacity, 0)),
+		compare: compare,
+	}
+}
+
+func (h *binaryHeap[T]) len() int {
+	return len(h.data)
+}
+
+// push 加入一个元素，返回它是否成为了堆顶
+func (h *binaryHeap[T]) push(val T) bool {
+	h.data = append(h.data, val)
+	return h.up(len(h.data)-1) == 0
+}
+
+// peek 返回堆顶的元素，调用方需要保证堆不为空
+func (h *binaryHeap[T]) peek() T {
+	return h.data[0]
+}
+
+// pop 取出堆顶的元素，调用方需要保证堆不为空
+func (h *binaryHeap[T]) pop() T {
+	n := len(h.data) - 1
+	top := h.data[0]
+	h.data[0] = h.data[n]
+	// 清空引用，让出队的元素可以被回收
+	var zero T
+	h.data[n] = zero
+	h.data = h.data[:n]
+	if n > 0 {
+		h.down(0)
+	}
+	return top
+}
+
+// up 把下标为i的元素向上调整到合适的位置，返回调整之后的下标
+func (h *binaryHeap[T]) up(i int) int {
+	for i > 0 {
+		parent := (i - 1) / 2
+		if h.compare(h.data[i], h.data[parent]) >= 0 {
+			return i
+		}
+		h.data[i], h.data[parent] = h.data[parent], h.data[i]
+		i = parent
+	}
+	return i
+}
+
+// down 把下标为i的元素向下调整到合适的位置
+func (h *binaryHeap[T]) down(i int) {
+	n := len(h.data)
+	for {
+		first := i
+		if left := 2*i + 1; left < n && h.compare(h.data[left], h.data[first]) < 0 {
+			first = left
+		}
+		if right := 2*i + 2; right < n && h.compare(h.data[right], h.data[first]) < 0 {
+			first = right
+		}
+		if first == i {
+			return
+		}
+		h.data[i], h.data[first] = h.data[first], h.data[i]
+		i = first
+	}
+}
@@ -0,0 +1,96 @@
+package concurrent_queue
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+var _ Queue[any] = (*LinkedQueue[any])(nil)
+
+type linkedNode[T any] struct {
+	val  T
+	next atomic.Pointer[linkedNode[T]]
+}
+
+// LinkedQueue Michael–Scott无锁链表队列，没有容量限制，并发安全。
+// 无锁队列不会阻塞，也不检查ctx：队列为空时DeQueue直接返回ErrEmptyQueue，需要等待时由调用方重试
+type LinkedQueue[T any] struct {
+	// head指向哨兵节点，哨兵节点的下一个节点是队头
+	head atomic.Pointer[linkedNode[T]]
+	// tail指向队尾或者队尾的前一个节点，落后的时候由其他goroutine帮忙推进
+	tail  atomic.Pointer[linkedNode[T]]
+	count atomic.Int64
+}
+
+func NewLinkedQueue[T any]() *LinkedQueue[T] {
+	q := &LinkedQueue[T]{}
+	sentinel := &linkedNode[T]{}
+	q.head.Store(sentinel)
+	q.tail.Store(sentinel)
+	return q
+}
+
+// EnQueue 元素入队，永远不会失败
+func (q *LinkedQueue[T]) EnQueue(ctx context.Context, data T) error {
+	n := &linkedNode[T]{val: data}
+	for {
+		tail := q.tail.Load()
+		next := tail.next.Load()
+		if tail != q.tail.Load() {
+			continue
+		}
+		if next != nil {
+			// 别的goroutine已经链上了新节点，但是还没有推进tail，帮它推进
+			q.tail.CompareAndSwap(tail, next)
+			continue
+		}
+		if tail.next.CompareAndSwap(nil, n) {
+			// 失败说明别的goroutine已经帮忙推进了
+			q.tail.CompareAndSwap(tail, n)
+			q.count.Add(1)
+			return nil
+		}
+	}
+}
+
+// DeQueue 队头的元素出队，队列为空时返回ErrEmptyQueue
+func (q *LinkedQueue[T]) DeQueue(ctx context.Context) (T, error) {
+	for {
+		head := q.head.Load()
+		tail := q.tail.Load()
+		next := head.next.Load()
+		if head != q.head.Load() {
+			continue
+		}
+		if next == nil {
+			var t T
+			return t, ErrEmptyQueue
+		}
+		if head == tail {
+			// tail落后了，先推进tail，避免head越过tail
+			q.tail.CompareAndSwap(tail, next)
+			continue
+		}
+		// 需要在CAS之前读取，CAS成功之后next成为新的哨兵节点。
+		// 其他goroutine可能还在读取它的值，所以不能清空，它的值要等到下一次出队之后才能被回收
+		val := next.val
+		if q.head.CompareAndSwap(head, next) {
+			q.count.Add(-1)
+			return val, nil
+		}
+	}
+}
+
+// IsFull 没有容量限制，永远返回false
+func (q *LinkedQueue[T]) IsFull() bool {
+	return false
+}
+
+func (q *LinkedQueue[T]) IsEmpty() bool {
+	return q.head.Load().next.Load() == nil
+}
+
+// Len 队列的长度，并发修改的时候是一个近似值
+func (q *LinkedQueue[T]) Len() uint64 {
+	return uint64(max(q.count.Load(), 0))
+}
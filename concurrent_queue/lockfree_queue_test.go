@@ -0,0 +1,132 @@
+package concurrent_queue
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockFreeQueue(t *testing.T) {
+	testCases := []struct {
+		name string
+		q    Queue[int]
+		// 超过容量之后入队的结果
+		wantFull error
+	}{
+		{name: "linked", q: NewLinkedQueue[int]()},
+		{name: "ring", q: NewRingQueue[int](3), wantFull: ErrFullQueue},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			q := tc.q
+			assert.True(t, q.IsEmpty())
+			_, err := q.DeQueue(ctx)
+			assert.Equal(t, ErrEmptyQueue, err)
+
+			// 反复入队出队，环形队列会绕过缓冲区的末尾
+			for round := 0; round < 3; round++ {
+				for i := 0; i < 4; i++ {
+					require.NoError(t, q.EnQueue(ctx, round*4+i))
+				}
+				assert.Equal(t, uint64(4), q.Len())
+				assert.Equal(t, tc.wantFull != nil, q.IsFull())
+				assert.Equal(t, tc.wantFull, q.EnQueue(ctx, -1))
+				for i := 0; i < 4; i++ {
+					val, err := q.DeQueue(ctx)
+					require.NoError(t, err)
+					assert.Equal(t, round*4+i, val)
+				}
+				if tc.wantFull == nil {
+					// 没有容量限制的队列中多入队的一条
+					val, err := q.DeQueue(ctx)
+					require.NoError(t, err)
+					assert.Equal(t, -1, val)
+				}
+				assert.True(t, q.IsEmpty())
+			}
+		})
+	}
+}
+
+func TestRingQueue_Cap(t *testing.T) {
+	assert.Equal(t, uint64(4), NewRingQueue[int](3).Cap())
+	assert.Equal(t, uint64(8), NewRingQueue[int](8).Cap())
+	assert.Equal(t, uint64(DefaultCapacity), NewRingQueue[int](0).Cap())
+}
+
+func TestLockFreeQueue_Concurrent(t *testing.T) {
+	testCases := []struct {
+		name string
+		q    Queue[int]
+	}{
+		{name: "linked", q: NewLinkedQueue[int]()},
+		{name: "ring", q: NewRingQueue[int](8)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			const producers, consumers, n = 4, 4, 2000
+			var wg sync.WaitGroup
+			for p := 0; p < producers; p++ {
+				wg.Add(1)
+				go func(p int) {
+					defer wg.Done()
+					for i := 0; i < n; i++ {
+						enqueue(tc.q, p*n+i)
+					}
+				}(p)
+			}
+
+			results := make([][]int, consumers)
+			for c := 0; c < consumers; c++ {
+				wg.Add(1)
+				go func(c int) {
+					defer wg.Done()
+					for i := 0; i < producers*n/consumers; i++ {
+						results[c] = append(results[c], dequeue(tc.q))
+					}
+				}(c)
+			}
+			wg.Wait()
+
+			// 不丢失、不重复，同一个生产者的元素在每个消费者中保持入队的顺序
+			seen := make([]bool, producers*n)
+			for _, res := range results {
+				last := map[int]int{}
+				for _, val := range res {
+					require.False(t, seen[val])
+					seen[val] = true
+					if prev, ok := last[val/n]; ok {
+						assert.Greater(t, val, prev)
+					}
+					last[val/n] = val
+				}
+			}
+			assert.True(t, tc.q.IsEmpty())
+		})
+	}
+}
+
+// enqueue 无锁队列已满时不会阻塞，重试到成功为止
+func enqueue(q Queue[int], val int) {
+	for q.EnQueue(context.Background(), val) != nil {
+		runtime.Gosched()
+	}
+}
+
+// dequeue 无锁队列为空时不会阻塞，重试到成功为止
+func dequeue(q Queue[int]) int {
+	for {
+		val, err := q.DeQueue(context.Background())
+		if err == nil {
+			return val
+		}
+		runtime.Gosched()
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !unix
+
+package concurrent_queue
+
+import "os"
+
+// mmapSupported 其他平台没有mmap，NewDiskQueue直接返回ErrMmapNotSupported
+const mmapSupported = false
+
+func mmap(f *os.File, size int) ([]byte, error) {
+	return nil, ErrMmapNotSupported
+}
+
+func munmap(data []byte) error {
+	return ErrMmapNotSupported
+}
+
+func msync(data []byte, off, end int) error {
+	return ErrMmapNotSupported
+}
+
+func preallocate(f *os.File, size int64) error {
+	return ErrMmapNotSupported
+}
@@ -0,0 +1,50 @@
+//go:build unix
+
+package concurrent_queue
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+var pageSize = os.Getpagesize()
+
+// mmapSupported 当前平台是否支持mmap，不支持时NewDiskQueue直接返回ErrMmapNotSupported
+const mmapSupported = true
+
+// mmap 把文件的前size个字节映射到内存，对映射区域的修改会写回文件
+func mmap(f *os.File, size int) ([]byte, error) {
+	return unix.Mmap(int(f.Fd()), 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+}
+
+func munmap(data []byte) error {
+	return unix.Munmap(data)
+}
+
+// msync 把data[off:end]所在的页刷到磁盘，msync要求起始地址按页对齐
+func msync(data []byte, off, end int) error {
+	return unix.Msync(data[off&^(pageSize-1):end], unix.MS_SYNC)
+}
+
+// writeZeros 从文件的末尾开始写零，把文件扩展到size。和Truncate不同，写入的部分会真正分配磁盘块，
+// 映射之后写入内存时不会因为磁盘已满触发SIGBUS，磁盘已满时在这里返回错误
+func writeZeros(f *os.File, size int64) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	off := info.Size()
+	if off >= size {
+		return nil
+	}
+	zeros := make([]byte, min(size-off, int64(pageSize)*16))
+	for off < size {
+		n, err := f.WriteAt(zeros[:min(size-off, int64(len(zeros)))], off)
+		if err != nil {
+			return err
+		}
+		off += int64(n)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build linux
+
+package concurrent_queue
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// preallocate 通过fallocate为文件的前size个字节分配磁盘块，不会修改已有的数据，
+// 文件系统不支持fallocate时退化为写零
+func preallocate(f *os.File, size int64) error {
+	err := unix.Fallocate(int(f.Fd()), 0, 0, size)
+	if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.ENOSYS) {
+		return writeZeros(f, size)
+	}
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build unix && !linux
+
+package concurrent_queue
+
+import "os"
+
+// preallocate 写零把文件扩展到size，为扩展的部分分配磁盘块
+func preallocate(f *os.File, size int64) error {
+	return writeZeros(f, size)
+}
@@ -0,0 +1,166 @@
+package concurrent_queue
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var _ BatchQueue[any] = (*PriorityQueue[any])(nil)
+
+// PriorityQueue 基于二叉堆的并发安全的优先级队列，compare认为排在前面的元素先出队。
+// 队列为空时DeQueue阻塞，设置了容量并且队列已满时EnQueue阻塞，直到条件满足或者ctx结束
+type PriorityQueue[T any] struct {
+	mu   sync.Mutex
+	heap binaryHeap[T]
+	// 容量，小于等于0表示不限制
+	capacity int
+	notFull  *cond
+	notEmpty *cond
+}
+
+type PriorityQueueOption[T any] func(q *PriorityQueue[T])
+
+// PriorityQueueWithCapacity 设置队列的容量，默认不限制
+func PriorityQueueWithCapacity[T any](capacity int) PriorityQueueOption[T] {
+	return func(q *PriorityQueue[T]) {
+		q.capacity = capacity
+	}
+}
+
+func NewPriorityQueue[T any](compare Comparator[T], opts ...PriorityQueueOption[T]) *PriorityQueue[T] {
+	q := &PriorityQueue[T]{}
+	for _, opt := range opts {
+		opt(q)
+	}
+	q.heap = newBinaryHeap(compare, q.capacity)
+	q.notFull = newCond(&q.mu)
+	q.notEmpty = newCond(&q.mu)
+	return q
+}
+
+// EnQueue 元素入队，队列已满时阻塞到有空位，ctx结束时返回ctx.Err()
+func (q *PriorityQueue[T]) EnQueue(ctx context.Context, data T) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.isFull() {
+		if err := q.notFull.Wait(ctx); err != nil {
+			return err
+		}
+	}
+
+	q.heap.push(data)
+	q.notEmpty.Broadcast()
+	return nil
+}
+
+// DeQueue 优先级最高的元素出队，队列为空时阻塞到有元素入队，ctx结束时返回ctx.Err()
+func (q *PriorityQueue[T]) DeQueue(ctx context.Context) (T, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.heap.len() == 0 {
+		if err := q.notEmpty.Wait(ctx); err != nil {
+			var t T
+			return t, err
+		}
+	}
+
+	data := q.heap.pop()
+	q.notFull.Broadcast()
+	return data, nil
+}
+
+// EnQueueBatch 批量入队，设置了容量并且空位不足时阻塞到所有元素都能放下，元素个数超过容量时返回ErrBatchTooLarge
+func (q *PriorityQueue[T]) EnQueueBatch(ctx context.Context, data []T) error {
+	if q.capacity > 0 && len(data) > q.capacity {
+		return ErrBatchTooLarge
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.capacity > 0 && q.heap.len()+len(data) > q.capacity {
+		if err := q.notFull.Wait(ctx); err != nil {
+			return err
+		}
+	}
+
+	for _, val := range data {
+		q.heap.push(val)
+	}
+	if len(data) > 0 {
+		q.notEmpty.Broadcast()
+	}
+	return nil
+}
+
+// DeQueueBatch 按照优先级批量出队，队列中的元素不足limit个时最多等待maxWait
+func (q *PriorityQueue[T]) DeQueueBatch(ctx context.Context, limit int, maxWait time.Duration) ([]T, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	deadline := time.Now().Add(maxWait)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.heap.len() < limit {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			break
+		}
+		if err := q.notEmpty.WaitTimeout(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+	return q.take(min(q.heap.len(), limit)), nil
+}
+
+// Drain 按照优先级取出所有的元素
+func (q *PriorityQueue[T]) Drain() []T {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.take(q.heap.len())
+}
+
+// take 取出优先级最高的n个元素，调用方需要持有q.mu
+func (q *PriorityQueue[T]) take(n int) []T {
+	if n == 0 {
+		return nil
+	}
+	res := make([]T, n)
+	for i := range res {
+		res[i] = q.heap.pop()
+	}
+	q.notFull.Broadcast()
+	return res
+}
+
+// Peek 返回优先级最高的元素但是不出队，队列为空时返回ErrEmptyQueue
+func (q *PriorityQueue[T]) Peek() (T, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.heap.len() == 0 {
+		var t T
+		return t, ErrEmptyQueue
+	}
+	return q.heap.peek(), nil
+}
+
+func (q *PriorityQueue[T]) IsFull() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.isFull()
+}
+
+func (q *PriorityQueue[T]) isFull() bool {
+	return q.capacity > 0 && q.heap.len() >= q.capacity
+}
+
+func (q *PriorityQueue[T]) IsEmpty() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.heap.len() == 0
+}
+
+func (q *PriorityQueue[T]) Len() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return uint64(q.heap.len())
+}
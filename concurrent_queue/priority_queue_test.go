@@ -0,0 +1,160 @@
+package concurrent_queue
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func compareInt(src, dst int) int {
+	return src - dst
+}
+
+type job struct {
+	name     string
+	priority int
+}
+
+func TestPriorityQueue(t *testing.T) {
+	testCases := []struct {
+		name    string
+		compare Comparator[job]
+		input   []job
+		want    []string
+	}{
+		{
+			name: "min first",
+			compare: func(src, dst job) int {
+				return src.priority - dst.priority
+			},
+			input: []job{{"c", 3}, {"a", 1}, {"d", 4}, {"b", 2}},
+			want:  []string{"a", "b", "c", "d"},
+		},
+		{
+			name: "max first",
+			compare: func(src, dst job) int {
+				return dst.priority - src.priority
+			},
+			input: []job{{"c", 3}, {"a", 1}, {"d", 4}, {"b", 2}},
+			want:  []string{"d", "c", "b", "a"},
+		},
+		{
+			name:    "empty",
+			compare: func(src, dst job) int { return 0 },
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			q := NewPriorityQueue[job](tc.compare)
+			for _, j := range tc.input {
+				require.NoError(t, q.EnQueue(ctx, j))
+			}
+			assert.Equal(t, uint64(len(tc.input)), q.Len())
+			assert.False(t, q.IsFull())
+
+			var got []string
+			for !q.IsEmpty() {
+				j, err := q.DeQueue(ctx)
+				require.NoError(t, err)
+				got = append(got, j.name)
+			}
+			assert.Equal(t, tc.want, got)
+			_, err := q.Peek()
+			assert.Equal(t, ErrEmptyQueue, err)
+		})
+	}
+}
+
+func TestPriorityQueue_Random(t *testing.T) {
+	ctx := context.Background()
+	q := NewPriorityQueue[int](compareInt)
+	input := rand.Perm(1000)
+	for _, v := range input {
+		require.NoError(t, q.EnQueue(ctx, v))
+	}
+	top, err := q.Peek()
+	require.NoError(t, err)
+	assert.Equal(t, 0, top)
+	sort.Ints(input)
+	for _, want := range input {
+		val, err := q.DeQueue(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, want, val)
+	}
+}
+
+func TestPriorityQueue_Blocking(t *testing.T) {
+	q := NewPriorityQueue[int](compareInt, PriorityQueueWithCapacity[int](2))
+
+	// 队列为空时DeQueue阻塞到ctx结束
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := q.DeQueue(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// 队列已满时EnQueue阻塞到ctx结束
+	require.NoError(t, q.EnQueue(context.Background(), 2))
+	require.NoError(t, q.EnQueue(context.Background(), 1))
+	assert.True(t, q.IsFull())
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, q.EnQueue(ctx, 3))
+
+	// 出队之后阻塞中的EnQueue可以继续
+	done := make(chan error)
+	go func() {
+		done <- q.EnQueue(context.Background(), 0)
+	}()
+	val, err := q.DeQueue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, val)
+	require.NoError(t, <-done)
+	for _, want := range []int{0, 2} {
+		val, err = q.DeQueue(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, want, val)
+	}
+
+	// 入队之后阻塞中的DeQueue可以继续
+	res := make(chan int)
+	go func() {
+		val, _ := q.DeQueue(context.Background())
+		res <- val
+	}()
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, q.EnQueue(context.Background(), 5))
+	assert.Equal(t, 5, <-res)
+}
+
+func TestPriorityQueue_Concurrent(t *testing.T) {
+	q := NewPriorityQueue[int](compareInt, PriorityQueueWithCapacity[int](8))
+	const producers, n = 4, 250
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				assert.NoError(t, q.EnQueue(context.Background(), p*n+i))
+			}
+		}(p)
+	}
+
+	seen := make([]bool, producers*n)
+	for i := 0; i < producers*n; i++ {
+		val, err := q.DeQueue(context.Background())
+		require.NoError(t, err)
+		assert.False(t, seen[val])
+		seen[val] = true
+	}
+	wg.Wait()
+	assert.True(t, q.IsEmpty())
+}
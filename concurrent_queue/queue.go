@@ -2,51 +2,163 @@ package concurrent_queue
 
 import (
 	"context"
-	"errors"
 	"sync"
+	"time"
 )
 
+const (
+	DefaultCapacity = 1024 // 默认的队列容量
+)
+
+var _ BatchQueue[any] = (*ConcurrentQueue[any])(nil)
+
+// ConcurrentQueue 基于环形缓冲区的有界阻塞队列，并发安全。
+// 队列已满时EnQueue阻塞，队列为空时DeQueue阻塞，直到条件满足或者ctx结束
 type ConcurrentQueue[T any] struct {
-	queue []any
-	mu    sync.Mutex
+	mu sync.Mutex
+	// 环形缓冲区，长度就是队列的容量
+	data []T
+	// 队头的下标
+	head int
+	// 队列中的元素个数
+	count    int
+	notFull  *cond
+	notEmpty *cond
 }
 
+// NewConcurrentQueue 创建容量为size的队列，size小于等于0时使用DefaultCapacity
 func NewConcurrentQueue[T any](size int) *ConcurrentQueue[T] {
-	return &ConcurrentQueue[T]{
-		queue: make([]any, size),
+	if size <= 0 {
+		size = DefaultCapacity
 	}
+	c := &ConcurrentQueue[T]{
+		data: make([]T, size),
+	}
+	c.notFull = newCond(&c.mu)
+	c.notEmpty = newCond(&c.mu)
+	return c
 }
 
+// EnQueue 元素入队，队列已满时阻塞到有空位，ctx结束时返回ctx.Err()
 func (c *ConcurrentQueue[T]) EnQueue(ctx context.Context, data T) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.queue = append(c.queue, data)
+	for c.count == len(c.data) {
+		if err := c.notFull.Wait(ctx); err != nil {
+			return err
+		}
+	}
+
+	c.data[(c.head+c.count)%len(c.data)] = data
+	c.count++
+	c.notEmpty.Broadcast()
 	return nil
 }
 
+// DeQueue 队头的元素出队，队列为空时阻塞到有元素入队，ctx结束时返回ctx.Err()
 func (c *ConcurrentQueue[T]) DeQueue(ctx context.Context) (T, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if c.IsEmpty() {
-		var t T
-		return t, errors.New("空的队列")
+	for c.count == 0 {
+		if err := c.notEmpty.Wait(ctx); err != nil {
+			var t T
+			return t, err
+		}
 	}
-	data := c.queue[0]
-	c.queue = c.queue[1:]
+
+	data := c.pop()
+	c.notFull.Broadcast()
 	return data, nil
 }
 
+// EnQueueBatch 批量入队，空位不足时阻塞到所有元素都能放下，元素个数超过容量时返回ErrBatchTooLarge
+func (c *ConcurrentQueue[T]) EnQueueBatch(ctx context.Context, data []T) error {
+	if len(data) > len(c.data) {
+		return ErrBatchTooLarge
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.data)-c.count < len(data) {
+		if err := c.notFull.Wait(ctx); err != nil {
+			return err
+		}
+	}
+
+	for _, val := range data {
+		c.data[(c.head+c.count)%len(c.data)] = val
+		c.count++
+	}
+	if len(data) > 0 {
+		c.notEmpty.Broadcast()
+	}
+	return nil
+}
+
+// DeQueueBatch 批量出队，队列中的元素不足limit个时最多等待maxWait，limit超过容量时总是会等满maxWait
+func (c *ConcurrentQueue[T]) DeQueueBatch(ctx context.Context, limit int, maxWait time.Duration) ([]T, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	deadline := time.Now().Add(maxWait)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.count < limit {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			break
+		}
+		if err := c.notEmpty.WaitTimeout(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+	return c.take(min(c.count, limit)), nil
+}
+
+// Drain 按照入队的顺序取出所有的元素
+func (c *ConcurrentQueue[T]) Drain() []T {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.take(c.count)
+}
+
+// take 取出队头的n个元素，调用方需要持有c.mu
+func (c *ConcurrentQueue[T]) take(n int) []T {
+	if n == 0 {
+		return nil
+	}
+	res := make([]T, n)
+	for i := range res {
+		res[i] = c.pop()
+	}
+	c.notFull.Broadcast()
+	return res
+}
+
+// pop 取出队头的元素，调用方需要持有c.mu并且保证队列不为空
+func (c *ConcurrentQueue[T]) pop() T {
+	data := c.data[c.head]
+	// 清空引用，让出队的元素可以被回收
+	var zero T
+	c.data[c.head] = zero
+	c.head = (c.head + 1) % len(c.data)
+	c.count--
+	return data
+}
+
 func (c *ConcurrentQueue[T]) IsFull() bool {
-	//TODO implement me
-	panic("implement me")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.count == len(c.data)
 }
 
 func (c *ConcurrentQueue[T]) IsEmpty() bool {
-	//TODO implement me
-	panic("implement me")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.count == 0
 }
 
 func (c *ConcurrentQueue[T]) Len() uint64 {
-	//TODO implement me
-	panic("implement me")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return uint64(c.count)
 }
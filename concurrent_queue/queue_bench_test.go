@@ -0,0 +1,109 @@
+package concurrent_queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// chanQueue 使用带缓冲的channel实现的队列，作为基准测试的对照
+type chanQueue[T any] struct {
+	ch chan T
+}
+
+func (q chanQueue[T]) EnQueue(ctx context.Context, data T) error {
+	select {
+	case q.ch <- data:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q chanQueue[T]) DeQueue(ctx context.Context) (T, error) {
+	select {
+	case val := <-q.ch:
+		return val, nil
+	case <-ctx.Done():
+		var t T
+		return t, ctx.Err()
+	}
+}
+
+func (q chanQueue[T]) IsFull() bool {
+	return len(q.ch) == cap(q.ch)
+}
+
+func (q chanQueue[T]) IsEmpty() bool {
+	return len(q.ch) == 0
+}
+
+func (q chanQueue[T]) Len() uint64 {
+	return uint64(len(q.ch))
+}
+
+func BenchmarkQueue(b *testing.B) {
+	const capacity = 1024
+	queues := []struct {
+		name string
+		new  func() Queue[int]
+	}{
+		{name: "mutex", new: func() Queue[int] { return NewConcurrentQueue[int](capacity) }},
+		{name: "channel", new: func() Queue[int] { return chanQueue[int]{ch: make(chan int, capacity)} }},
+		{name: "linked", new: func() Queue[int] { return NewLinkedQueue[int]() }},
+		{name: "ring", new: func() Queue[int] { return NewRingQueue[int](capacity) }},
+	}
+	workers := []struct {
+		producers int
+		consumers int
+	}{
+		{producers: 1, consumers: 1},
+		{producers: 4, consumers: 4},
+		{producers: 8, consumers: 1},
+		{producers: 1, consumers: 8},
+		{producers: 16, consumers: 16},
+	}
+
+	for _, w := range workers {
+		for _, qc := range queues {
+			b.Run(fmt.Sprintf("%dP%dC/%s", w.producers, w.consumers, qc.name), func(b *testing.B) {
+				benchmarkQueue(b, qc.new(), w.producers, w.consumers)
+			})
+		}
+	}
+}
+
+// benchmarkQueue producers个生产者一共入队b.N个元素，consumers个消费者把它们全部取走
+func benchmarkQueue(b *testing.B, q Queue[int], producers, consumers int) {
+	var wg sync.WaitGroup
+	b.ResetTimer()
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				enqueue(q, i)
+			}
+		}(share(b.N, producers, p))
+	}
+	for c := 0; c < consumers; c++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				dequeue(q)
+			}
+		}(share(b.N, consumers, c))
+	}
+	wg.Wait()
+}
+
+// share 把n平均分给k个worker，返回第i个分到的数量
+func share(n, k, i int) int {
+	res := n / k
+	if i < n%k {
+		res++
+	}
+	return res
+}
@@ -0,0 +1,150 @@
+package concurrent_queue
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrentQueue(t *testing.T) {
+	ctx := context.Background()
+	q := NewConcurrentQueue[int](3)
+	assert.True(t, q.IsEmpty())
+	assert.False(t, q.IsFull())
+
+	// 先错开队头的位置，之后每一轮都会绕过缓冲区的末尾，依旧保持先进先出
+	require.NoError(t, q.EnQueue(ctx, -1))
+	val, err := q.DeQueue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, -1, val)
+	next := 0
+	for round := 0; round < 4; round++ {
+		for i := 0; i < 3; i++ {
+			require.NoError(t, q.EnQueue(ctx, round*3+i))
+		}
+		assert.True(t, q.IsFull())
+		assert.Equal(t, uint64(3), q.Len())
+		for i := 0; i < 3; i++ {
+			val, err := q.DeQueue(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, next, val)
+			next++
+		}
+	}
+	assert.True(t, q.IsEmpty())
+	assert.Equal(t, uint64(0), q.Len())
+}
+
+func TestConcurrentQueue_Timeout(t *testing.T) {
+	testCases := []struct {
+		name   string
+		before []int
+		op     func(ctx context.Context, q *ConcurrentQueue[int]) error
+	}{
+		{
+			name: "dequeue empty",
+			op: func(ctx context.Context, q *ConcurrentQueue[int]) error {
+				_, err := q.DeQueue(ctx)
+				return err
+			},
+		},
+		{
+			name:   "enqueue full",
+			before: []int{1, 2},
+			op: func(ctx context.Context, q *ConcurrentQueue[int]) error {
+				return q.EnQueue(ctx, 3)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := NewConcurrentQueue[int](2)
+			for _, v := range tc.before {
+				require.NoError(t, q.EnQueue(context.Background(), v))
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			assert.Equal(t, context.DeadlineExceeded, tc.op(ctx, q))
+			// 超时之后队列的状态不变
+			assert.Equal(t, uint64(len(tc.before)), q.Len())
+		})
+	}
+}
+
+func TestConcurrentQueue_Blocking(t *testing.T) {
+	q := NewConcurrentQueue[int](1)
+	require.NoError(t, q.EnQueue(context.Background(), 1))
+
+	// 出队之后阻塞中的EnQueue可以继续
+	done := make(chan error)
+	go func() {
+		done <- q.EnQueue(context.Background(), 2)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	val, err := q.DeQueue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, val)
+	require.NoError(t, <-done)
+
+	// 入队之后阻塞中的DeQueue可以继续
+	val, err = q.DeQueue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, val)
+	res := make(chan int)
+	go func() {
+		val, _ := q.DeQueue(context.Background())
+		res <- val
+	}()
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, q.EnQueue(context.Background(), 3))
+	assert.Equal(t, 3, <-res)
+}
+
+func TestConcurrentQueue_Concurrent(t *testing.T) {
+	q := NewConcurrentQueue[int](4)
+	const producers, consumers, n = 4, 4, 500
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				assert.NoError(t, q.EnQueue(context.Background(), p*n+i))
+			}
+		}(p)
+	}
+
+	var mu sync.Mutex
+	seen := make(map[int]struct{}, producers*n)
+	// 每个生产者的消息在同一个消费者中保持入队的顺序
+	var cwg sync.WaitGroup
+	for c := 0; c < consumers; c++ {
+		cwg.Add(1)
+		go func() {
+			defer cwg.Done()
+			last := map[int]int{}
+			for i := 0; i < producers*n/consumers; i++ {
+				val, err := q.DeQueue(context.Background())
+				if !assert.NoError(t, err) {
+					return
+				}
+				if prev, ok := last[val/n]; ok {
+					assert.Greater(t, val, prev)
+				}
+				last[val/n] = val
+				mu.Lock()
+				seen[val] = struct{}{}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	cwg.Wait()
+	assert.Len(t, seen, producers*n)
+	assert.True(t, q.IsEmpty())
+}
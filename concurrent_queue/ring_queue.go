@@ -0,0 +1,121 @@
+package concurrent_queue
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// cacheLineSize 用于填充，避免入队和出队的位置落在同一个缓存行中互相影响
+const cacheLineSize = 64
+
+var _ Queue[any] = (*RingQueue[any])(nil)
+
+type ringSlot[T any] struct {
+	// seq等于位置pos时可以写入pos，等于pos+1时可以读取pos
+	seq atomic.Uint64
+	val T
+}
+
+// RingQueue 基于原子序号的有界MPMC环形队列(Vyukov算法)，并发安全，容量会向上取整到2的幂。
+// 无锁队列不会阻塞，也不检查ctx：队列已满时EnQueue返回ErrFullQueue，队列为空时DeQueue返回ErrEmptyQueue
+type RingQueue[T any] struct {
+	_      [cacheLineSize]byte
+	enqPos atomic.Uint64
+	_      [cacheLineSize - 8]byte
+	deqPos atomic.Uint64
+	_      [cacheLineSize - 8]byte
+	mask   uint64
+	slots  []ringSlot[T]
+}
+
+// NewRingQueue 创建容量至少为capacity的队列，capacity小于等于0时使用DefaultCapacity
+func NewRingQueue[T any](capacity int) *RingQueue[T] {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	size := uint64(1)
+	for size < uint64(capacity) {
+		size <<= 1
+	}
+	q := &RingQueue[T]{
+		mask:  size - 1,
+		slots: make([]ringSlot[T], size),
+	}
+	for i := range q.slots {
+		q.slots[i].seq.Store(uint64(i))
+	}
+	return q
+}
+
+// EnQueue 元素入队，队列已满时返回ErrFullQueue
+func (q *RingQueue[T]) EnQueue(ctx context.Context, data T) error {
+	pos := q.enqPos.Load()
+	for {
+		slot := &q.slots[pos&q.mask]
+		diff := int64(slot.seq.Load() - pos)
+		switch {
+		case diff == 0:
+			if q.enqPos.CompareAndSwap(pos, pos+1) {
+				slot.val = data
+				slot.seq.Store(pos + 1)
+				return nil
+			}
+			pos = q.enqPos.Load()
+		case diff < 0:
+			// 上一轮的元素还没有被取走
+			return ErrFullQueue
+		default:
+			// 别的goroutine已经占用了这个位置
+			pos = q.enqPos.Load()
+		}
+	}
+}
+
+// DeQueue 队头的元素出队，队列为空时返回ErrEmptyQueue
+func (q *RingQueue[T]) DeQueue(ctx context.Context) (T, error) {
+	pos := q.deqPos.Load()
+	for {
+		slot := &q.slots[pos&q.mask]
+		diff := int64(slot.seq.Load() - (pos + 1))
+		switch {
+		case diff == 0:
+			if q.deqPos.CompareAndSwap(pos, pos+1) {
+				val := slot.val
+				// 清空引用，让出队的元素可以被回收
+				var zero T
+				slot.val = zero
+				// 下一轮的入队可以使用这个位置了
+				slot.seq.Store(pos + q.mask + 1)
+				return val, nil
+			}
+			pos = q.deqPos.Load()
+		case diff < 0:
+			// 这个位置还没有写入
+			var t T
+			return t, ErrEmptyQueue
+		default:
+			pos = q.deqPos.Load()
+		}
+	}
+}
+
+func (q *RingQueue[T]) IsFull() bool {
+	return q.Len() == uint64(len(q.slots))
+}
+
+func (q *RingQueue[T]) IsEmpty() bool {
+	return q.Len() == 0
+}
+
+// Len 队列的长度，并发修改的时候是一个近似值
+func (q *RingQueue[T]) Len() uint64 {
+	// 先读出队的位置，保证不会大于入队的位置
+	deq := q.deqPos.Load()
+	enq := q.enqPos.Load()
+	return min(enq-deq, uint64(len(q.slots)))
+}
+
+// Cap 队列的容量
+func (q *RingQueue[T]) Cap() uint64 {
+	return uint64(len(q.slots))
+}
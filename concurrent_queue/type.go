@@ -1,6 +1,17 @@
 package concurrent_queue
 
-import "context"
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrEmptyQueue = errors.New("空的队列")
+	ErrFullQueue  = errors.New("队列已满")
+	// ErrBatchTooLarge 批量入队的元素个数超过了队列的容量，永远放不下
+	ErrBatchTooLarge = errors.New("批量入队的元素个数超过了队列的容量")
+)
 
 // Queue 队列对外提供的接口
 type Queue[T any] interface {
@@ -15,3 +26,19 @@ type Queue[T any] interface {
 	// Len 队列的长度
 	Len() uint64
 }
+
+// BatchQueue 支持批量操作的队列，每个批量操作都在一个临界区内完成，不会和其他调用方交错。
+// ConcurrentQueue、PriorityQueue和DelayQueue实现了它
+type BatchQueue[T any] interface {
+	Queue[T]
+	// EnQueueBatch 批量入队，空间不足时阻塞到所有元素都能放下，ctx结束时返回ctx.Err()，不会只放进去一部分
+	EnQueueBatch(ctx context.Context, data []T) error
+	// DeQueueBatch 批量出队，最多取出limit个元素。凑不够limit个时最多等待maxWait，
+	// 然后取出当时所有可以出队的元素，可能一个都没有；ctx结束时返回ctx.Err()，不会取出任何元素
+	DeQueueBatch(ctx context.Context, limit int, maxWait time.Duration) ([]T, error)
+	// Drain 不阻塞地取出队列中所有的元素
+	Drain() []T
+}
+
+// Comparator 比较两个元素，src排在dst前面时返回负数，相等时返回0，否则返回正数
+type Comparator[T any] func(src, dst T) int
@@ -0,0 +1,158 @@
+package distributed_lock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryDistributedLock 基于内存实现的锁，只在单个进程内生效，适用于单机部署和单元测试
+type MemoryDistributedLock struct {
+	// key是锁的key，val是锁的持有者
+	locks map[string]*memoryLockValue
+	// 加锁保护资源
+	mu sync.Mutex
+	// 获取当前时间，测试时可以替换
+	now func() time.Time
+	// 指标记录器
+	metrics MetricsRecorder
+}
+
+type MemoryDistributedLockOption func(*MemoryDistributedLock)
+
+// MemoryDistributedLockWithMetrics 设置指标记录器，默认不记录
+func MemoryDistributedLockWithMetrics(m MetricsRecorder) MemoryDistributedLockOption {
+	return func(l *MemoryDistributedLock) {
+		l.metrics = m
+	}
+}
+
+type memoryLockValue struct {
+	// 锁的唯一标识
+	val string
+	// 过期时间
+	deadline time.Time
+}
+
+func NewMemoryDistributedLock(opts ...MemoryDistributedLockOption) *MemoryDistributedLock {
+	m := &MemoryDistributedLock{
+		locks:   map[string]*memoryLockValue{},
+		now:     time.Now,
+		metrics: NopMetricsRecorder{},
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Lock 加锁，锁被别人持有时按照strategy重试
+func (m *MemoryDistributedLock) Lock(ctx context.Context, key string,
+	timeout, expiration time.Duration,
+	strategy RetryStrategy, opts ...LockOption) (*Lock, error) {
+	val := newLockConfig(opts).val
+	err := lock(ctx, key, timeout, strategy, m.metrics, func(ctx context.Context) (bool, error) {
+		return m.tryLock(key, val, expiration, true), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newLock(m, key, val, expiration), nil
+}
+
+// TryLock 尝试加锁一次，和Redis的SETNX一样，锁被持有时即使锁值相同也会失败
+func (m *MemoryDistributedLock) TryLock(ctx context.Context, key string, expiration time.Duration, opts ...LockOption) (*Lock, error) {
+	val := newLockConfig(opts).val
+	err := tryLockOnce(ctx, key, m.metrics, func(ctx context.Context) (bool, error) {
+		return m.tryLock(key, val, expiration, false), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newLock(m, key, val, expiration), nil
+}
+
+// tryLock 锁不存在或者已经过期时加锁成功，reentrant为true时锁本来就是val持有的则直接续约
+func (m *MemoryDistributedLock) tryLock(key, val string, expiration time.Duration, reentrant bool) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	if v := m.get(key, now); v != nil && (!reentrant || v.val != val) {
+		return false
+	}
+
+	m.locks[key] = &memoryLockValue{
+		val:      val,
+		deadline: now.Add(expiration),
+	}
+	return true
+}
+
+// Holder 返回锁当前的持有者
+func (m *MemoryDistributedLock) Holder(ctx context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v := m.get(key, m.now())
+	if v == nil {
+		return "", ErrLockNotExist
+	}
+	return v.val, nil
+}
+
+// Inspect 返回锁的持有者和剩余过期时间
+func (m *MemoryDistributedLock) Inspect(ctx context.Context, key string) (*LockInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	v := m.get(key, now)
+	if v == nil {
+		return nil, ErrLockNotExist
+	}
+	return newLockInfo(key, v.val, v.deadline.Sub(now)), nil
+}
+
+func (m *MemoryDistributedLock) recorder() MetricsRecorder {
+	return m.metrics
+}
+
+func (m *MemoryDistributedLock) refresh(ctx context.Context, key, val string, expiration time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	v := m.get(key, now)
+	if v == nil || v.val != val {
+		return ErrLockNotHold
+	}
+
+	v.deadline = now.Add(expiration)
+	return nil
+}
+
+func (m *MemoryDistributedLock) unlock(ctx context.Context, key, val string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v := m.get(key, m.now())
+	if v == nil || v.val != val {
+		return ErrLockNotHold
+	}
+
+	delete(m.locks, key)
+	return nil
+}
+
+// get 获取没有过期的锁，过期的锁直接删除，调用方需要持有m.mu
+func (m *MemoryDistributedLock) get(key string, now time.Time) *memoryLockValue {
+	v, ok := m.locks[key]
+	if !ok {
+		return nil
+	}
+
+	if !v.deadline.After(now) {
+		delete(m.locks, key)
+		return nil
+	}
+	return v
+}
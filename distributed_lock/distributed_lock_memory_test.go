@@ -0,0 +1,48 @@
+package distributed_lock
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMemoryDistributedLock(t *testing.T) {
+	lockerSuite{
+		newLocker: func(t *testing.T) Locker {
+			return NewMemoryDistributedLock()
+		},
+		expiration: 100 * time.Millisecond,
+	}.run(t)
+}
+
+// ExampleLock_StartAutoRefresh 在后台自动续约，业务代码通过Context感知锁是否丢失，不用在每一部分代码中检查续约的错误
+func ExampleLock_StartAutoRefresh() {
+	ctx := context.Background()
+	l, err := NewMemoryDistributedLock().TryLock(ctx, "key", time.Second)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	l.StartAutoRefresh(300*time.Millisecond, 100*time.Millisecond,
+		AutoRefreshWithErrHandler(func(err error) {
+			// 续约超时或者失败时的处理逻辑，例如打印日志
+		}))
+
+	bizCtx := l.Context()
+	select {
+	case <-bizCtx.Done():
+		// 锁已经丢失，停止业务逻辑
+		fmt.Println(context.Cause(bizCtx))
+		return
+	default:
+		// 业务逻辑的部分
+	}
+
+	// 业务结束，解锁的同时停止自动续约并取消Context
+	_ = l.Unlock(ctx)
+	<-bizCtx.Done()
+	fmt.Println(context.Cause(bizCtx))
+	// output:
+	// 租约已经主动释放
+}
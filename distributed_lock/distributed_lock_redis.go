@@ -4,10 +4,8 @@ import (
 	"context"
 	_ "embed"
 	"errors"
-	"sync"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -15,6 +13,7 @@ var (
 	ErrFailedToRaceLock = errors.New("抢锁失败")
 	ErrLockNotHold      = errors.New("你没有持有锁")
 	ErrOverMaxCount     = errors.New("超过重试次数")
+	ErrLockNotExist     = errors.New("锁不存在")
 )
 
 //go:embed lua/unlock.lua
@@ -26,145 +25,96 @@ var refreshScript string
 //go:embed lua/lock.lua
 var lockScript string
 
+//go:embed lua/inspect.lua
+var inspectScript string
+
+type RedisDistributedLockOption func(*RedisDistributedLock)
+
 // RedisDistributedLock 基于Redis实现的分布式锁
 type RedisDistributedLock struct {
 	client redis.Cmdable
+	// 指标记录器
+	metrics MetricsRecorder
+}
+
+// RedisDistributedLockWithMetrics 设置指标记录器，默认不记录
+func RedisDistributedLockWithMetrics(m MetricsRecorder) RedisDistributedLockOption {
+	return func(l *RedisDistributedLock) {
+		l.metrics = m
+	}
 }
 
-func NewRedisDistributedLock(client redis.Cmdable) *RedisDistributedLock {
-	return &RedisDistributedLock{
-		client: client,
+func NewRedisDistributedLock(client redis.Cmdable, opts ...RedisDistributedLockOption) *RedisDistributedLock {
+	l := &RedisDistributedLock{
+		client:  client,
+		metrics: NopMetricsRecorder{},
+	}
+
+	for _, opt := range opts {
+		opt(l)
 	}
+	return l
 }
 
+// Lock 加锁，锁被别人持有时按照strategy重试，timeout是每次调用redis的超时时间，expiration是锁的过期时间
 func (l *RedisDistributedLock) Lock(ctx context.Context, key string,
 	timeout, expiration time.Duration,
-	strategy RetryStrategy) (*Lock, error) {
-	var timer *time.Timer
-	val := uuid.New().String()
-	for {
-		c, cancel := context.WithTimeout(ctx, timeout)
-		res, err := l.client.Eval(c, lockScript, []string{key}, []any{val, expiration.Seconds()}).Result()
-		cancel()
-		if err != nil && !errors.Is(err, context.DeadlineExceeded) {
-			return nil, err
-		}
-
-		interval, ok := strategy.Next(err)
-		if !ok {
-			return nil, ErrOverMaxCount
-		}
-
-		if res == "OK" {
-			return &Lock{
-				key:        key,
-				val:        val,
-				client:     l.client,
-				expiration: expiration,
-			}, nil
-		}
-
-		if timer == nil {
-			timer = time.NewTimer(interval)
-		} else {
-			timer.Reset(interval)
-		}
-
-		select {
-		case <-timer.C:
-			// 进行下一轮重试
-		case <-ctx.Done():
-			// context超时
-			return nil, ctx.Err()
-		}
+	strategy RetryStrategy, opts ...LockOption) (*Lock, error) {
+	val := newLockConfig(opts).val
+	err := lock(ctx, key, timeout, strategy, l.metrics, func(ctx context.Context) (bool, error) {
+		res, err := l.client.Eval(ctx, lockScript, []string{key}, []any{val, expiration.Seconds()}).Result()
+		return res == "OK", err
+	})
+	if err != nil {
+		return nil, err
 	}
+
+	return newLock(l, key, val, expiration), nil
 }
 
 // TryLock 尝试抢锁，key是存储在Redis中的键，
-func (l *RedisDistributedLock) TryLock(ctx context.Context, key string, expiration time.Duration) (*Lock, error) {
-	val := uuid.New().String()
-	ok, err := l.client.SetNX(ctx, key, val, expiration).Result()
+func (l *RedisDistributedLock) TryLock(ctx context.Context, key string, expiration time.Duration, opts ...LockOption) (*Lock, error) {
+	val := newLockConfig(opts).val
+	err := tryLockOnce(ctx, key, l.metrics, func(ctx context.Context) (bool, error) {
+		return l.client.SetNX(ctx, key, val, expiration).Result()
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	if !ok {
-		return nil, ErrFailedToRaceLock
-	}
-
-	return &Lock{
-		key:        key,
-		val:        val,
-		client:     l.client,
-		expiration: expiration,
-	}, nil
+	return newLock(l, key, val, expiration), nil
 }
 
-// Lock 锁
-type Lock struct {
-	// 存储在redis中的key
-	key string
-	// 锁的唯一标识，防止释放掉别人的锁
-	val string
-	// 过期时间，用于手动续约刷新过期时间
-	expiration time.Duration
-	// redis
-	client redis.Cmdable
-	// unlockCh 通知停止自动续约的channel
-	unlockCh chan struct{}
-	// timeoutCh context超时后通知重试的channel
-	timeoutCh chan struct{}
-	// once 防止多次释放锁
-	once sync.Once
+// Holder 返回锁当前的持有者
+func (l *RedisDistributedLock) Holder(ctx context.Context, key string) (string, error) {
+	val, err := l.client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrLockNotExist
+	}
+	return val, err
 }
 
-// AutoRefresh 自动续约机制，timeout是每次调用redis的context超时时间，interval是每次续约的间隔时间
-func (l *Lock) AutoRefresh(interval, timeout time.Duration) error {
-	l.timeoutCh = make(chan struct{}, 1)
-	l.unlockCh = make(chan struct{}, 1)
-	l.once = sync.Once{}
-
-	defer close(l.timeoutCh)
-	ticker := time.NewTicker(interval)
-	for {
-		select {
-		case <-ticker.C:
-			// 这里是正常的续约逻辑处理
-			ctx, cancel := context.WithTimeout(context.Background(), timeout)
-			err := l.Refresh(ctx)
-			cancel()
-			if err != nil {
-				if errors.Is(err, context.DeadlineExceeded) {
-					// 超时的业务员逻辑
-					l.timeoutCh <- struct{}{}
-					continue
-				} else {
-					return err
-				}
-			}
-		case <-l.timeoutCh:
-			// 这里是context超时后处理重试的逻辑
-			ctx, cancel := context.WithTimeout(context.Background(), timeout)
-			err := l.Refresh(ctx)
-			cancel()
-			if err != nil {
-				if errors.Is(err, context.DeadlineExceeded) {
-					// 超时的业务员逻辑
-					l.timeoutCh <- struct{}{}
-					continue
-				} else {
-					return err
-				}
-			}
-		case <-l.unlockCh:
-			return nil
-		}
+// Inspect 返回锁的持有者和剩余过期时间
+func (l *RedisDistributedLock) Inspect(ctx context.Context, key string) (*LockInfo, error) {
+	res, err := l.client.Eval(ctx, inspectScript, []string{key}).Slice()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrLockNotExist
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	val, _ := res[0].(string)
+	pttl, _ := res[1].(int64)
+	return newLockInfo(key, val, time.Duration(pttl)*time.Millisecond), nil
 }
 
-// Refresh 手动给锁续约
-func (l *Lock) Refresh(ctx context.Context) error {
-	res, err := l.client.Eval(ctx, refreshScript, []string{l.key}, l.val, l.expiration.Seconds()).Int64()
+func (l *RedisDistributedLock) recorder() MetricsRecorder {
+	return l.metrics
+}
+
+func (l *RedisDistributedLock) refresh(ctx context.Context, key, val string, expiration time.Duration) error {
+	res, err := l.client.Eval(ctx, refreshScript, []string{key}, val, expiration.Seconds()).Int64()
 	if err != nil {
 		return err
 	}
@@ -176,30 +126,16 @@ func (l *Lock) Refresh(ctx context.Context) error {
 	return nil
 }
 
-// Unlock 解锁，因为TryLock返回的是*Lock，所以直接定义为Lock的方法
-// 解锁过程涉及到并发问题，可以利用Redis单线程的特性使用脚本来完成解锁流程
-func (l *Lock) Unlock(ctx context.Context) error {
-	var unlockErr error
-	l.once.Do(func() {
-		defer func() {
-			select {
-			case l.unlockCh <- struct{}{}:
-				close(l.unlockCh)
-			default:
-				// 没有人调用自动续约，不需要处理
-			}
-		}()
-
-		res, err := l.client.Eval(ctx, unlockScript, []string{l.key}, l.val).Int64()
-		if err != nil {
-			unlockErr = err
-		}
-
-		if res != 1 {
-			unlockErr = ErrLockNotHold
-		}
-		return
-	})
+// unlock 解锁过程涉及到并发问题，可以利用Redis单线程的特性使用脚本来完成解锁流程
+func (l *RedisDistributedLock) unlock(ctx context.Context, key, val string) error {
+	res, err := l.client.Eval(ctx, unlockScript, []string{key}, val).Int64()
+	if err != nil {
+		return err
+	}
 
-	return unlockErr
+	if res != 1 {
+		return ErrLockNotHold
+	}
+
+	return nil
 }
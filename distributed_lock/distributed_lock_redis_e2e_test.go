@@ -46,7 +46,7 @@ func TestRedisDistributedLock_TryLock_e2e(t *testing.T) {
 			lock: &Lock{
 				key:        "key1",
 				val:        "value1",
-				client:     client,
+				client:     NewRedisDistributedLock(client),
 				expiration: time.Minute,
 			},
 		},
@@ -63,7 +63,7 @@ func TestRedisDistributedLock_TryLock_e2e(t *testing.T) {
 			wantErr: nil,
 			lock: &Lock{
 				key:        "key2",
-				client:     client,
+				client:     NewRedisDistributedLock(client),
 				expiration: time.Minute,
 			},
 		},
@@ -112,7 +112,7 @@ func TestRedisDistributedLock_Unlock_e2e(t *testing.T) {
 			lock: &Lock{
 				key:    "not exist1",
 				val:    "not exist1",
-				client: client,
+				client: NewRedisDistributedLock(client),
 			},
 		},
 		// 锁存在，但不是自己的锁
@@ -131,7 +131,7 @@ func TestRedisDistributedLock_Unlock_e2e(t *testing.T) {
 			lock: &Lock{
 				key:    "lock hold",
 				val:    "1232132131232132",
-				client: client,
+				client: NewRedisDistributedLock(client),
 			},
 		},
 		// 锁存在且是自己的锁
@@ -150,7 +150,7 @@ func TestRedisDistributedLock_Unlock_e2e(t *testing.T) {
 			lock: &Lock{
 				key:    "unlock success",
 				val:    "123456",
-				client: client,
+				client: NewRedisDistributedLock(client),
 			},
 		},
 	}
@@ -191,7 +191,7 @@ func TestRedisDistributedLock_Refresh_e2e(t *testing.T) {
 			lock: &Lock{
 				key:        "lock not exist",
 				val:        "lock not exist",
-				client:     client,
+				client:     NewRedisDistributedLock(client),
 				expiration: time.Minute,
 			},
 			wantErr: ErrLockNotHold,
@@ -217,7 +217,7 @@ func TestRedisDistributedLock_Refresh_e2e(t *testing.T) {
 			lock: &Lock{
 				key:        "other lock",
 				val:        "my lock",
-				client:     client,
+				client:     NewRedisDistributedLock(client),
 				expiration: time.Minute,
 			},
 			wantErr: ErrLockNotHold,
@@ -249,7 +249,7 @@ func TestRedisDistributedLock_Refresh_e2e(t *testing.T) {
 			lock: &Lock{
 				key:        "refresh lock",
 				val:        "123456",
-				client:     client,
+				client:     NewRedisDistributedLock(client),
 				expiration: time.Minute,
 			},
 			wantErr: nil,
@@ -267,3 +267,17 @@ func TestRedisDistributedLock_Refresh_e2e(t *testing.T) {
 		})
 	}
 }
+
+func TestRedisDistributedLock_Conformance_e2e(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     "122.9.137.145:6319",
+		Password: "123456",
+	})
+	lockerSuite{
+		newLocker: func(t *testing.T) Locker {
+			return NewRedisDistributedLock(client)
+		},
+		// redis的过期时间精度是秒
+		expiration: 2 * time.Second,
+	}.run(t)
+}
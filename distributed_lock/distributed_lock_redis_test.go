@@ -7,8 +7,10 @@ import (
 	"github.com/go-playground/assert/v2"
 	"github.com/golang/mock/gomock"
 	"github.com/liquanhui-99/gotool/cache/redis_cache/mocks"
+	"github.com/liquanhui-99/gotool/retry"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/require"
+	"sync"
 	"testing"
 	"time"
 )
@@ -82,6 +84,64 @@ func TestRedisDistributedLock_TryLock(t *testing.T) {
 	}
 }
 
+func TestRedisDistributedLock_Lock(t *testing.T) {
+	testCases := []struct {
+		name     string
+		key      string
+		strategy RetryStrategy
+		wantErr  error
+		client   func(ctrl *gomock.Controller) redis.Cmdable
+	}{
+		{
+			name:     "lock after retry",
+			key:      "lock after retry",
+			strategy: retry.WithMaxAttempts(retry.NewConstantStrategy(time.Millisecond), 2),
+			client: func(ctrl *gomock.Controller) redis.Cmdable {
+				cmd := mocks.NewMockCmdable(ctrl)
+				hold := redis.NewCmd(context.Background())
+				hold.SetVal("")
+				ok := redis.NewCmd(context.Background())
+				ok.SetVal("OK")
+				gomock.InOrder(
+					cmd.EXPECT().Eval(gomock.Any(), lockScript, []string{"lock after retry"}, gomock.Any()).
+						Return(hold).Times(2),
+					cmd.EXPECT().Eval(gomock.Any(), lockScript, []string{"lock after retry"}, gomock.Any()).
+						Return(ok),
+				)
+				return cmd
+			},
+		},
+		{
+			name:     "over max count",
+			key:      "over max count",
+			strategy: retry.WithMaxAttempts(retry.NewConstantStrategy(time.Millisecond), 2),
+			wantErr:  ErrOverMaxCount,
+			client: func(ctrl *gomock.Controller) redis.Cmdable {
+				cmd := mocks.NewMockCmdable(ctrl)
+				hold := redis.NewCmd(context.Background())
+				hold.SetVal("")
+				cmd.EXPECT().Eval(gomock.Any(), lockScript, []string{"over max count"}, gomock.Any()).
+					Return(hold).Times(3)
+				return cmd
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			dl := NewRedisDistributedLock(tc.client(ctrl))
+			lock, err := dl.Lock(context.Background(), tc.key, time.Second, time.Minute, tc.strategy)
+			require.Equal(t, tc.wantErr, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tc.key, lock.key)
+		})
+	}
+}
+
 func TestRedisDistributedLock_Unlock(t *testing.T) {
 	testCases := []struct {
 		name     string
@@ -143,7 +203,7 @@ func TestRedisDistributedLock_Unlock(t *testing.T) {
 			lock := &Lock{
 				key:    tc.key,
 				val:    tc.val,
-				client: tc.client(ctrl),
+				client: NewRedisDistributedLock(tc.client(ctrl)),
 			}
 			err := lock.Unlock(context.Background())
 			assert.Equal(t, err, tc.wantErr)
@@ -218,7 +278,7 @@ func TestRedisDistributedLock_Refresh(t *testing.T) {
 			lock := &Lock{
 				key:        tc.key,
 				val:        tc.val,
-				client:     tc.client(ctrl),
+				client:     NewRedisDistributedLock(tc.client(ctrl)),
 				expiration: tc.expiration,
 			}
 			err := lock.Refresh(context.Background())
@@ -227,6 +287,112 @@ func TestRedisDistributedLock_Refresh(t *testing.T) {
 	}
 }
 
+func TestLock_StartAutoRefresh(t *testing.T) {
+	testCases := []struct {
+		name      string
+		maxRetry  int
+		wantCause error
+		wantErrs  int
+		client    func(ctrl *gomock.Controller) redis.Cmdable
+	}{
+		{
+			name:      "lock lost",
+			maxRetry:  3,
+			wantCause: ErrLockNotHold,
+			wantErrs:  1,
+			client: func(ctrl *gomock.Controller) redis.Cmdable {
+				cmd := mocks.NewMockCmdable(ctrl)
+				ok := redis.NewCmd(context.Background())
+				ok.SetVal(int64(1))
+				lost := redis.NewCmd(context.Background())
+				lost.SetVal(int64(0))
+				gomock.InOrder(
+					cmd.EXPECT().Eval(gomock.Any(), refreshScript, []string{"lock lost"}, []any{"val", float64(60)}).Return(ok),
+					cmd.EXPECT().Eval(gomock.Any(), refreshScript, []string{"lock lost"}, []any{"val", float64(60)}).Return(lost),
+				)
+				return cmd
+			},
+		},
+		{
+			name:      "over max retry",
+			maxRetry:  2,
+			wantCause: context.DeadlineExceeded,
+			wantErrs:  3,
+			client: func(ctrl *gomock.Controller) redis.Cmdable {
+				cmd := mocks.NewMockCmdable(ctrl)
+				timeout := redis.NewCmd(context.Background())
+				timeout.SetErr(context.DeadlineExceeded)
+				cmd.EXPECT().Eval(gomock.Any(), refreshScript, []string{"over max retry"}, []any{"val", float64(60)}).
+					Return(timeout).Times(3)
+				return cmd
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			lock := &Lock{
+				key:        tc.name,
+				val:        "val",
+				client:     NewRedisDistributedLock(tc.client(ctrl)),
+				expiration: time.Minute,
+			}
+			var mu sync.Mutex
+			errs := 0
+			lock.StartAutoRefresh(10*time.Millisecond, time.Second,
+				AutoRefreshWithMaxRetry(tc.maxRetry),
+				AutoRefreshWithErrHandler(func(err error) {
+					mu.Lock()
+					errs++
+					mu.Unlock()
+				}))
+
+			bizCtx, cancel := WithLockContext(context.Background(), lock)
+			defer cancel()
+			select {
+			case <-bizCtx.Done():
+			case <-time.After(time.Second):
+				t.Fatal("租约丢失后没有取消context")
+			}
+			assert.Equal(t, tc.wantCause, context.Cause(lock.Context()))
+			assert.Equal(t, tc.wantCause, context.Cause(bizCtx))
+			mu.Lock()
+			assert.Equal(t, tc.wantErrs, errs)
+			mu.Unlock()
+		})
+	}
+}
+
+func TestLock_Context(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cmd := mocks.NewMockCmdable(ctrl)
+	res := redis.NewCmd(context.Background())
+	res.SetVal(int64(1))
+	cmd.EXPECT().Eval(context.Background(), unlockScript, []string{"key"}, []any{"val"}).Return(res)
+	lock := &Lock{
+		key:    "key",
+		val:    "val",
+		client: NewRedisDistributedLock(cmd),
+	}
+
+	refreshDone := make(chan error, 1)
+	go func() {
+		refreshDone <- lock.AutoRefresh(time.Hour, time.Second)
+	}()
+
+	require.NoError(t, lock.Context().Err())
+	require.NoError(t, lock.Unlock(context.Background()))
+	// 解锁之后context被取消，自动续约正常退出
+	<-lock.Context().Done()
+	assert.Equal(t, ErrLeaseReleased, context.Cause(lock.Context()))
+	require.NoError(t, <-refreshDone)
+	// 重复解锁不会再访问redis
+	require.NoError(t, lock.Unlock(context.Background()))
+}
+
 func ExampleLock_Refresh() {
 	var l Lock
 	errCh := make(chan error, 1)
@@ -239,6 +405,7 @@ func ExampleLock_Refresh() {
 			case <-ticker.C:
 				ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 				err := l.Refresh(ctx)
+				cancel()
 				if err != nil {
 					if errors.Is(err, context.DeadlineExceeded) {
 						// 超时了，通知重试
@@ -248,12 +415,11 @@ func ExampleLock_Refresh() {
 						errCh <- err
 					}
 				}
-
-				cancel()
 			case <-timeoutCh:
 				// 续约超时重试的逻辑，这里可以加上一些超时次数等更加精细化的控制
 				ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 				err := l.Refresh(ctx)
+				cancel()
 				if err != nil {
 					if errors.Is(err, context.DeadlineExceeded) {
 						// 超时了，通知重试
@@ -263,12 +429,11 @@ func ExampleLock_Refresh() {
 						errCh <- err
 					}
 				}
-				cancel()
 			case <-closeCh:
 				// 业务代码通知关闭续约程序
 				close(errCh)
 				close(closeCh)
-				break
+				return
 			}
 		}
 	}()
@@ -305,3 +470,56 @@ func ExampleLock_Refresh() {
 	// output:
 	// hello
 }
+
+func TestRedisDistributedLock_Inspect(t *testing.T) {
+	testCases := []struct {
+		name     string
+		res      func() *redis.Cmd
+		wantErr  error
+		wantInfo *LockInfo
+	}{
+		{
+			name: "inspect",
+			res: func() *redis.Cmd {
+				res := redis.NewCmd(context.Background())
+				res.SetVal([]any{"node-1", int64(1500)})
+				return res
+			},
+			wantInfo: &LockInfo{
+				Key:   "key",
+				Value: "node-1",
+				TTL:   1500 * time.Millisecond,
+			},
+		},
+		{
+			name: "lock not exist",
+			res: func() *redis.Cmd {
+				res := redis.NewCmd(context.Background())
+				res.SetErr(redis.Nil)
+				return res
+			},
+			wantErr: ErrLockNotExist,
+		},
+		{
+			name: "eval error",
+			res: func() *redis.Cmd {
+				res := redis.NewCmd(context.Background())
+				res.SetErr(context.DeadlineExceeded)
+				return res
+			},
+			wantErr: context.DeadlineExceeded,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			cmd := mocks.NewMockCmdable(ctrl)
+			cmd.EXPECT().Eval(gomock.Any(), inspectScript, []string{"key"}).Return(tc.res())
+			info, err := NewRedisDistributedLock(cmd).Inspect(context.Background(), "key")
+			require.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantInfo, info)
+		})
+	}
+}
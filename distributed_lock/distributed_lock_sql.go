@@ -0,0 +1,212 @@
+package distributed_lock
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	DefaultLockTable = "distributed_lock" // 默认的锁表名
+)
+
+type SQLDistributedLockOption func(*SQLDistributedLock)
+
+// SQLDistributedLock 基于关系型数据库行租约实现的锁，每一把锁对应锁表中的一行，
+// expire_at是租约的过期时间(毫秒时间戳)，过期的行可以被其他人抢占。
+// 加锁依赖INSERT ... ON CONFLICT语法，PostgreSQL和SQLite都支持。
+// 过期时间使用的是各个节点的本地时间，节点之间的时钟偏差需要远小于锁的过期时间
+type SQLDistributedLock struct {
+	db *sql.DB
+	// 锁表的表名
+	table string
+	// 生成第i个(从1开始)参数的占位符
+	placeholder func(i int) string
+	// 获取当前时间，测试时可以替换
+	now func() time.Time
+	// 指标记录器
+	metrics MetricsRecorder
+
+	lockQuery    string
+	tryLockQuery string
+	refreshQuery string
+	unlockQuery  string
+	holderQuery  string
+	inspectQuery string
+}
+
+// SQLDistributedLockWithTable 设置锁表的表名
+func SQLDistributedLockWithTable(table string) SQLDistributedLockOption {
+	return func(l *SQLDistributedLock) {
+		l.table = table
+	}
+}
+
+// SQLDistributedLockWithDollarPlaceholder 使用PostgreSQL的$1、$2形式的占位符，默认是?
+func SQLDistributedLockWithDollarPlaceholder() SQLDistributedLockOption {
+	return func(l *SQLDistributedLock) {
+		l.placeholder = func(i int) string {
+			return fmt.Sprintf("$%d", i)
+		}
+	}
+}
+
+// SQLDistributedLockWithMetrics 设置指标记录器，默认不记录
+func SQLDistributedLockWithMetrics(m MetricsRecorder) SQLDistributedLockOption {
+	return func(l *SQLDistributedLock) {
+		l.metrics = m
+	}
+}
+
+func NewSQLDistributedLock(db *sql.DB, opts ...SQLDistributedLockOption) *SQLDistributedLock {
+	l := &SQLDistributedLock{
+		db:    db,
+		table: DefaultLockTable,
+		placeholder: func(i int) string {
+			return "?"
+		},
+		now:     time.Now,
+		metrics: NopMetricsRecorder{},
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	p := l.placeholder
+	// 锁不存在时直接插入，锁已经过期或者本来就是自己持有的时候更新，否则什么都不做，影响行数为0
+	l.lockQuery = fmt.Sprintf("INSERT INTO %[1]s (lock_key, lock_value, expire_at) VALUES (%[2]s, %[3]s, %[4]s) "+
+		"ON CONFLICT (lock_key) DO UPDATE SET lock_value = excluded.lock_value, expire_at = excluded.expire_at "+
+		"WHERE %[1]s.expire_at <= %[5]s OR %[1]s.lock_value = excluded.lock_value",
+		l.table, p(1), p(2), p(3), p(4))
+	// TryLock和Redis的SETNX一样，锁被持有时即使锁值相同也会失败
+	l.tryLockQuery = fmt.Sprintf("INSERT INTO %[1]s (lock_key, lock_value, expire_at) VALUES (%[2]s, %[3]s, %[4]s) "+
+		"ON CONFLICT (lock_key) DO UPDATE SET lock_value = excluded.lock_value, expire_at = excluded.expire_at "+
+		"WHERE %[1]s.expire_at <= %[5]s",
+		l.table, p(1), p(2), p(3), p(4))
+	l.refreshQuery = fmt.Sprintf("UPDATE %s SET expire_at = %s WHERE lock_key = %s AND lock_value = %s AND expire_at > %s",
+		l.table, p(1), p(2), p(3), p(4))
+	l.unlockQuery = fmt.Sprintf("DELETE FROM %s WHERE lock_key = %s AND lock_value = %s AND expire_at > %s",
+		l.table, p(1), p(2), p(3))
+	l.holderQuery = fmt.Sprintf("SELECT lock_value FROM %s WHERE lock_key = %s AND expire_at > %s",
+		l.table, p(1), p(2))
+	l.inspectQuery = fmt.Sprintf("SELECT lock_value, expire_at FROM %s WHERE lock_key = %s AND expire_at > %s",
+		l.table, p(1), p(2))
+	return l
+}
+
+// CreateTable 创建锁表，表已经存在时什么都不做
+func (l *SQLDistributedLock) CreateTable(ctx context.Context) error {
+	_, err := l.db.ExecContext(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s ("+
+		"lock_key VARCHAR(255) NOT NULL PRIMARY KEY, "+
+		"lock_value VARCHAR(255) NOT NULL, "+
+		"expire_at BIGINT NOT NULL)", l.table))
+	return err
+}
+
+// Lock 加锁，锁被别人持有时按照strategy重试，timeout是每次访问数据库的超时时间
+func (l *SQLDistributedLock) Lock(ctx context.Context, key string,
+	timeout, expiration time.Duration,
+	strategy RetryStrategy, opts ...LockOption) (*Lock, error) {
+	val := newLockConfig(opts).val
+	err := lock(ctx, key, timeout, strategy, l.metrics, func(ctx context.Context) (bool, error) {
+		return l.tryLock(ctx, l.lockQuery, key, val, expiration)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newLock(l, key, val, expiration), nil
+}
+
+// TryLock 尝试加锁一次，和Redis的SETNX一样，锁被持有时即使锁值相同也会失败
+func (l *SQLDistributedLock) TryLock(ctx context.Context, key string, expiration time.Duration, opts ...LockOption) (*Lock, error) {
+	val := newLockConfig(opts).val
+	err := tryLockOnce(ctx, key, l.metrics, func(ctx context.Context) (bool, error) {
+		return l.tryLock(ctx, l.tryLockQuery, key, val, expiration)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newLock(l, key, val, expiration), nil
+}
+
+func (l *SQLDistributedLock) tryLock(ctx context.Context, query, key, val string, expiration time.Duration) (bool, error) {
+	now := l.now()
+	res, err := l.db.ExecContext(ctx, query, key, val, now.Add(expiration).UnixMilli(), now.UnixMilli())
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// Holder 返回锁当前的持有者
+func (l *SQLDistributedLock) Holder(ctx context.Context, key string) (string, error) {
+	var val string
+	err := l.db.QueryRowContext(ctx, l.holderQuery, key, l.now().UnixMilli()).Scan(&val)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrLockNotExist
+	}
+	return val, err
+}
+
+// Inspect 返回锁的持有者和剩余过期时间
+func (l *SQLDistributedLock) Inspect(ctx context.Context, key string) (*LockInfo, error) {
+	var (
+		val      string
+		expireAt int64
+	)
+	now := l.now()
+	err := l.db.QueryRowContext(ctx, l.inspectQuery, key, now.UnixMilli()).Scan(&val, &expireAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrLockNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newLockInfo(key, val, time.UnixMilli(expireAt).Sub(now)), nil
+}
+
+func (l *SQLDistributedLock) recorder() MetricsRecorder {
+	return l.metrics
+}
+
+func (l *SQLDistributedLock) refresh(ctx context.Context, key, val string, expiration time.Duration) error {
+	now := l.now()
+	res, err := l.db.ExecContext(ctx, l.refreshQuery, now.Add(expiration).UnixMilli(), key, val, now.UnixMilli())
+	if err != nil {
+		return err
+	}
+
+	return l.checkAffected(res)
+}
+
+func (l *SQLDistributedLock) unlock(ctx context.Context, key, val string) error {
+	res, err := l.db.ExecContext(ctx, l.unlockQuery, key, val, l.now().UnixMilli())
+	if err != nil {
+		return err
+	}
+
+	return l.checkAffected(res)
+}
+
+// checkAffected 影响行数不为1说明锁已经过期或者被别人持有
+func (l *SQLDistributedLock) checkAffected(res sql.Result) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected != 1 {
+		return ErrLockNotHold
+	}
+	return nil
+}
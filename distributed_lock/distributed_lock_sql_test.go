@@ -0,0 +1,51 @@
+package distributed_lock
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+func TestSQLDistributedLock(t *testing.T) {
+	lockerSuite{
+		newLocker: func(t *testing.T) Locker {
+			return newSQLiteLocker(t)
+		},
+		expiration: 100 * time.Millisecond,
+	}.run(t)
+}
+
+func TestSQLDistributedLock_Query(t *testing.T) {
+	l := NewSQLDistributedLock(nil, SQLDistributedLockWithTable("job_lock"), SQLDistributedLockWithDollarPlaceholder())
+	require.Equal(t, "INSERT INTO job_lock (lock_key, lock_value, expire_at) VALUES ($1, $2, $3) "+
+		"ON CONFLICT (lock_key) DO UPDATE SET lock_value = excluded.lock_value, expire_at = excluded.expire_at "+
+		"WHERE job_lock.expire_at <= $4 OR job_lock.lock_value = excluded.lock_value", l.lockQuery)
+	require.Equal(t, "INSERT INTO job_lock (lock_key, lock_value, expire_at) VALUES ($1, $2, $3) "+
+		"ON CONFLICT (lock_key) DO UPDATE SET lock_value = excluded.lock_value, expire_at = excluded.expire_at "+
+		"WHERE job_lock.expire_at <= $4", l.tryLockQuery)
+	require.Equal(t, "UPDATE job_lock SET expire_at = $1 WHERE lock_key = $2 AND lock_value = $3 AND expire_at > $4",
+		l.refreshQuery)
+	require.Equal(t, "DELETE FROM job_lock WHERE lock_key = $1 AND lock_value = $2 AND expire_at > $3", l.unlockQuery)
+	require.Equal(t, "SELECT lock_value FROM job_lock WHERE lock_key = $1 AND expire_at > $2", l.holderQuery)
+	require.Equal(t, "SELECT lock_value, expire_at FROM job_lock WHERE lock_key = $1 AND expire_at > $2", l.inspectQuery)
+}
+
+// newSQLiteLocker 每个测试用例使用一个独立的SQLite数据库文件
+func newSQLiteLocker(t *testing.T) *SQLDistributedLock {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "lock.db")+"?_pragma=busy_timeout(5000)")
+	require.NoError(t, err)
+	// SQLite只支持单个写连接
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	l := NewSQLDistributedLock(db)
+	require.NoError(t, l.CreateTable(context.Background()))
+	return l
+}
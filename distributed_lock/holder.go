@@ -0,0 +1,68 @@
+package distributed_lock
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// HolderInfo 锁持有者的元数据，使用LockWithHolderInfo加锁时以JSON的形式作为锁值保存
+type HolderInfo struct {
+	// ID 唯一标识，保证同一个进程中的不同持有者互不相同
+	ID string `json:"id"`
+	// Hostname 持有者所在的主机名
+	Hostname string `json:"hostname"`
+	// PID 持有者的进程号
+	PID int `json:"pid"`
+	// AcquiredAt 开始加锁的时间
+	AcquiredAt time.Time `json:"acquired_at"`
+}
+
+// LockInfo 锁的当前状态，通过Inspect获取
+type LockInfo struct {
+	Key string
+	// Value 锁值，也就是持有者的唯一标识
+	Value string
+	// Holder 持有者的元数据，锁值不是LockWithHolderInfo生成的时候为nil
+	Holder *HolderInfo
+	// TTL 锁的剩余过期时间
+	TTL time.Duration
+}
+
+// LockWithHolderInfo 使用持有者的元数据(主机名、进程号、加锁时间)作为锁值，
+// 锁卡住的时候可以通过Inspect查看是谁持有了锁。会覆盖LockWithValue设置的值
+func LockWithHolderInfo() LockOption {
+	return func(cfg *lockConfig) {
+		hostname, _ := os.Hostname()
+		info := HolderInfo{
+			ID:         uuid.New().String(),
+			Hostname:   hostname,
+			PID:        os.Getpid(),
+			AcquiredAt: time.Now(),
+		}
+		// HolderInfo只包含基本类型，序列化不会失败
+		val, _ := json.Marshal(info)
+		cfg.val = string(val)
+	}
+}
+
+// ParseHolderInfo 从锁值中解析持有者的元数据，锁值不是LockWithHolderInfo生成的时候返回false
+func ParseHolderInfo(val string) (*HolderInfo, bool) {
+	var info HolderInfo
+	if err := json.Unmarshal([]byte(val), &info); err != nil || info.ID == "" {
+		return nil, false
+	}
+	return &info, true
+}
+
+func newLockInfo(key, val string, ttl time.Duration) *LockInfo {
+	info := &LockInfo{
+		Key:   key,
+		Value: val,
+		TTL:   ttl,
+	}
+	info.Holder, _ = ParseHolderInfo(val)
+	return info
+}
@@ -0,0 +1,38 @@
+package distributed_lock
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHolderInfo(t *testing.T) {
+	cfg := newLockConfig([]LockOption{LockWithHolderInfo()})
+	info, ok := ParseHolderInfo(cfg.val)
+	require.True(t, ok)
+	assert.NotEmpty(t, info.ID)
+	assert.False(t, info.AcquiredAt.IsZero())
+
+	testCases := []struct {
+		name string
+		val  string
+	}{
+		{
+			name: "uuid",
+			val:  "9b1deb4d-3b7d-4bad-9bdd-2b0d7b3dcb6d",
+		},
+		{
+			name: "json without id",
+			val:  `{"hostname":"node-1"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			info, ok := ParseHolderInfo(tc.val)
+			assert.False(t, ok)
+			assert.Nil(t, info)
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package distributed_lock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultLeaseDuration = 15 * time.Second // 默认的leader租约时长
+	DefaultRetryPeriod   = 2 * time.Second  // 默认的竞选间隔
+	DefaultRenewTimeout  = time.Second      // 默认每次续约的超时时间
+)
+
+// LeaderCallbacks 竞选结果的回调
+type LeaderCallbacks struct {
+	// OnStartedLeading 成为leader之后在新的goroutine中调用，ctx在失去leader身份时被取消，
+	// 业务代码需要在ctx取消之后尽快返回，返回之后才会释放锁，保证同一时刻只有一个leader在工作
+	OnStartedLeading func(ctx context.Context)
+	// OnStoppedLeading 失去leader身份时调用，可以为nil
+	OnStoppedLeading func()
+}
+
+type LeaderElectorOption func(*LeaderElector)
+
+// LeaderElector 基于Locker实现的leader选举，多个副本使用同一个key竞选，只有抢到锁的副本是leader。
+// 锁的值是参选者的身份，因此可以通过Leader查看当前的leader是谁
+type LeaderElector struct {
+	locker Locker
+	// 竞选使用的锁的key
+	key string
+	// 参选者的身份，需要在所有副本中唯一，例如主机名
+	identity  string
+	callbacks LeaderCallbacks
+	// 锁的过期时间，leader宕机之后最多经过这么长时间其他副本才能接管
+	leaseDuration time.Duration
+	// 续约的间隔
+	renewInterval time.Duration
+	// 每次续约的超时时间
+	renewTimeout time.Duration
+	// 没有抢到锁时的竞选间隔
+	retryPeriod time.Duration
+	// 竞选和续约过程中出错的回调
+	onErr func(err error)
+
+	// 当前是否是leader
+	mu       sync.RWMutex
+	isLeader bool
+}
+
+// LeaderElectorWithLeaseDuration 设置锁的过期时间，默认为DefaultLeaseDuration，续约间隔为过期时间的三分之一
+func LeaderElectorWithLeaseDuration(d time.Duration) LeaderElectorOption {
+	return func(e *LeaderElector) {
+		e.leaseDuration = d
+		e.renewInterval = d / 3
+	}
+}
+
+// LeaderElectorWithRenewTimeout 设置每次续约的超时时间
+func LeaderElectorWithRenewTimeout(d time.Duration) LeaderElectorOption {
+	return func(e *LeaderElector) {
+		e.renewTimeout = d
+	}
+}
+
+// LeaderElectorWithRetryPeriod 设置没有抢到锁时的竞选间隔
+func LeaderElectorWithRetryPeriod(d time.Duration) LeaderElectorOption {
+	return func(e *LeaderElector) {
+		e.retryPeriod = d
+	}
+}
+
+// LeaderElectorWithErrHandler 设置竞选和续约出错的回调，用于记录日志
+func LeaderElectorWithErrHandler(fn func(err error)) LeaderElectorOption {
+	return func(e *LeaderElector) {
+		e.onErr = fn
+	}
+}
+
+func NewLeaderElector(locker Locker, key, identity string, callbacks LeaderCallbacks,
+	opts ...LeaderElectorOption) *LeaderElector {
+	e := &LeaderElector{
+		locker:        locker,
+		key:           key,
+		identity:      identity,
+		callbacks:     callbacks,
+		leaseDuration: DefaultLeaseDuration,
+		renewInterval: DefaultLeaseDuration / 3,
+		renewTimeout:  DefaultRenewTimeout,
+		retryPeriod:   DefaultRetryPeriod,
+		onErr:         func(err error) {},
+	}
+
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	if e.callbacks.OnStoppedLeading == nil {
+		e.callbacks.OnStoppedLeading = func() {}
+	}
+	return e
+}
+
+// Run 参与竞选，会一直阻塞直到ctx结束。失去leader身份之后会重新参与竞选，
+// ctx结束时如果是leader，会等待OnStartedLeading返回之后释放锁，让其他副本可以立刻接管
+func (e *LeaderElector) Run(ctx context.Context) error {
+	for {
+		lock, err := e.campaign(ctx)
+		if err != nil {
+			return err
+		}
+		e.lead(ctx, lock)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// IsLeader 当前是否是leader
+func (e *LeaderElector) IsLeader() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.isLeader
+}
+
+// Identity 参选者自己的身份
+func (e *LeaderElector) Identity() string {
+	return e.identity
+}
+
+// Leader 返回当前leader的身份，没有leader时返回ErrLockNotExist
+func (e *LeaderElector) Leader(ctx context.Context) (string, error) {
+	if e.IsLeader() {
+		return e.identity, nil
+	}
+	return e.locker.Holder(ctx, e.key)
+}
+
+// campaign 每隔retryPeriod尝试抢一次锁，直到抢到锁或者ctx结束
+func (e *LeaderElector) campaign(ctx context.Context) (*Lock, error) {
+	ticker := time.NewTicker(e.retryPeriod)
+	defer ticker.Stop()
+	for {
+		c, cancel := context.WithTimeout(ctx, e.renewTimeout)
+		lock, err := e.locker.TryLock(c, e.key, e.leaseDuration, LockWithValue(e.identity))
+		cancel()
+		if err == nil {
+			return lock, nil
+		}
+		if !errors.Is(err, ErrFailedToRaceLock) {
+			e.onErr(err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// lead 成为leader之后的处理，直到ctx结束或者锁丢失
+func (e *LeaderElector) lead(ctx context.Context, lock *Lock) {
+	lock.StartAutoRefresh(e.renewInterval, e.renewTimeout, AutoRefreshWithErrHandler(e.onErr))
+	leaderCtx, cancel := WithLockContext(ctx, lock)
+	defer cancel()
+	e.setLeader(true)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.callbacks.OnStartedLeading(leaderCtx)
+	}()
+
+	<-leaderCtx.Done()
+	<-done
+	e.setLeader(false)
+
+	// 主动让出leader，锁已经丢失的时候解锁会失败，忽略即可
+	unlockCtx, unlockCancel := context.WithTimeout(context.Background(), e.renewTimeout)
+	if err := lock.Unlock(unlockCtx); err != nil && !errors.Is(err, ErrLockNotHold) {
+		e.onErr(err)
+	}
+	unlockCancel()
+	e.callbacks.OnStoppedLeading()
+}
+
+func (e *LeaderElector) setLeader(isLeader bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.isLeader = isLeader
+}
@@ -0,0 +1,103 @@
+package distributed_lock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCandidate 参选者以及它的回调记录
+type testCandidate struct {
+	elector *LeaderElector
+	started chan struct{}
+	stopped chan struct{}
+	cancel  context.CancelFunc
+	done    chan error
+}
+
+func newTestCandidate(locker Locker, identity string) *testCandidate {
+	c := &testCandidate{
+		started: make(chan struct{}, 10),
+		stopped: make(chan struct{}, 10),
+		done:    make(chan error, 1),
+	}
+	c.elector = NewLeaderElector(locker, "leader", identity, LeaderCallbacks{
+		OnStartedLeading: func(ctx context.Context) {
+			c.started <- struct{}{}
+			<-ctx.Done()
+		},
+		OnStoppedLeading: func() {
+			c.stopped <- struct{}{}
+		},
+	}, LeaderElectorWithLeaseDuration(300*time.Millisecond),
+		LeaderElectorWithRetryPeriod(20*time.Millisecond),
+		LeaderElectorWithRenewTimeout(50*time.Millisecond))
+
+	var ctx context.Context
+	ctx, c.cancel = context.WithCancel(context.Background())
+	go func() {
+		c.done <- c.elector.Run(ctx)
+	}()
+	return c
+}
+
+func waitSignal(t *testing.T, ch <-chan struct{}, msg string) {
+	select {
+	case <-ch:
+	case <-time.After(2 * time.Second):
+		t.Fatal(msg)
+	}
+}
+
+func TestLeaderElector(t *testing.T) {
+	locker := NewMemoryDistributedLock()
+	first := newTestCandidate(locker, "node-1")
+	waitSignal(t, first.started, "node-1没有成为leader")
+
+	second := newTestCandidate(locker, "node-2")
+	// 超过租约时长之后node-1依旧是leader，说明在后台续约
+	time.Sleep(500 * time.Millisecond)
+	assert.True(t, first.elector.IsLeader())
+	assert.False(t, second.elector.IsLeader())
+	leader, err := second.elector.Leader(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "node-1", leader)
+
+	// node-1下线时主动让出leader，node-2接管
+	first.cancel()
+	waitSignal(t, first.stopped, "node-1没有让出leader")
+	assert.Equal(t, context.Canceled, <-first.done)
+	waitSignal(t, second.started, "node-2没有成为leader")
+	leader, err = second.elector.Leader(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "node-2", leader)
+
+	second.cancel()
+	waitSignal(t, second.stopped, "node-2没有让出leader")
+	assert.Equal(t, context.Canceled, <-second.done)
+	_, err = locker.Holder(context.Background(), "leader")
+	assert.Equal(t, ErrLockNotExist, err)
+}
+
+func TestLeaderElector_LeaseLost(t *testing.T) {
+	locker := NewMemoryDistributedLock()
+	c := newTestCandidate(locker, "node-1")
+	defer c.cancel()
+	waitSignal(t, c.started, "node-1没有成为leader")
+
+	// 模拟锁被别人抢走，续约失败之后失去leader身份，然后重新参与竞选
+	locker.mu.Lock()
+	locker.locks["leader"].val = "node-2"
+	locker.mu.Unlock()
+	waitSignal(t, c.stopped, "锁丢失之后node-1没有失去leader身份")
+	assert.False(t, c.elector.IsLeader())
+
+	locker.mu.Lock()
+	delete(locker.locks, "leader")
+	locker.mu.Unlock()
+	waitSignal(t, c.started, "node-1没有重新成为leader")
+	assert.True(t, c.elector.IsLeader())
+}
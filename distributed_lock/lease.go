@@ -0,0 +1,123 @@
+package distributed_lock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrLeaseReleased = errors.New("租约已经主动释放")
+)
+
+const (
+	// DefaultMaxRefreshRetry 后台自动续约时连续超时的默认重试次数
+	DefaultMaxRefreshRetry = 3
+)
+
+// lease 租约的公共逻辑，Lock和Permit共用
+// 租约丢失(续约失败)或者主动释放时会取消ctx，context.Cause可以拿到具体的原因
+type lease struct {
+	initOnce sync.Once
+	ctx      context.Context
+	cancel   context.CancelCauseFunc
+}
+
+// init Lock允许直接使用零值，所以延迟初始化
+func (le *lease) init() {
+	le.initOnce.Do(func() {
+		le.ctx, le.cancel = context.WithCancelCause(context.Background())
+	})
+}
+
+func (le *lease) context() context.Context {
+	le.init()
+	return le.ctx
+}
+
+// end 结束租约，只有第一次调用传入的cause会生效
+func (le *lease) end(cause error) {
+	le.init()
+	le.cancel(cause)
+}
+
+// autoRefresh 每隔interval调用一次refresh，timeout是每次调用的超时时间，超时后会立刻重试，
+// 连续超时超过maxRetry次(小于0表示不限制次数)或者refresh返回其它错误时结束租约并返回该错误，
+// 租约结束(包括主动释放)后正常退出。每一次续约失败都会回调onErr
+func (le *lease) autoRefresh(refresh func(ctx context.Context) error,
+	interval, timeout time.Duration, maxRetry int, onErr func(err error)) error {
+	done := le.context().Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	// context超时后通知重试的channel
+	retryCh := make(chan struct{}, 1)
+	retryCnt := 0
+	for {
+		select {
+		case <-ticker.C:
+			// 这里是正常的续约逻辑处理
+		case <-retryCh:
+			// 这里是context超时后处理重试的逻辑
+		case <-done:
+			return nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := refresh(ctx)
+		cancel()
+		if err == nil {
+			retryCnt = 0
+			continue
+		}
+
+		onErr(err)
+		if errors.Is(err, context.DeadlineExceeded) && (maxRetry < 0 || retryCnt < maxRetry) {
+			retryCnt++
+			// timeout不小于interval时ticker可能先触发，这时channel里已经有一次重试了，不能阻塞
+			select {
+			case retryCh <- struct{}{}:
+			default:
+			}
+			continue
+		}
+		le.end(err)
+		return err
+	}
+}
+
+// AutoRefreshOption 后台自动续约的配置项
+type AutoRefreshOption func(*autoRefreshConfig)
+
+type autoRefreshConfig struct {
+	// 连续超时的最大重试次数
+	maxRetry int
+	// 续约失败的回调
+	onErr func(err error)
+}
+
+func newAutoRefreshConfig(opts []AutoRefreshOption) *autoRefreshConfig {
+	cfg := &autoRefreshConfig{
+		maxRetry: DefaultMaxRefreshRetry,
+		onErr:    func(err error) {},
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// AutoRefreshWithMaxRetry 设置续约连续超时的最大重试次数，小于0表示不限制
+func AutoRefreshWithMaxRetry(maxRetry int) AutoRefreshOption {
+	return func(cfg *autoRefreshConfig) {
+		cfg.maxRetry = maxRetry
+	}
+}
+
+// AutoRefreshWithErrHandler 设置续约失败的回调，每一次续约失败都会调用，
+// 租约最终丢失时可以通过Lock.Context拿到原因
+func AutoRefreshWithErrHandler(fn func(err error)) AutoRefreshOption {
+	return func(cfg *autoRefreshConfig) {
+		cfg.onErr = fn
+	}
+}
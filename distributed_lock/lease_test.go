@@ -0,0 +1,34 @@
+package distributed_lock
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLease_AutoRefreshTimeoutLongerThanInterval(t *testing.T) {
+	var le lease
+	var calls atomic.Int64
+	// 每次续约都等到超时，超时时间比续约间隔长
+	refresh := func(ctx context.Context) error {
+		calls.Add(1)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- le.autoRefresh(refresh, 5*time.Millisecond, 20*time.Millisecond, 10, func(err error) {})
+	}()
+	select {
+	case err := <-errCh:
+		assert.Equal(t, context.DeadlineExceeded, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("自动续约没有在超过重试次数之后退出")
+	}
+	assert.Equal(t, int64(11), calls.Load())
+	assert.Equal(t, context.DeadlineExceeded, context.Cause(le.context()))
+}
@@ -0,0 +1,89 @@
+package distributed_lock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Lock 锁
+type Lock struct {
+	// 锁的key
+	key string
+	// 锁的唯一标识，防止释放掉别人的锁
+	val string
+	// 过期时间，用于手动续约刷新过期时间
+	expiration time.Duration
+	// 锁的存储后端
+	client lockClient
+	// once 防止多次释放锁
+	once sync.Once
+	// lease 租约状态，续约失败或者解锁时通知业务方和自动续约
+	lease lease
+}
+
+// Context 返回一个跟锁的租约绑定的context，续约失败(锁已经丢失)或者解锁时会被取消，
+// 可以通过context.Cause拿到原因，例如ErrLockNotHold、ErrLeaseReleased
+func (l *Lock) Context() context.Context {
+	return l.lease.context()
+}
+
+// AutoRefresh 自动续约机制，timeout是每次调用存储后端的context超时时间，interval是每次续约的间隔时间
+// 该方法会阻塞直到解锁或者续约失败，续约超时会一直重试，续约失败时Context会被取消
+func (l *Lock) AutoRefresh(interval, timeout time.Duration) error {
+	return l.lease.autoRefresh(l.Refresh, interval, timeout, -1, func(err error) {})
+}
+
+// StartAutoRefresh 在后台自动续约，不会阻塞调用方
+// 续约连续超时的次数有上限，默认为DefaultMaxRefreshRetry，超过上限或者锁已经丢失时停止续约并取消Context，
+// 续约过程中的错误通过AutoRefreshWithErrHandler设置的回调通知
+func (l *Lock) StartAutoRefresh(interval, timeout time.Duration, opts ...AutoRefreshOption) {
+	cfg := newAutoRefreshConfig(opts)
+	go func() {
+		_ = l.lease.autoRefresh(l.Refresh, interval, timeout, cfg.maxRetry, cfg.onErr)
+	}()
+}
+
+// Refresh 手动给锁续约
+func (l *Lock) Refresh(ctx context.Context) error {
+	err := l.client.refresh(ctx, l.key, l.val, l.expiration)
+	if err != nil {
+		l.client.recorder().IncRefreshFailure(l.key, err)
+	}
+	return err
+}
+
+// Unlock 解锁，因为TryLock返回的是*Lock，所以直接定义为Lock的方法
+// 不管解锁是否成功，都会停止自动续约并取消Context
+func (l *Lock) Unlock(ctx context.Context) error {
+	var unlockErr error
+	l.once.Do(func() {
+		defer l.lease.end(ErrLeaseReleased)
+		unlockErr = l.client.unlock(ctx, l.key, l.val)
+	})
+
+	return unlockErr
+}
+
+func newLock(client lockClient, key, val string, expiration time.Duration) *Lock {
+	return &Lock{
+		key:        key,
+		val:        val,
+		client:     client,
+		expiration: expiration,
+	}
+}
+
+// WithLockContext 返回一个parent的子context，parent结束或者锁的租约结束时都会被取消，
+// 业务代码使用它就能在锁丢失时及时退出
+func WithLockContext(parent context.Context, l *Lock) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	lockCtx := l.Context()
+	stop := context.AfterFunc(lockCtx, func() {
+		cancel(context.Cause(lockCtx))
+	})
+	return ctx, func() {
+		stop()
+		cancel(context.Canceled)
+	}
+}
@@ -0,0 +1,131 @@
+package distributed_lock
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/liquanhui-99/gotool/retry"
+)
+
+// Locker 分布式锁的抽象，Redis、SQL和内存实现都满足该接口，业务代码依赖Locker就可以切换存储后端
+type Locker interface {
+	// Lock 加锁，锁被别人持有时按照strategy重试，timeout是每次尝试的超时时间，expiration是锁的过期时间
+	Lock(ctx context.Context, key string, timeout, expiration time.Duration,
+		strategy RetryStrategy, opts ...LockOption) (*Lock, error)
+	// TryLock 尝试加锁一次，锁被别人持有时返回ErrFailedToRaceLock
+	TryLock(ctx context.Context, key string, expiration time.Duration, opts ...LockOption) (*Lock, error)
+	// Holder 返回锁当前持有者的唯一标识，也就是加锁时的锁值，锁不存在时返回ErrLockNotExist
+	Holder(ctx context.Context, key string) (string, error)
+	// Inspect 返回锁的持有者和剩余过期时间，锁不存在时返回ErrLockNotExist
+	Inspect(ctx context.Context, key string) (*LockInfo, error)
+}
+
+// LockOption 加锁的配置项
+type LockOption func(*lockConfig)
+
+type lockConfig struct {
+	// 锁的唯一标识
+	val string
+}
+
+func newLockConfig(opts []LockOption) *lockConfig {
+	cfg := &lockConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.val == "" {
+		cfg.val = uuid.New().String()
+	}
+	return cfg
+}
+
+// LockWithValue 使用val作为锁的唯一标识，默认是随机的UUID，
+// 可以设置成持有者的身份(例如主机名)，方便通过Holder查看是谁持有了锁。
+// 调用方需要保证不同的持有者使用不同的val，相同的val会被当成同一个持有者
+func LockWithValue(val string) LockOption {
+	return func(cfg *lockConfig) {
+		cfg.val = val
+	}
+}
+
+var (
+	_ Locker = (*RedisDistributedLock)(nil)
+	_ Locker = (*MemoryDistributedLock)(nil)
+	_ Locker = (*SQLDistributedLock)(nil)
+)
+
+// lockClient 锁的存储后端，Lock通过它完成续约和解锁
+type lockClient interface {
+	// refresh 给val持有的锁续约，锁不存在或者被别人持有时返回ErrLockNotHold
+	refresh(ctx context.Context, key, val string, expiration time.Duration) error
+	// unlock 释放val持有的锁，锁不存在或者被别人持有时返回ErrLockNotHold
+	unlock(ctx context.Context, key, val string) error
+	// recorder 记录续约失败等指标
+	recorder() MetricsRecorder
+}
+
+// tryLockOnce 各个存储后端共用的TryLock逻辑，tryLock返回false时返回ErrFailedToRaceLock
+func tryLockOnce(ctx context.Context, key string, m MetricsRecorder,
+	tryLock func(ctx context.Context) (bool, error)) error {
+	start := time.Now()
+	ok, err := tryLock(ctx)
+	if err == nil && !ok {
+		m.IncContention(key)
+		err = ErrFailedToRaceLock
+	}
+	m.ObserveAcquire(key, time.Since(start), err)
+	return err
+}
+
+// lock 各个存储后端共用的加锁重试逻辑，tryLock返回true表示加锁成功。
+// 和retry.Do一样，开始之前重置strategy，同一个strategy可以在多次加锁之间复用
+func lock(ctx context.Context, key string, timeout time.Duration, strategy RetryStrategy, m MetricsRecorder,
+	tryLock func(ctx context.Context) (bool, error)) (err error) {
+	start := time.Now()
+	defer func() {
+		m.ObserveAcquire(key, time.Since(start), err)
+	}()
+	if r, ok := strategy.(retry.Resetter); ok {
+		r.Reset()
+	}
+
+	var timer *time.Timer
+	for {
+		c, cancel := context.WithTimeout(ctx, timeout)
+		ok, err := tryLock(c)
+		cancel()
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+
+		if ok {
+			return nil
+		}
+		if err == nil {
+			m.IncContention(key)
+		}
+
+		interval, ok := strategy.Next(err)
+		if !ok {
+			return ErrOverMaxCount
+		}
+		m.IncRetry(key)
+
+		if timer == nil {
+			timer = time.NewTimer(interval)
+		} else {
+			timer.Reset(interval)
+		}
+
+		select {
+		case <-timer.C:
+			// 进行下一轮重试
+		case <-ctx.Done():
+			// context超时
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
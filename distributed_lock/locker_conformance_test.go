@@ -0,0 +1,253 @@
+package distributed_lock
+
+import (
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/liquanhui-99/gotool/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lockerSuite 所有Locker实现共用的一致性测试
+type lockerSuite struct {
+	// newLocker 每个测试用例都会调用一次
+	newLocker func(t *testing.T) Locker
+	// expiration 测试过期相关逻辑使用的过期时间，不同的存储后端精度不同
+	expiration time.Duration
+}
+
+func (s lockerSuite) run(t *testing.T) {
+	t.Run("try lock", s.testTryLock)
+	t.Run("try lock same value", s.testTryLockSameValue)
+	t.Run("unlock", s.testUnlock)
+	t.Run("expiration", s.testExpiration)
+	t.Run("refresh", s.testRefresh)
+	t.Run("lock with retry", s.testLockWithRetry)
+	t.Run("lock over max count", s.testLockOverMaxCount)
+	t.Run("reuse strategy", s.testReuseStrategy)
+	t.Run("concurrent try lock", s.testConcurrentTryLock)
+	t.Run("holder", s.testHolder)
+	t.Run("inspect", s.testInspect)
+}
+
+func (s lockerSuite) testTryLock(t *testing.T) {
+	l := s.newLocker(t)
+	ctx := context.Background()
+	lock, err := l.TryLock(ctx, "try-lock", time.Minute)
+	require.NoError(t, err)
+	defer func() {
+		_ = lock.Unlock(ctx)
+	}()
+
+	_, err = l.TryLock(ctx, "try-lock", time.Minute)
+	assert.Equal(t, ErrFailedToRaceLock, err)
+
+	// 不同的key互不影响
+	other, err := l.TryLock(ctx, "try-lock-other", time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, other.Unlock(ctx))
+}
+
+func (s lockerSuite) testTryLockSameValue(t *testing.T) {
+	l := s.newLocker(t)
+	ctx := context.Background()
+	lock, err := l.TryLock(ctx, "try-lock-same-value", time.Minute, LockWithValue("node-1"))
+	require.NoError(t, err)
+
+	// TryLock不可重入，锁值相同也会失败，也不会续约
+	_, err = l.TryLock(ctx, "try-lock-same-value", 2*time.Minute, LockWithValue("node-1"))
+	assert.Equal(t, ErrFailedToRaceLock, err)
+	info, err := l.Inspect(ctx, "try-lock-same-value")
+	require.NoError(t, err)
+	assert.True(t, info.TTL <= time.Minute)
+
+	// Lock的重试需要处理上一次超时但是实际加锁成功的情况，锁值相同时直接续约
+	relock, err := l.Lock(ctx, "try-lock-same-value", time.Second, time.Minute,
+		retry.WithMaxAttempts(retry.NewConstantStrategy(time.Millisecond), 1), LockWithValue("node-1"))
+	require.NoError(t, err)
+	require.NoError(t, relock.Unlock(ctx))
+	assert.Equal(t, ErrLockNotHold, lock.Unlock(ctx))
+}
+
+func (s lockerSuite) testUnlock(t *testing.T) {
+	l := s.newLocker(t)
+	ctx := context.Background()
+	lock, err := l.TryLock(ctx, "unlock", time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, lock.Unlock(ctx))
+	// 重复解锁不会报错
+	require.NoError(t, lock.Unlock(ctx))
+	// 不能续约已经释放的锁
+	assert.Equal(t, ErrLockNotHold, lock.Refresh(ctx))
+
+	// 解锁之后其他人可以加锁
+	lock, err = l.TryLock(ctx, "unlock", time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, lock.Unlock(ctx))
+}
+
+func (s lockerSuite) testExpiration(t *testing.T) {
+	l := s.newLocker(t)
+	ctx := context.Background()
+	lock, err := l.TryLock(ctx, "expiration", s.expiration)
+	require.NoError(t, err)
+	time.Sleep(s.expiration + s.expiration/2)
+
+	// 锁过期之后其他人可以加锁，原来的持有者不能续约也不能解锁
+	other, err := l.TryLock(ctx, "expiration", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, ErrLockNotHold, lock.Refresh(ctx))
+	assert.Equal(t, ErrLockNotHold, lock.Unlock(ctx))
+	require.NoError(t, other.Unlock(ctx))
+}
+
+func (s lockerSuite) testRefresh(t *testing.T) {
+	l := s.newLocker(t)
+	ctx := context.Background()
+	lock, err := l.TryLock(ctx, "refresh", s.expiration)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		time.Sleep(s.expiration / 2)
+		require.NoError(t, lock.Refresh(ctx))
+	}
+
+	// 持续续约，超过过期时间之后锁依旧被持有
+	_, err = l.TryLock(ctx, "refresh", time.Minute)
+	assert.Equal(t, ErrFailedToRaceLock, err)
+	require.NoError(t, lock.Unlock(ctx))
+}
+
+func (s lockerSuite) testLockWithRetry(t *testing.T) {
+	l := s.newLocker(t)
+	ctx := context.Background()
+	holder, err := l.TryLock(ctx, "lock-with-retry", time.Minute)
+	require.NoError(t, err)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = holder.Unlock(ctx)
+	}()
+
+	lock, err := l.Lock(ctx, "lock-with-retry", time.Second, time.Minute,
+		retry.WithMaxAttempts(retry.NewConstantStrategy(20*time.Millisecond), 100))
+	require.NoError(t, err)
+	require.NoError(t, lock.Unlock(ctx))
+}
+
+func (s lockerSuite) testLockOverMaxCount(t *testing.T) {
+	l := s.newLocker(t)
+	ctx := context.Background()
+	holder, err := l.TryLock(ctx, "lock-over-max-count", time.Minute)
+	require.NoError(t, err)
+	defer func() {
+		_ = holder.Unlock(ctx)
+	}()
+
+	_, err = l.Lock(ctx, "lock-over-max-count", time.Second, time.Minute,
+		retry.WithMaxAttempts(retry.NewConstantStrategy(time.Millisecond), 3))
+	assert.Equal(t, ErrOverMaxCount, err)
+}
+
+// testReuseStrategy 同一个strategy用于多次加锁，每次加锁都从头开始计算重试次数
+func (s lockerSuite) testReuseStrategy(t *testing.T) {
+	l := s.newLocker(t)
+	ctx := context.Background()
+	holder, err := l.TryLock(ctx, "reuse-strategy", time.Minute)
+	require.NoError(t, err)
+
+	strategy := retry.WithMaxAttempts(retry.NewConstantStrategy(50*time.Millisecond), 4)
+	_, err = l.Lock(ctx, "reuse-strategy", time.Second, time.Minute, strategy)
+	assert.Equal(t, ErrOverMaxCount, err)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = holder.Unlock(ctx)
+	}()
+	lock, err := l.Lock(ctx, "reuse-strategy", time.Second, time.Minute, strategy)
+	require.NoError(t, err)
+	require.NoError(t, lock.Unlock(ctx))
+}
+
+func (s lockerSuite) testConcurrentTryLock(t *testing.T) {
+	l := s.newLocker(t)
+	ctx := context.Background()
+	var (
+		wg      sync.WaitGroup
+		success int64
+		mu      sync.Mutex
+		locks   []*Lock
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lock, err := l.TryLock(ctx, "concurrent-try-lock", time.Minute)
+			if err != nil {
+				assert.Equal(t, ErrFailedToRaceLock, err)
+				return
+			}
+			atomic.AddInt64(&success, 1)
+			mu.Lock()
+			locks = append(locks, lock)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(1), success)
+	for _, lock := range locks {
+		require.NoError(t, lock.Unlock(ctx))
+	}
+}
+
+func (s lockerSuite) testHolder(t *testing.T) {
+	l := s.newLocker(t)
+	ctx := context.Background()
+	_, err := l.Holder(ctx, "holder")
+	assert.Equal(t, ErrLockNotExist, err)
+
+	lock, err := l.TryLock(ctx, "holder", time.Minute, LockWithValue("node-1"))
+	require.NoError(t, err)
+	holder, err := l.Holder(ctx, "holder")
+	require.NoError(t, err)
+	assert.Equal(t, "node-1", holder)
+
+	_, err = l.Lock(ctx, "holder", time.Second, time.Minute,
+		retry.WithMaxAttempts(retry.NewConstantStrategy(time.Millisecond), 1), LockWithValue("node-2"))
+	assert.Equal(t, ErrOverMaxCount, err)
+
+	require.NoError(t, lock.Unlock(ctx))
+	_, err = l.Holder(ctx, "holder")
+	assert.Equal(t, ErrLockNotExist, err)
+}
+
+func (s lockerSuite) testInspect(t *testing.T) {
+	l := s.newLocker(t)
+	ctx := context.Background()
+	_, err := l.Inspect(ctx, "inspect")
+	assert.Equal(t, ErrLockNotExist, err)
+
+	lock, err := l.TryLock(ctx, "inspect", time.Minute, LockWithHolderInfo())
+	require.NoError(t, err)
+	info, err := l.Inspect(ctx, "inspect")
+	require.NoError(t, err)
+	assert.Equal(t, "inspect", info.Key)
+	assert.Equal(t, lock.val, info.Value)
+	assert.True(t, info.TTL > 0 && info.TTL <= time.Minute)
+	require.NotNil(t, info.Holder)
+	assert.Equal(t, os.Getpid(), info.Holder.PID)
+	require.NoError(t, lock.Unlock(ctx))
+
+	// 普通的锁值没有持有者的元数据
+	lock, err = l.TryLock(ctx, "inspect", time.Minute, LockWithValue("node-1"))
+	require.NoError(t, err)
+	info, err = l.Inspect(ctx, "inspect")
+	require.NoError(t, err)
+	assert.Equal(t, "node-1", info.Value)
+	assert.Nil(t, info.Holder)
+	require.NoError(t, lock.Unlock(ctx))
+}
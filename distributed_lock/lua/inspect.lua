@@ -0,0 +1,6 @@
+-- 同时返回锁值和剩余的过期时间(毫秒)，锁不存在时返回nil
+local val = redis.call("GET", KEYS[1])
+if val == false then
+    return false
+end
+return {val, redis.call("PTTL", KEYS[1])}
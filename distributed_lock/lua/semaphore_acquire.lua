@@ -0,0 +1,22 @@
+---
+--- 基于有序集合实现的信号量，member是持有者的唯一标识，score是持有者的过期时间(毫秒)
+--- KEYS[1] 信号量的key
+--- ARGV[1] 持有者的唯一标识
+--- ARGV[2] 信号量的许可数
+--- ARGV[3] 过期时间(毫秒)
+---
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local ttl = tonumber(ARGV[3])
+-- 先回收已经过期的持有者
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+if redis.call("ZSCORE", KEYS[1], ARGV[1]) == false and redis.call("ZCARD", KEYS[1]) >= tonumber(ARGV[2]) then
+    -- 许可已经被占满了
+    return ""
+end
+-- 新加入的持有者，或者上次已经获取成功了，直接续约即可
+redis.call("ZADD", KEYS[1], now + ttl, ARGV[1])
+if redis.call("PTTL", KEYS[1]) < ttl then
+    redis.call("PEXPIRE", KEYS[1], ttl)
+end
+return "OK"
@@ -0,0 +1,18 @@
+---
+--- 给信号量的持有者续约，持有者已经过期或者不存在则返回0
+--- KEYS[1] 信号量的key
+--- ARGV[1] 持有者的唯一标识
+--- ARGV[2] 过期时间(毫秒)
+---
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local ttl = tonumber(ARGV[2])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+if redis.call("ZSCORE", KEYS[1], ARGV[1]) == false then
+    return 0
+end
+redis.call("ZADD", KEYS[1], "XX", now + ttl, ARGV[1])
+if redis.call("PTTL", KEYS[1]) < ttl then
+    redis.call("PEXPIRE", KEYS[1], ttl)
+end
+return 1
@@ -0,0 +1,17 @@
+---
+--- 释放信号量的许可，持有者已经过期或者不存在则返回0
+--- KEYS[1] 信号量的key
+--- ARGV[1] 持有者的唯一标识
+---
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local score = redis.call("ZSCORE", KEYS[1], ARGV[1])
+if score == false then
+    return 0
+end
+redis.call("ZREM", KEYS[1], ARGV[1])
+-- 已经过期但是还没有被回收的许可可能已经被别人占用了，不能算释放成功
+if tonumber(score) <= now then
+    return 0
+end
+return 1
@@ -0,0 +1,29 @@
+package distributed_lock
+
+import "time"
+
+// MetricsRecorder 锁的指标记录器，可以对接Prometheus等监控系统，实现需要是并发安全的
+type MetricsRecorder interface {
+	// ObserveAcquire 记录一次Lock或者TryLock的耗时，err为nil表示加锁成功
+	ObserveAcquire(key string, latency time.Duration, err error)
+	// IncContention 尝试加锁时锁被别人持有
+	IncContention(key string)
+	// IncRetry 加锁失败之后按照重试策略进行了一次重试
+	IncRetry(key string)
+	// IncRefreshFailure 续约失败，包括续约超时和锁已经丢失
+	IncRefreshFailure(key string, err error)
+}
+
+// NopMetricsRecorder 什么都不记录，是各个存储后端的默认值，
+// 只关心部分指标时可以嵌入它，只实现需要的方法
+type NopMetricsRecorder struct{}
+
+var _ MetricsRecorder = NopMetricsRecorder{}
+
+func (NopMetricsRecorder) ObserveAcquire(key string, latency time.Duration, err error) {}
+
+func (NopMetricsRecorder) IncContention(key string) {}
+
+func (NopMetricsRecorder) IncRetry(key string) {}
+
+func (NopMetricsRecorder) IncRefreshFailure(key string, err error) {}
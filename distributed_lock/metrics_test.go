@@ -0,0 +1,67 @@
+package distributed_lock
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/liquanhui-99/gotool/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordMetrics 记录所有指标的MetricsRecorder
+type recordMetrics struct {
+	mu              sync.Mutex
+	acquireErrs     []error
+	contention      int
+	retry           int
+	refreshFailures []error
+}
+
+func (r *recordMetrics) ObserveAcquire(key string, latency time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.acquireErrs = append(r.acquireErrs, err)
+}
+
+func (r *recordMetrics) IncContention(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.contention++
+}
+
+func (r *recordMetrics) IncRetry(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.retry++
+}
+
+func (r *recordMetrics) IncRefreshFailure(key string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refreshFailures = append(r.refreshFailures, err)
+}
+
+func TestMetricsRecorder(t *testing.T) {
+	m := &recordMetrics{}
+	locker := NewMemoryDistributedLock(MemoryDistributedLockWithMetrics(m))
+	ctx := context.Background()
+
+	lock, err := locker.TryLock(ctx, "key", time.Minute)
+	require.NoError(t, err)
+	_, err = locker.TryLock(ctx, "key", time.Minute)
+	assert.Equal(t, ErrFailedToRaceLock, err)
+	_, err = locker.Lock(ctx, "key", time.Second, time.Minute,
+		retry.WithMaxAttempts(retry.NewConstantStrategy(time.Millisecond), 2))
+	assert.Equal(t, ErrOverMaxCount, err)
+	require.NoError(t, lock.Unlock(ctx))
+	assert.Equal(t, ErrLockNotHold, lock.Refresh(ctx))
+
+	assert.Equal(t, []error{nil, ErrFailedToRaceLock, ErrOverMaxCount}, m.acquireErrs)
+	// TryLock失败一次，Lock一共尝试了三次
+	assert.Equal(t, 4, m.contention)
+	assert.Equal(t, 2, m.retry)
+	assert.Equal(t, []error{ErrLockNotHold}, m.refreshFailures)
+}
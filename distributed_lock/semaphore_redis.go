@@ -0,0 +1,146 @@
+package distributed_lock
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	ErrFailedToAcquirePermit = errors.New("获取信号量失败")
+	ErrPermitNotHold         = errors.New("你没有持有信号量")
+)
+
+//go:embed lua/semaphore_acquire.lua
+var semaphoreAcquireScript string
+
+//go:embed lua/semaphore_refresh.lua
+var semaphoreRefreshScript string
+
+//go:embed lua/semaphore_release.lua
+var semaphoreReleaseScript string
+
+// RedisSemaphore 基于Redis有序集合实现的分布式信号量，同一个key最多允许permits个持有者同时持有，
+// 有序集合的member是持有者的唯一标识，score是持有者的过期时间，过期的持有者在下一次获取或者续约时被回收
+type RedisSemaphore struct {
+	client redis.Cmdable
+}
+
+func NewRedisSemaphore(client redis.Cmdable) *RedisSemaphore {
+	return &RedisSemaphore{
+		client: client,
+	}
+}
+
+// Acquire 获取信号量，获取失败时按照strategy进行重试，timeout是每次调用redis的超时时间，ttl是持有的过期时间
+func (s *RedisSemaphore) Acquire(ctx context.Context, key string, permits int,
+	timeout, ttl time.Duration,
+	strategy RetryStrategy) (*Permit, error) {
+	val := uuid.New().String()
+	err := lock(ctx, key, timeout, strategy, NopMetricsRecorder{}, func(ctx context.Context) (bool, error) {
+		res, err := s.client.Eval(ctx, semaphoreAcquireScript, []string{key}, val, permits, ttl.Milliseconds()).Result()
+		return res == "OK", err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newPermit(s.client, key, val, ttl), nil
+}
+
+// TryAcquire 尝试获取信号量，许可已经被占满时返回ErrFailedToAcquirePermit
+func (s *RedisSemaphore) TryAcquire(ctx context.Context, key string, permits int, ttl time.Duration) (*Permit, error) {
+	val := uuid.New().String()
+	res, err := s.client.Eval(ctx, semaphoreAcquireScript, []string{key}, val, permits, ttl.Milliseconds()).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	if res != "OK" {
+		return nil, ErrFailedToAcquirePermit
+	}
+
+	return newPermit(s.client, key, val, ttl), nil
+}
+
+// Permit 信号量的一个许可
+type Permit struct {
+	// 存储在redis中的key
+	key string
+	// 持有者的唯一标识，防止释放掉别人的许可
+	val string
+	// 过期时间，用于续约
+	ttl time.Duration
+	// redis
+	client redis.Cmdable
+	// once 防止多次释放
+	once sync.Once
+	// lease 租约状态，续约失败或者释放时通知业务方和自动续约
+	lease lease
+}
+
+func newPermit(client redis.Cmdable, key, val string, ttl time.Duration) *Permit {
+	return &Permit{
+		key:    key,
+		val:    val,
+		ttl:    ttl,
+		client: client,
+	}
+}
+
+// Context 返回跟许可的租约绑定的context，用法和Lock.Context一致
+func (p *Permit) Context() context.Context {
+	return p.lease.context()
+}
+
+// AutoRefresh 自动续约，会阻塞直到许可被释放或者续约失败，用法和Lock.AutoRefresh一致
+func (p *Permit) AutoRefresh(interval, timeout time.Duration) error {
+	return p.lease.autoRefresh(p.Refresh, interval, timeout, -1, func(err error) {})
+}
+
+// StartAutoRefresh 在后台自动续约，用法和Lock.StartAutoRefresh一致
+func (p *Permit) StartAutoRefresh(interval, timeout time.Duration, opts ...AutoRefreshOption) {
+	cfg := newAutoRefreshConfig(opts)
+	go func() {
+		_ = p.lease.autoRefresh(p.Refresh, interval, timeout, cfg.maxRetry, cfg.onErr)
+	}()
+}
+
+// Refresh 手动续约，许可已经过期被回收时返回ErrPermitNotHold
+func (p *Permit) Refresh(ctx context.Context) error {
+	res, err := p.client.Eval(ctx, semaphoreRefreshScript, []string{p.key}, p.val, p.ttl.Milliseconds()).Int64()
+	if err != nil {
+		return err
+	}
+
+	if res != 1 {
+		return ErrPermitNotHold
+	}
+
+	return nil
+}
+
+// Release 释放许可，同时停止自动续约。许可已经过期时返回ErrPermitNotHold，即使它还没有被回收
+func (p *Permit) Release(ctx context.Context) error {
+	var releaseErr error
+	p.once.Do(func() {
+		defer p.lease.end(ErrLeaseReleased)
+
+		res, err := p.client.Eval(ctx, semaphoreReleaseScript, []string{p.key}, p.val).Int64()
+		if err != nil {
+			releaseErr = err
+			return
+		}
+
+		if res != 1 {
+			releaseErr = ErrPermitNotHold
+		}
+	})
+
+	return releaseErr
+}
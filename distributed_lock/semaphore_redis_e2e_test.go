@@ -0,0 +1,55 @@
+//go:build e2e
+
+package distributed_lock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisSemaphore_e2e(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     "122.9.137.145:6319",
+		Password: "123456",
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	key := "semaphore_e2e"
+	defer client.Del(ctx, key)
+
+	s := NewRedisSemaphore(client)
+	p1, err := s.TryAcquire(ctx, key, 2, time.Minute)
+	require.NoError(t, err)
+	p2, err := s.TryAcquire(ctx, key, 2, time.Minute)
+	require.NoError(t, err)
+
+	// 许可已经被占满
+	_, err = s.TryAcquire(ctx, key, 2, time.Minute)
+	assert.Equal(t, ErrFailedToAcquirePermit, err)
+
+	require.NoError(t, p1.Refresh(ctx))
+	require.NoError(t, p1.Release(ctx))
+	// 释放之后可以重新获取
+	p3, err := s.TryAcquire(ctx, key, 2, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, p3.Release(ctx))
+
+	// 模拟持有者过期，过期的持有者会被回收
+	require.NoError(t, client.ZAdd(ctx, key, redis.Z{Score: 0, Member: p2.val}).Err())
+	assert.Equal(t, ErrPermitNotHold, p2.Refresh(ctx))
+	p4, err := s.TryAcquire(ctx, key, 1, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, p4.Release(ctx))
+
+	// 已经过期但是还没有被回收的许可不能释放成功
+	p5, err := s.TryAcquire(ctx, key, 1, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, client.ZAdd(ctx, key, redis.Z{Score: 0, Member: p5.val}).Err())
+	assert.Equal(t, ErrPermitNotHold, p5.Release(ctx))
+	assert.Equal(t, int64(0), client.ZCard(ctx, key).Val())
+}
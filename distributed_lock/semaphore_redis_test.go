@@ -0,0 +1,251 @@
+package distributed_lock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/liquanhui-99/gotool/cache/redis_cache/mocks"
+	"github.com/liquanhui-99/gotool/retry"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisSemaphore_TryAcquire(t *testing.T) {
+	testCases := []struct {
+		name    string
+		key     string
+		permits int
+		ttl     time.Duration
+		wantErr error
+		client  func(ctrl *gomock.Controller) redis.Cmdable
+	}{
+		{
+			name:    "acquire permit",
+			key:     "acquire permit",
+			permits: 3,
+			ttl:     time.Minute,
+			client: func(ctrl *gomock.Controller) redis.Cmdable {
+				cmd := mocks.NewMockCmdable(ctrl)
+				res := redis.NewCmd(context.Background())
+				res.SetVal("OK")
+				cmd.EXPECT().Eval(context.Background(), semaphoreAcquireScript, []string{"acquire permit"},
+					gomock.Any(), 3, int64(60000)).Return(res)
+				return cmd
+			},
+		},
+		{
+			name:    "permits exhausted",
+			key:     "permits exhausted",
+			permits: 3,
+			ttl:     time.Minute,
+			wantErr: ErrFailedToAcquirePermit,
+			client: func(ctrl *gomock.Controller) redis.Cmdable {
+				cmd := mocks.NewMockCmdable(ctrl)
+				res := redis.NewCmd(context.Background())
+				res.SetVal("")
+				cmd.EXPECT().Eval(context.Background(), semaphoreAcquireScript, []string{"permits exhausted"},
+					gomock.Any(), 3, int64(60000)).Return(res)
+				return cmd
+			},
+		},
+		{
+			name:    "acquire DeadlineExceeded",
+			key:     "acquire DeadlineExceeded",
+			permits: 3,
+			ttl:     time.Minute,
+			wantErr: context.DeadlineExceeded,
+			client: func(ctrl *gomock.Controller) redis.Cmdable {
+				cmd := mocks.NewMockCmdable(ctrl)
+				res := redis.NewCmd(context.Background())
+				res.SetErr(context.DeadlineExceeded)
+				cmd.EXPECT().Eval(context.Background(), semaphoreAcquireScript, []string{"acquire DeadlineExceeded"},
+					gomock.Any(), 3, int64(60000)).Return(res)
+				return cmd
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			s := NewRedisSemaphore(tc.client(ctrl))
+			p, err := s.TryAcquire(context.Background(), tc.key, tc.permits, tc.ttl)
+			require.Equal(t, tc.wantErr, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tc.key, p.key)
+			assert.NotEmpty(t, p.val)
+			assert.Equal(t, tc.ttl, p.ttl)
+		})
+	}
+}
+
+func TestRedisSemaphore_Acquire(t *testing.T) {
+	testCases := []struct {
+		name     string
+		key      string
+		strategy RetryStrategy
+		wantErr  error
+		client   func(ctrl *gomock.Controller) redis.Cmdable
+	}{
+		{
+			name:     "acquire after retry",
+			key:      "acquire after retry",
+			strategy: retry.WithMaxAttempts(retry.NewConstantStrategy(time.Millisecond), 3),
+			client: func(ctrl *gomock.Controller) redis.Cmdable {
+				cmd := mocks.NewMockCmdable(ctrl)
+				full := redis.NewCmd(context.Background())
+				full.SetVal("")
+				timeout := redis.NewCmd(context.Background())
+				timeout.SetErr(context.DeadlineExceeded)
+				ok := redis.NewCmd(context.Background())
+				ok.SetVal("OK")
+				gomock.InOrder(
+					cmd.EXPECT().Eval(gomock.Any(), semaphoreAcquireScript, []string{"acquire after retry"},
+						gomock.Any(), 2, int64(10000)).Return(full),
+					cmd.EXPECT().Eval(gomock.Any(), semaphoreAcquireScript, []string{"acquire after retry"},
+						gomock.Any(), 2, int64(10000)).Return(timeout),
+					cmd.EXPECT().Eval(gomock.Any(), semaphoreAcquireScript, []string{"acquire after retry"},
+						gomock.Any(), 2, int64(10000)).Return(ok),
+				)
+				return cmd
+			},
+		},
+		{
+			name:     "over max count",
+			key:      "over max count",
+			strategy: retry.WithMaxAttempts(retry.NewConstantStrategy(time.Millisecond), 2),
+			wantErr:  ErrOverMaxCount,
+			client: func(ctrl *gomock.Controller) redis.Cmdable {
+				cmd := mocks.NewMockCmdable(ctrl)
+				full := redis.NewCmd(context.Background())
+				full.SetVal("")
+				cmd.EXPECT().Eval(gomock.Any(), semaphoreAcquireScript, []string{"over max count"},
+					gomock.Any(), 2, int64(10000)).Return(full).Times(3)
+				return cmd
+			},
+		},
+		{
+			name:     "redis error",
+			key:      "redis error",
+			strategy: retry.WithMaxAttempts(retry.NewConstantStrategy(time.Millisecond), 2),
+			wantErr:  errors.New("network error"),
+			client: func(ctrl *gomock.Controller) redis.Cmdable {
+				cmd := mocks.NewMockCmdable(ctrl)
+				res := redis.NewCmd(context.Background())
+				res.SetErr(errors.New("network error"))
+				cmd.EXPECT().Eval(gomock.Any(), semaphoreAcquireScript, []string{"redis error"},
+					gomock.Any(), 2, int64(10000)).Return(res)
+				return cmd
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			s := NewRedisSemaphore(tc.client(ctrl))
+			p, err := s.Acquire(context.Background(), tc.key, 2, time.Second, 10*time.Second, tc.strategy)
+			require.Equal(t, tc.wantErr, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tc.key, p.key)
+		})
+	}
+}
+
+func TestPermit_Refresh(t *testing.T) {
+	testCases := []struct {
+		name    string
+		res     int64
+		err     error
+		wantErr error
+	}{
+		{
+			name: "refresh success",
+			res:  1,
+		},
+		{
+			name:    "permit expired",
+			res:     0,
+			wantErr: ErrPermitNotHold,
+		},
+		{
+			name:    "refresh DeadlineExceeded",
+			err:     context.DeadlineExceeded,
+			wantErr: context.DeadlineExceeded,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			cmd := mocks.NewMockCmdable(ctrl)
+			res := redis.NewCmd(context.Background())
+			if tc.err != nil {
+				res.SetErr(tc.err)
+			} else {
+				res.SetVal(tc.res)
+			}
+			cmd.EXPECT().Eval(context.Background(), semaphoreRefreshScript, []string{tc.name},
+				"permit-val", int64(60000)).Return(res)
+			p := newPermit(cmd, tc.name, "permit-val", time.Minute)
+			err := p.Refresh(context.Background())
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestPermit_Release(t *testing.T) {
+	testCases := []struct {
+		name    string
+		res     int64
+		err     error
+		wantErr error
+	}{
+		{
+			name: "release success",
+			res:  1,
+		},
+		{
+			name:    "permit not hold",
+			res:     0,
+			wantErr: ErrPermitNotHold,
+		},
+		{
+			name:    "release DeadlineExceeded",
+			err:     context.DeadlineExceeded,
+			wantErr: context.DeadlineExceeded,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			cmd := mocks.NewMockCmdable(ctrl)
+			res := redis.NewCmd(context.Background())
+			if tc.err != nil {
+				res.SetErr(tc.err)
+			} else {
+				res.SetVal(tc.res)
+			}
+			cmd.EXPECT().Eval(context.Background(), semaphoreReleaseScript, []string{tc.name}, "permit-val").Return(res)
+			p := newPermit(cmd, tc.name, "permit-val", time.Minute)
+			assert.Equal(t, tc.wantErr, p.Release(context.Background()))
+			// 重复释放不会再访问redis
+			assert.Nil(t, p.Release(context.Background()))
+			// 释放之后自动续约立刻退出
+			assert.Nil(t, p.AutoRefresh(time.Hour, time.Second))
+		})
+	}
+}
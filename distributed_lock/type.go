@@ -4,22 +4,23 @@ import (
 	"context"
 	"errors"
 	"time"
+
+	"github.com/liquanhui-99/gotool/retry"
 )
 
-// RetryStrategy 迭代器的模式实现重试策略
-type RetryStrategy interface {
-	// Next 有两个返回值，第一个标识重试的间隔，第二个标识是否进行重试
-	Next(err error) (time.Duration, bool)
-}
+// RetryStrategy 迭代器的模式实现重试策略，更多的策略实现见retry包
+type RetryStrategy = retry.Strategy
 
 // FixTimeIntervalStrategy 固定时间间隔策略
+//
+// Deprecated: 使用retry.NewConstantStrategy配合retry.WithMaxAttempts
 type FixTimeIntervalStrategy struct {
 	// 时间间隔
 	Interval time.Duration
+	// 最大的次数
+	MaxCnt int
 	// 当前的次数
 	cnt int
-	// 最大的次数
-	maxCnt int
 }
 
 func (f *FixTimeIntervalStrategy) Next(err error) (time.Duration, bool) {
@@ -28,10 +29,16 @@ func (f *FixTimeIntervalStrategy) Next(err error) (time.Duration, bool) {
 	}
 
 	f.cnt++
-	return f.Interval, f.cnt >= f.maxCnt
+	return f.Interval, f.cnt <= f.MaxCnt
+}
+
+func (f *FixTimeIntervalStrategy) Reset() {
+	f.cnt = 0
 }
 
 // ExponentialBackOffIntervalStrategy 使用指数退避实现动态的等待时间
+//
+// Deprecated: 使用retry.NewExponentialStrategy，支持抖动和重置
 type ExponentialBackOffIntervalStrategy struct {
 	// 最大的重试间隔
 	MaxInterval time.Duration
@@ -44,5 +51,5 @@ func (s *ExponentialBackOffIntervalStrategy) Next(err error) (time.Duration, boo
 		return s.CntInterval, false
 	}
 	s.CntInterval = s.CntInterval << 1
-	return s.CntInterval, s.CntInterval >= s.MaxInterval
+	return s.CntInterval, s.CntInterval <= s.MaxInterval
 }
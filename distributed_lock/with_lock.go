@@ -0,0 +1,91 @@
+package distributed_lock
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	DefaultLockTimeout    = time.Second      // WithLock默认每次访问存储后端的超时时间
+	DefaultLockExpiration = 30 * time.Second // WithLock默认的锁过期时间
+)
+
+// WithLockOptions WithLock的配置，零值字段使用默认值
+type WithLockOptions struct {
+	// Timeout 每次加锁、续约、解锁的超时时间，默认为DefaultLockTimeout
+	Timeout time.Duration
+	// Expiration 锁的过期时间，默认为DefaultLockExpiration
+	Expiration time.Duration
+	// Strategy 锁被别人持有时的重试策略，为nil时只尝试加锁一次，失败返回ErrFailedToRaceLock
+	Strategy RetryStrategy
+	// RefreshInterval 自动续约的间隔，默认为Expiration的三分之一
+	RefreshInterval time.Duration
+	// LockOptions 加锁的配置项，例如LockWithValue
+	LockOptions []LockOption
+}
+
+func (o WithLockOptions) withDefault() WithLockOptions {
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultLockTimeout
+	}
+	if o.Expiration <= 0 {
+		o.Expiration = DefaultLockExpiration
+	}
+	if o.RefreshInterval <= 0 {
+		o.RefreshInterval = o.Expiration / 3
+	}
+	return o
+}
+
+// WithLock 在锁的保护下执行fn：加锁、在后台自动续约、执行fn、解锁。
+// 传给fn的ctx在锁丢失时会被取消，fn需要监听ctx及时退出；
+// 不管fn是正常返回还是panic都会解锁，panic会在解锁之后继续向上抛出；
+// 返回值是fn的错误和解锁错误合并之后的结果，可以用errors.Is分别判断
+func WithLock(ctx context.Context, locker Locker, key string, opts WithLockOptions,
+	fn func(ctx context.Context) error) (err error) {
+	opts = opts.withDefault()
+	var lock *Lock
+	if opts.Strategy == nil {
+		lock, err = locker.TryLock(ctx, key, opts.Expiration, opts.LockOptions...)
+	} else {
+		lock, err = locker.Lock(ctx, key, opts.Timeout, opts.Expiration, opts.Strategy, opts.LockOptions...)
+	}
+	if err != nil {
+		return err
+	}
+
+	lock.StartAutoRefresh(opts.RefreshInterval, opts.Timeout)
+	bizCtx, cancel := WithLockContext(ctx, lock)
+	defer func() {
+		cancel()
+		// ctx可能已经被取消了，解锁不能受它影响
+		unlockCtx, unlockCancel := context.WithTimeout(context.WithoutCancel(ctx), opts.Timeout)
+		unlockErr := lock.Unlock(unlockCtx)
+		unlockCancel()
+		if r := recover(); r != nil {
+			panic(r)
+		}
+		err = errors.Join(err, unlockErr)
+	}()
+
+	return fn(bizCtx)
+}
+
+// WithLock 在锁的保护下执行fn，见WithLock函数
+func (l *RedisDistributedLock) WithLock(ctx context.Context, key string, opts WithLockOptions,
+	fn func(ctx context.Context) error) error {
+	return WithLock(ctx, l, key, opts, fn)
+}
+
+// WithLock 在锁的保护下执行fn，见WithLock函数
+func (m *MemoryDistributedLock) WithLock(ctx context.Context, key string, opts WithLockOptions,
+	fn func(ctx context.Context) error) error {
+	return WithLock(ctx, m, key, opts, fn)
+}
+
+// WithLock 在锁的保护下执行fn，见WithLock函数
+func (l *SQLDistributedLock) WithLock(ctx context.Context, key string, opts WithLockOptions,
+	fn func(ctx context.Context) error) error {
+	return WithLock(ctx, l, key, opts, fn)
+}
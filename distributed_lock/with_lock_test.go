@@ -0,0 +1,146 @@
+package distributed_lock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/liquanhui-99/gotool/cache/redis_cache/mocks"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithLock(t *testing.T) {
+	errBiz := errors.New("biz error")
+	testCases := []struct {
+		name string
+		// before 在执行WithLock之前准备锁的状态
+		before  func(t *testing.T, locker *MemoryDistributedLock)
+		fn      func(t *testing.T, locker *MemoryDistributedLock) func(ctx context.Context) error
+		wantErr []error
+	}{
+		{
+			name:   "success",
+			before: func(t *testing.T, locker *MemoryDistributedLock) {},
+			fn: func(t *testing.T, locker *MemoryDistributedLock) func(ctx context.Context) error {
+				return func(ctx context.Context) error {
+					// 执行期间锁被持有
+					_, err := locker.TryLock(context.Background(), "key", time.Minute)
+					assert.Equal(t, ErrFailedToRaceLock, err)
+					return nil
+				}
+			},
+		},
+		{
+			name:   "biz error",
+			before: func(t *testing.T, locker *MemoryDistributedLock) {},
+			fn: func(t *testing.T, locker *MemoryDistributedLock) func(ctx context.Context) error {
+				return func(ctx context.Context) error {
+					return errBiz
+				}
+			},
+			wantErr: []error{errBiz},
+		},
+		{
+			name: "lock hold by others",
+			before: func(t *testing.T, locker *MemoryDistributedLock) {
+				_, err := locker.TryLock(context.Background(), "key", time.Minute)
+				require.NoError(t, err)
+			},
+			fn: func(t *testing.T, locker *MemoryDistributedLock) func(ctx context.Context) error {
+				return func(ctx context.Context) error {
+					t.Fatal("没有抢到锁不应该执行业务逻辑")
+					return nil
+				}
+			},
+			wantErr: []error{ErrFailedToRaceLock},
+		},
+		{
+			name:   "lease lost",
+			before: func(t *testing.T, locker *MemoryDistributedLock) {},
+			fn: func(t *testing.T, locker *MemoryDistributedLock) func(ctx context.Context) error {
+				return func(ctx context.Context) error {
+					// 模拟锁被别人抢走，续约失败之后ctx被取消
+					locker.mu.Lock()
+					locker.locks["key"].val = "others"
+					locker.mu.Unlock()
+					select {
+					case <-ctx.Done():
+						assert.Equal(t, ErrLockNotHold, context.Cause(ctx))
+						return ctx.Err()
+					case <-time.After(time.Second):
+						t.Fatal("锁丢失之后没有取消ctx")
+						return nil
+					}
+				}
+			},
+			wantErr: []error{context.Canceled, ErrLockNotHold},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			locker := NewMemoryDistributedLock()
+			tc.before(t, locker)
+			err := locker.WithLock(context.Background(), "key", WithLockOptions{
+				Expiration: 100 * time.Millisecond,
+			}, tc.fn(t, locker))
+			if len(tc.wantErr) == 0 {
+				require.NoError(t, err)
+			}
+			for _, wantErr := range tc.wantErr {
+				assert.ErrorIs(t, err, wantErr)
+			}
+			// 锁属于别人时不能被删除
+			if errors.Is(err, ErrFailedToRaceLock) || errors.Is(err, ErrLockNotHold) {
+				_, err = locker.Holder(context.Background(), "key")
+				assert.NoError(t, err)
+				return
+			}
+			// 执行结束之后一定会解锁
+			_, err = locker.Holder(context.Background(), "key")
+			assert.Equal(t, ErrLockNotExist, err)
+		})
+	}
+}
+
+func TestWithLock_Panic(t *testing.T) {
+	locker := NewMemoryDistributedLock()
+	assert.PanicsWithValue(t, "biz panic", func() {
+		_ = WithLock(context.Background(), locker, "key", WithLockOptions{}, func(ctx context.Context) error {
+			panic("biz panic")
+		})
+	})
+	// panic之后依旧会解锁
+	_, err := locker.Holder(context.Background(), "key")
+	assert.Equal(t, ErrLockNotExist, err)
+}
+
+func TestRedisDistributedLock_WithLock(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cmd := mocks.NewMockCmdable(ctrl)
+	var val string
+	cmd.EXPECT().SetNX(gomock.Any(), "key", gomock.Any(), DefaultLockExpiration).
+		DoAndReturn(func(ctx context.Context, key string, value any, expiration time.Duration) *redis.BoolCmd {
+			val = value.(string)
+			return redis.NewBoolResult(true, nil)
+		})
+	unlockRes := redis.NewCmd(context.Background())
+	unlockRes.SetVal(int64(0))
+	cmd.EXPECT().Eval(gomock.Any(), unlockScript, []string{"key"}, gomock.Any()).
+		DoAndReturn(func(ctx context.Context, script string, keys []string, args ...any) *redis.Cmd {
+			assert.Equal(t, []any{val}, args)
+			return unlockRes
+		})
+
+	err := NewRedisDistributedLock(cmd).WithLock(context.Background(), "key", WithLockOptions{},
+		func(ctx context.Context) error {
+			return nil
+		})
+	// 解锁失败的错误也会返回
+	assert.ErrorIs(t, err, ErrLockNotHold)
+}
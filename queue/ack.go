@@ -0,0 +1,296 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxAttempts = 5 // 开启确认之后默认的最大投递次数
+
+	deadLetterPrefix = "%DLQ%"
+	// deadLetterTimeout 发送死信最多阻塞的时间，不超过visibilityTimeout，避免阻塞整个订阅的重新投递
+	deadLetterTimeout = time.Second
+)
+
+var (
+	ErrMessageNotInFlight = errors.New("消息不在处理中，可能已经确认过或者超时之后重新投递了")
+	// ErrDeadLetterDropped 超过最大投递次数的消息没有进入死信队列，例如死信队列没有订阅者或者已满
+	ErrDeadLetterDropped = errors.New("超过最大投递次数，消息没有进入死信队列，被丢弃")
+)
+
+// DeadLetterTopic 返回topic对应的死信队列的topic，超过最大投递次数的消息会发送到这里，
+// 订阅它就可以处理这些消息
+func DeadLetterTopic(topic string) string {
+	return deadLetterPrefix + topic
+}
+
+// SubscribeWithAck 开启消息确认，消费者需要在visibilityTimeout之内调用Message.Ack，
+// 超时或者调用Message.Nack的消息会重新投递，超时从消费者取走消息开始计算，在队列中等待的时间不算
+func SubscribeWithAck(visibilityTimeout time.Duration) SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		cfg.visibilityTimeout = visibilityTimeout
+	}
+}
+
+// SubscribeWithMaxAttempts 设置开启确认之后的最大投递次数，默认为DefaultMaxAttempts，
+// 最后一次投递依旧没有确认的消息会发送到DeadLetterTopic，小于等于0表示不限制
+func SubscribeWithMaxAttempts(n int) SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		cfg.maxAttempts = n
+	}
+}
+
+// Acknowledger 消息确认的实现，Message.Ack和Message.Nack会交给它处理。
+// 其他的Broker实现，例如redis_stream，通过Message.WithAcknowledger接入
+type Acknowledger interface {
+	Ack(msg Message) error
+	Nack(msg Message) error
+}
+
+// WithAcknowledger 返回使用a确认的消息
+func (m Message) WithAcknowledger(a Acknowledger) Message {
+	m.acker = a
+	return m
+}
+
+// Ack 确认消息已经处理完成，没有开启确认的订阅什么都不做
+func (m Message) Ack() error {
+	if m.acker == nil {
+		return nil
+	}
+	return m.acker.Ack(m)
+}
+
+// Nack 通知消息处理失败，立刻重新投递，没有开启确认的订阅什么都不做
+func (m Message) Nack() error {
+	if m.acker == nil {
+		return nil
+	}
+	return m.acker.Nack(m)
+}
+
+var _ Acknowledger = (*ackTracker)(nil)
+
+// ackTracker 记录一个订阅中已经投递但是还没有确认的消息
+type ackTracker struct {
+	visibilityTimeout time.Duration
+	maxAttempts       int
+
+	mu       sync.Mutex
+	inflight map[string]*inflightMessage
+	// 消费者取走消息时才开始计时，在这之前消息的deadline为零值
+	startOnReceive bool
+	// 有消息需要立刻重新投递时通知redeliver
+	notify chan struct{}
+	// 获取当前时间，测试时可以替换
+	now func() time.Time
+}
+
+type inflightMessage struct {
+	msg Message
+	// 超时的时间，为零值表示消息还在队列中没有被取走
+	deadline time.Time
+}
+
+func newAckTracker(visibilityTimeout time.Duration, maxAttempts int) *ackTracker {
+	return &ackTracker{
+		visibilityTimeout: visibilityTimeout,
+		maxAttempts:       maxAttempts,
+		inflight:          map[string]*inflightMessage{},
+		notify:            make(chan struct{}, 1),
+		now:               time.Now,
+	}
+}
+
+// track 记录一次投递，返回带有确认能力的消息
+func (a *ackTracker) track(msg Message) Message {
+	if msg.Attempt == 0 {
+		msg.Attempt = 1
+	}
+	msg.acker = a
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	v := &inflightMessage{msg: msg}
+	if !a.startOnReceive {
+		v.deadline = a.now().Add(a.visibilityTimeout)
+	}
+	a.inflight[msg.ID] = v
+	return msg
+}
+
+// received 消费者取走了消息，开始计算超时时间
+func (a *ackTracker) received(msg Message) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// 消费者可能已经确认或者Nack了
+	if v, ok := a.inflight[msg.ID]; ok && v.msg.Attempt == msg.Attempt && v.deadline.IsZero() {
+		v.deadline = a.now().Add(a.visibilityTimeout)
+	}
+}
+
+// untrack 消息没有投递成功，不需要确认
+func (a *ackTracker) untrack(msg Message) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if v, ok := a.inflight[msg.ID]; ok && v.msg.Attempt == msg.Attempt {
+		delete(a.inflight, msg.ID)
+	}
+}
+
+func (a *ackTracker) Ack(msg Message) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	v, ok := a.inflight[msg.ID]
+	// 已经重新投递过的消息只能由最新的一次投递确认
+	if !ok || v.msg.Attempt != msg.Attempt {
+		return ErrMessageNotInFlight
+	}
+	delete(a.inflight, msg.ID)
+	return nil
+}
+
+func (a *ackTracker) Nack(msg Message) error {
+	a.mu.Lock()
+	v, ok := a.inflight[msg.ID]
+	if !ok || v.msg.Attempt != msg.Attempt {
+		a.mu.Unlock()
+		return ErrMessageNotInFlight
+	}
+	v.deadline = a.now()
+	a.mu.Unlock()
+
+	select {
+	case a.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// pending 还没有确认的消息数
+func (a *ackTracker) pending() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.inflight)
+}
+
+// expired 取出所有超时的消息，同时返回距离下一条消息超时的时间
+func (a *ackTracker) expired() ([]Message, time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+	next := a.visibilityTimeout
+	var res []Message
+	for id, v := range a.inflight {
+		// 还在队列中的消息不会超时
+		if v.deadline.IsZero() {
+			continue
+		}
+		if d := v.deadline.Sub(now); d > 0 {
+			next = min(next, d)
+			continue
+		}
+		delete(a.inflight, id)
+		res = append(res, v.msg)
+	}
+	return res, next
+}
+
+// relay 把队列中的消息逐条交给消费者，交付之后开始计算确认的超时时间。
+// 取消订阅之后丢弃还没有交给消费者的消息并关闭s.out，消费者不再读取时也不会一直阻塞
+func (s *subscription) relay() {
+	defer close(s.out)
+	for msg := range s.ch {
+		select {
+		case s.out <- msg:
+			s.acks.received(msg)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// redeliver 重新投递超时和Nack的消息，超过最大投递次数的消息发送到死信队列
+func (b *Broker) redeliver(topic string, sub *subscription) {
+	defer sub.wg.Done()
+	timer := time.NewTimer(sub.acks.visibilityTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-sub.acks.notify:
+		case <-sub.done:
+			return
+		}
+
+		msgs, next := sub.acks.expired()
+		for _, msg := range msgs {
+			if sub.acks.maxAttempts > 0 && msg.Attempt >= sub.acks.maxAttempts {
+				b.deadLetter(topic, sub, msg)
+				continue
+			}
+			msg.Attempt++
+			if !sub.deliver(msg) {
+				return
+			}
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(next)
+	}
+}
+
+// deadLetter 把消息发送到死信队列，死信队列的订阅者阻塞时最多等待deadLetterTimeout，
+// 取消订阅时立刻放弃。没有进入死信队列时通过错误队列通知
+func (b *Broker) deadLetter(topic string, sub *subscription, msg Message) {
+	dead := msg
+	// 通配符订阅时使用消息本身的topic
+	dead.Topic = DeadLetterTopic(msg.Topic)
+	dead.Attempt = 0
+	dead.acker = nil
+
+	ctx, cancel := context.WithTimeout(context.Background(), min(sub.acks.visibilityTimeout, deadLetterTimeout))
+	defer cancel()
+	go func() {
+		select {
+		case <-sub.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	report, err := b.Publish(ctx, dead)
+	if err != nil {
+		err = ErrDeadLetterDropped
+	} else {
+		err = deadLetterErr(report)
+	}
+	if err == nil {
+		return
+	}
+
+	msg.acker = nil
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	b.reportErr(topic, ErrMessage{Message: msg, Err: err})
+}
+
+// deadLetterErr 死信没有投递给所有订阅者时返回ErrDeadLetterDropped和每个订阅者的原因
+func deadLetterErr(report *DeliveryReport) error {
+	var errs []error
+	for _, res := range report.Results {
+		if res.Err != nil {
+			errs = append(errs, res.Err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errors.Join(append([]error{ErrDeadLetterDropped}, errs...)...)
+}
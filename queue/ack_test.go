@@ -0,0 +1,217 @@
+package queue
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// receiveMsg 从ch中读取一条消息
+func receiveMsg(t *testing.T, ch <-chan Message) Message {
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("没有收到消息")
+		return Message{}
+	}
+}
+
+// assertNoMsg 断言d之内ch中没有消息
+func assertNoMsg(t *testing.T, ch <-chan Message, d time.Duration) {
+	select {
+	case msg := <-ch:
+		t.Fatalf("不应该收到消息: %v", msg)
+	case <-time.After(d):
+	}
+}
+
+func newAckBroker(t *testing.T, opts ...SubscribeOption) (*Broker, <-chan Message) {
+	b := NewBroker()
+	require.NoError(t, subscribeErr(b.Subscribe("order", "consumer", opts...)))
+	t.Cleanup(func() {
+		b.Close("order")
+	})
+	ch, ok := b.Queue("order", "consumer")
+	require.True(t, ok)
+	return b, ch
+}
+
+func TestMessage_Ack(t *testing.T) {
+	b, ch := newAckBroker(t, SubscribeWithAck(50*time.Millisecond))
+	require.NoError(t, b.Send(Message{Topic: "order", Content: "order-1"}))
+	msg := receiveMsg(t, ch)
+	assert.NotEmpty(t, msg.ID)
+	assert.Equal(t, 1, msg.Attempt)
+	require.NoError(t, msg.Ack())
+	// 确认之后不会重新投递
+	assertNoMsg(t, ch, 150*time.Millisecond)
+	assert.Equal(t, ErrMessageNotInFlight, msg.Ack())
+}
+
+func TestMessage_VisibilityTimeout(t *testing.T) {
+	b, ch := newAckBroker(t, SubscribeWithAck(50*time.Millisecond))
+	require.NoError(t, b.Send(Message{Topic: "order", Content: "order-1"}))
+	first := receiveMsg(t, ch)
+
+	// 超时没有确认的消息会重新投递
+	second := receiveMsg(t, ch)
+	assert.Equal(t, first.ID, second.ID)
+	assert.Equal(t, 2, second.Attempt)
+	// 已经重新投递的消息只能由最新的一次投递确认
+	assert.Equal(t, ErrMessageNotInFlight, first.Ack())
+	require.NoError(t, second.Ack())
+}
+
+func TestMessage_Nack(t *testing.T) {
+	b, ch := newAckBroker(t, SubscribeWithAck(time.Hour))
+	require.NoError(t, b.Send(Message{Topic: "order", Content: "order-1"}))
+	first := receiveMsg(t, ch)
+	require.NoError(t, first.Nack())
+
+	// Nack之后立刻重新投递，不需要等到超时
+	second := receiveMsg(t, ch)
+	assert.Equal(t, first.ID, second.ID)
+	assert.Equal(t, 2, second.Attempt)
+	assert.Equal(t, ErrMessageNotInFlight, first.Nack())
+	require.NoError(t, second.Ack())
+}
+
+func TestMessage_AckBacklog(t *testing.T) {
+	// 消息数超过容量，单条消息的处理时间小于超时时间，但是处理完整个队列的时间大于超时时间
+	b, ch := newAckBroker(t, SubscribeWithAck(100*time.Millisecond), SubscribeWithMaxAttempts(3),
+		SubscribeWithCapacity(5), SubscribeWithOverflow(OverflowBlock))
+	require.NoError(t, subscribeErr(b.Subscribe(DeadLetterTopic("order"), "dlq")))
+	defer b.Close(DeadLetterTopic("order"))
+	dlq, _ := b.Queue(DeadLetterTopic("order"), "dlq")
+
+	const n = 20
+	go func() {
+		for i := 0; i < n; i++ {
+			assert.NoError(t, b.Send(Message{Topic: "order", Content: i}))
+		}
+	}()
+
+	// 在队列中等待的时间不算超时，每条消息只会投递一次
+	for i := 0; i < n; i++ {
+		msg := receiveMsg(t, ch)
+		assert.Equal(t, i, msg.Content)
+		assert.Equal(t, 1, msg.Attempt)
+		time.Sleep(20 * time.Millisecond)
+		require.NoError(t, msg.Ack())
+	}
+	assertNoMsg(t, ch, 150*time.Millisecond)
+	assertNoMsg(t, dlq, 10*time.Millisecond)
+}
+
+func TestMessage_UnsubscribeWithoutReading(t *testing.T) {
+	before := runtime.NumGoroutine()
+	b := NewBroker()
+	sub, err := b.Subscribe("order", "consumer", SubscribeWithAck(time.Hour), SubscribeWithCapacity(5))
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, b.Send(Message{Topic: "order", Content: i}))
+	}
+	// 消费者只读取了一条消息就不再读取，取消订阅之后转发消息的goroutine也要退出
+	receiveMsg(t, sub.C())
+	sub.Unsubscribe()
+	// assert.Eventually会启动新的goroutine，这里自己轮询
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > before && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
+
+func TestMessage_DeadLetter(t *testing.T) {
+	b, ch := newAckBroker(t, SubscribeWithAck(time.Hour), SubscribeWithMaxAttempts(2))
+	require.NoError(t, subscribeErr(b.Subscribe(DeadLetterTopic("order"), "dlq")))
+	defer b.Close(DeadLetterTopic("order"))
+	dlq, _ := b.Queue(DeadLetterTopic("order"), "dlq")
+
+	require.NoError(t, b.Send(Message{Topic: "order", Content: "order-1"}))
+	msg := receiveMsg(t, ch)
+	require.NoError(t, msg.Nack())
+	msg = receiveMsg(t, ch)
+	require.NoError(t, msg.Nack())
+
+	// 最后一次投递失败之后进入死信队列，不会再投递给原来的队列
+	dead := receiveMsg(t, dlq)
+	assert.Equal(t, msg.ID, dead.ID)
+	assert.Equal(t, "order-1", dead.Content)
+	assertNoMsg(t, ch, 50*time.Millisecond)
+}
+
+func TestMessage_DeadLetterDropped(t *testing.T) {
+	b, ch := newAckBroker(t, SubscribeWithAck(time.Hour), SubscribeWithMaxAttempts(1))
+	errCh, ok := b.ErrQueue("order")
+	require.True(t, ok)
+	require.NoError(t, b.Send(Message{Topic: "order", Content: "order-1"}))
+	msg := receiveMsg(t, ch)
+	require.NoError(t, msg.Nack())
+
+	// 死信队列没有订阅者时通过错误队列通知
+	select {
+	case errMsg := <-errCh:
+		assert.Equal(t, ErrDeadLetterDropped, errMsg.Err)
+		assert.Equal(t, msg.ID, errMsg.ID)
+	case <-time.After(time.Second):
+		t.Fatal("没有收到错误消息")
+	}
+}
+
+func TestMessage_DeadLetterBlocked(t *testing.T) {
+	b, ch := newAckBroker(t, SubscribeWithAck(50*time.Millisecond), SubscribeWithMaxAttempts(1))
+	dlqTopic := DeadLetterTopic("order")
+	require.NoError(t, subscribeErr(b.Subscribe(dlqTopic, "dlq",
+		SubscribeWithCapacity(1), SubscribeWithOverflow(OverflowBlock))))
+	defer b.Close(dlqTopic)
+	errCh, _ := b.ErrQueue("order")
+	// 死信队列的订阅者已满
+	require.NoError(t, b.Send(Message{Topic: dlqTopic, Content: "full"}))
+
+	require.NoError(t, b.Send(Message{Topic: "order", Content: "order-1"}))
+	require.NoError(t, b.Send(Message{Topic: "order", Content: "order-2"}))
+	first := receiveMsg(t, ch)
+	require.NoError(t, first.Nack())
+
+	// 死信阻塞的时候不会影响其他消息的确认和投递
+	second := receiveMsg(t, ch)
+	assert.Equal(t, "order-2", second.Content)
+	require.NoError(t, second.Ack())
+	select {
+	case errMsg := <-errCh:
+		assert.ErrorIs(t, errMsg.Err, ErrDeadLetterDropped)
+		assert.ErrorIs(t, errMsg.Err, context.DeadlineExceeded)
+		assert.Equal(t, first.ID, errMsg.ID)
+		assert.Equal(t, "order", errMsg.Topic)
+	case <-time.After(time.Second):
+		t.Fatal("没有收到错误消息")
+	}
+
+	// 死信阻塞的时候关闭不会卡住
+	require.NoError(t, b.Send(Message{Topic: "order", Content: "order-3"}))
+	require.NoError(t, receiveMsg(t, ch).Nack())
+	closed := make(chan struct{})
+	go func() {
+		b.Close("order")
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("关闭的时候被死信阻塞")
+	}
+}
+
+func TestMessage_AckWithoutAckMode(t *testing.T) {
+	b, ch := newAckBroker(t)
+	require.NoError(t, b.Send(Message{Topic: "order", Content: "order-1"}))
+	msg := receiveMsg(t, ch)
+	assert.Equal(t, 0, msg.Attempt)
+	assert.NoError(t, msg.Ack())
+	assert.NoError(t, msg.Nack())
+}
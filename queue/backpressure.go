@@ -0,0 +1,341 @@
+package queue
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrQueueFull = errors.New("消息队列已满")
+	ErrSpillFull = errors.New("溢出缓冲区已满")
+)
+
+// OverflowPolicy 订阅者的队列已满时的处理策略
+type OverflowPolicy int
+
+const (
+	// OverflowErrQueue 放进topic的错误队列，错误队列也满了的时候丢弃，默认策略
+	OverflowErrQueue OverflowPolicy = iota
+	// OverflowBlock 阻塞发送方直到队列有空位或者Publish的ctx结束，
+	// 阻塞发生在释放Broker的锁之后，不会影响其他订阅者
+	OverflowBlock
+	// OverflowDropNewest 丢弃新的消息
+	OverflowDropNewest
+	// OverflowDropOldest 丢弃队列中最旧的消息，为新的消息腾出位置
+	OverflowDropOldest
+	// OverflowSpill 暂存在订阅者的溢出缓冲区中，队列有空位之后按顺序投递，
+	// 缓冲区的大小通过SubscribeWithSpillLimit设置
+	OverflowSpill
+)
+
+// DeliveryStatus 一条消息投递给一个订阅者的结果
+type DeliveryStatus int
+
+const (
+	// DeliveryDelivered 进入了订阅者的队列
+	DeliveryDelivered DeliveryStatus = iota
+	// DeliveryEvicted 进入了订阅者的队列，但是挤掉了队列中最旧的一条消息
+	DeliveryEvicted
+	// DeliverySpilled 队列已满，暂存在溢出缓冲区中，之后会按顺序投递
+	DeliverySpilled
+	// DeliveryDropped 队列已满，消息被丢弃
+	DeliveryDropped
+	// DeliveryRejected 队列已满，消息放进了错误队列
+	DeliveryRejected
+	// DeliveryTimeout 阻塞等待的过程中ctx结束了，消息没有投递
+	DeliveryTimeout
+)
+
+// DeliveryResult 一个订阅者的投递结果
+type DeliveryResult struct {
+	// Queue 队列的名称，消费组中是消费者的名称
+	Queue string
+	// Group 消费组的名称，Subscribe订阅的队列为空
+	Group  string
+	Status DeliveryStatus
+	// Err 没有进入订阅者的队列的原因
+	Err error
+}
+
+// DeliveryReport Publish的投递报告
+type DeliveryReport struct {
+	// ID 消息的唯一标识
+	ID string
+	// Offset 消息在topic日志中的偏移量，只有开启持久化或者保留时才有意义
+	Offset uint64
+	// Scheduled 是否是还没有到投递时间的延时消息，延时消息没有投递结果
+	Scheduled bool
+	Results   []DeliveryResult
+}
+
+// SubscribeWithOverflow 设置队列已满时的处理策略，默认为OverflowErrQueue
+func SubscribeWithOverflow(policy OverflowPolicy) SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		cfg.overflow = policy
+	}
+}
+
+// SubscribeWithSpillLimit 设置OverflowSpill策略下溢出缓冲区最多暂存的消息数，
+// 超过之后丢弃新的消息，小于等于0表示不限制
+func SubscribeWithSpillLimit(n int) SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		cfg.spillLimit = n
+	}
+}
+
+// target 一次投递的目标
+type target struct {
+	// topic 订阅时使用的topic，通配符订阅时和消息的topic不同
+	topic string
+	queue string
+	group string
+	sub   *subscription
+}
+
+// Publish 发送消息并返回每个订阅者的投递结果。开启持久化或者保留时消息会先写入topic的日志，
+// 即使topic暂时没有订阅者也不会丢失。队列已满时按照订阅者的OverflowPolicy处理，
+// OverflowBlock的订阅者会阻塞到ctx结束，同一个goroutine依次Publish的消息会按顺序投递。
+// 设置了Delay或者DeliverAt的消息会暂存起来，到期之后才投递，返回的报告中Scheduled为true，
+// 可以通过Cancel取消。消息会投递给订阅了msg.Topic的订阅者以及匹配它的通配符订阅者
+func (b *Broker) Publish(ctx context.Context, msg Message) (*DeliveryReport, error) {
+	if b.initErr != nil {
+		return nil, b.initErr
+	}
+	if IsTopicPattern(msg.Topic) {
+		return nil, ErrPublishToPattern
+	}
+	if msg.ID == "" {
+		msg.ID = uuid.New().String()
+	}
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = b.logCfg.now()
+	}
+	if msg.Delay > 0 {
+		msg.DeliverAt = b.logCfg.now().Add(msg.Delay)
+		msg.Delay = 0
+	}
+	if msg.DeliverAt.After(b.logCfg.now()) {
+		return b.schedule(msg)
+	}
+	return b.publish(ctx, msg)
+}
+
+// schedule 暂存延时消息
+func (b *Broker) schedule(msg Message) (*DeliveryReport, error) {
+	b.mu.RLock()
+	routes := b.routes(msg.Topic)
+	closing := b.closing
+	retained := b.retained()
+	b.mu.RUnlock()
+	if closing {
+		return nil, ErrBrokerClosed
+	}
+	if len(routes) == 0 && !retained {
+		return nil, errors.New("topic不存在")
+	}
+
+	if err := b.scheduler.schedule(msg); err != nil {
+		return nil, err
+	}
+	return &DeliveryReport{ID: msg.ID, Scheduled: true}, nil
+}
+
+// Cancel 取消一条还没有到投递时间的延时消息，消息已经投递或者不存在时返回ErrMessageNotScheduled
+func (b *Broker) Cancel(id string) error {
+	return b.scheduler.cancel(id)
+}
+
+func (b *Broker) publish(ctx context.Context, msg Message) (*DeliveryReport, error) {
+	b.mu.Lock()
+	if b.closing {
+		b.mu.Unlock()
+		return nil, ErrBrokerClosed
+	}
+	if b.retained() {
+		log, err := b.topicLog(msg.Topic)
+		if err == nil {
+			msg.Offset, err = log.append(msg)
+		}
+		if err != nil {
+			b.mu.Unlock()
+			return nil, err
+		}
+	}
+
+	routes := b.routes(msg.Topic)
+	if len(routes) == 0 && !b.retained() {
+		b.mu.Unlock()
+		return nil, errors.New("topic不存在")
+	}
+
+	var targets []target
+	for _, topic := range routes {
+		for name, sub := range b.brokerChain[topic] {
+			if sub.live && matchHeaders(sub.headers, msg) {
+				targets = append(targets, target{topic: topic, queue: name, sub: sub})
+			}
+		}
+		for group, g := range b.groups[topic] {
+			if !matchHeaders(g.headers, msg) {
+				continue
+			}
+			name, sub := g.pick(msg)
+			targets = append(targets, target{topic: topic, queue: name, group: group, sub: sub})
+		}
+	}
+
+	report := &DeliveryReport{
+		ID:      msg.ID,
+		Offset:  msg.Offset,
+		Results: make([]DeliveryResult, len(targets)),
+	}
+	var blocked []int
+	for i, t := range targets {
+		res, block := b.offer(t, msg)
+		if block {
+			// 在锁外面阻塞等待，取消订阅时会等它结束再关闭channel
+			t.sub.wg.Add(1)
+			blocked = append(blocked, i)
+		}
+		report.Results[i] = res
+	}
+	b.mu.Unlock()
+
+	for _, i := range blocked {
+		report.Results[i] = targets[i].sub.deliverCtx(ctx, targets[i], msg)
+	}
+	return report, nil
+}
+
+// offer 不阻塞地把消息投递给t，需要阻塞等待时返回true，调用方需要持有b.mu
+func (b *Broker) offer(t target, msg Message) (DeliveryResult, bool) {
+	res := DeliveryResult{Queue: t.queue, Group: t.group}
+	sub := t.sub
+	switch sub.overflow {
+	case OverflowSpill:
+		res.Status, res.Err = sub.spillOrDeliver(msg)
+		return res, false
+	case OverflowDropOldest:
+		res.Status, res.Err = sub.evictAndDeliver(msg)
+		return res, false
+	}
+
+	if sub.tryDeliver(msg) {
+		res.Status = DeliveryDelivered
+		return res, false
+	}
+
+	switch sub.overflow {
+	case OverflowBlock:
+		return res, true
+	case OverflowDropNewest:
+		res.Status, res.Err = DeliveryDropped, ErrQueueFull
+	default:
+		res.Status, res.Err = DeliveryRejected, ErrQueueFull
+		msg.Queue = t.queue
+		b.reportErr(t.topic, ErrMessage{Message: msg, Err: ErrQueueFull})
+	}
+	return res, false
+}
+
+// reportErr 不阻塞地放进订阅时使用的topic的错误队列，错误队列已满时丢弃，调用方需要持有b.mu
+func (b *Broker) reportErr(topic string, errMsg ErrMessage) {
+	select {
+	case b.topicErrQueue[topic] <- errMsg:
+	default:
+	}
+}
+
+// deliverCtx 阻塞地投递消息直到ctx结束，调用之前需要sub.wg.Add(1)
+func (s *subscription) deliverCtx(ctx context.Context, t target, msg Message) DeliveryResult {
+	defer s.wg.Done()
+	res := DeliveryResult{Queue: t.queue, Group: t.group, Status: DeliveryDelivered}
+	if s.acks != nil {
+		msg = s.acks.track(msg)
+	}
+	select {
+	case s.ch <- msg:
+		return res
+	case <-ctx.Done():
+		res.Status, res.Err = DeliveryTimeout, ctx.Err()
+	case <-s.done:
+		res.Status, res.Err = DeliveryDropped, errors.New("队列已关闭")
+	}
+	if s.acks != nil {
+		s.acks.untrack(msg)
+	}
+	return res
+}
+
+// evictAndDeliver 队列已满时丢弃最旧的消息，直到新的消息能放进队列
+func (s *subscription) evictAndDeliver(msg Message) (DeliveryStatus, error) {
+	status := DeliveryDelivered
+	for !s.tryDeliver(msg) {
+		// 没有缓冲的队列无法腾出位置
+		if cap(s.ch) == 0 {
+			return DeliveryDropped, ErrQueueFull
+		}
+		select {
+		case old := <-s.ch:
+			if s.acks != nil {
+				s.acks.untrack(old)
+			}
+			status = DeliveryEvicted
+		default:
+			// 刚好被消费者取走了，重新尝试投递
+		}
+	}
+	return status, nil
+}
+
+// spillOrDeliver 溢出缓冲区中有消息的时候，新的消息也要放进缓冲区，保证投递的顺序
+func (s *subscription) spillOrDeliver(msg Message) (DeliveryStatus, error) {
+	s.spillMu.Lock()
+	defer s.spillMu.Unlock()
+	if len(s.spill) == 0 && s.tryDeliver(msg) {
+		return DeliveryDelivered, nil
+	}
+	if s.spillLimit > 0 && len(s.spill) >= s.spillLimit {
+		return DeliveryDropped, ErrSpillFull
+	}
+
+	s.spill = append(s.spill, msg)
+	select {
+	case s.spillNotify <- struct{}{}:
+	default:
+	}
+	return DeliverySpilled, nil
+}
+
+// pumpSpill 把溢出缓冲区中的消息按顺序投递到队列中
+func (s *subscription) pumpSpill() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.spillNotify:
+		case <-s.done:
+			return
+		}
+
+		for {
+			s.spillMu.Lock()
+			if len(s.spill) == 0 {
+				s.spillMu.Unlock()
+				break
+			}
+			// 投递成功之后才从缓冲区中删除，投递过程中新的消息会继续放进缓冲区
+			msg := s.spill[0]
+			s.spillMu.Unlock()
+			if !s.deliver(msg) {
+				return
+			}
+
+			s.spillMu.Lock()
+			s.spill[0] = Message{}
+			s.spill = s.spill[1:]
+			s.spillMu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func statuses(report *DeliveryReport) []DeliveryStatus {
+	res := make([]DeliveryStatus, 0, len(report.Results))
+	for _, r := range report.Results {
+		res = append(res, r.Status)
+	}
+	return res
+}
+
+func contents(msgs []Message) []any {
+	res := make([]any, 0, len(msgs))
+	for _, msg := range msgs {
+		res = append(res, msg.Content)
+	}
+	return res
+}
+
+func TestBroker_Overflow(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts []SubscribeOption
+		// 队列容量为2，连续发送4条消息的投递结果
+		wantStatus []DeliveryStatus
+		// 最终消费者能收到的消息
+		wantMsgs []any
+		wantErrs int
+	}{
+		{
+			name:       "err queue",
+			wantStatus: []DeliveryStatus{DeliveryDelivered, DeliveryDelivered, DeliveryRejected, DeliveryRejected},
+			wantMsgs:   []any{"msg-0", "msg-1"},
+			wantErrs:   2,
+		},
+		{
+			name:       "drop newest",
+			opts:       []SubscribeOption{SubscribeWithOverflow(OverflowDropNewest)},
+			wantStatus: []DeliveryStatus{DeliveryDelivered, DeliveryDelivered, DeliveryDropped, DeliveryDropped},
+			wantMsgs:   []any{"msg-0", "msg-1"},
+		},
+		{
+			name:       "drop oldest",
+			opts:       []SubscribeOption{SubscribeWithOverflow(OverflowDropOldest)},
+			wantStatus: []DeliveryStatus{DeliveryDelivered, DeliveryDelivered, DeliveryEvicted, DeliveryEvicted},
+			wantMsgs:   []any{"msg-2", "msg-3"},
+		},
+		{
+			name:       "spill",
+			opts:       []SubscribeOption{SubscribeWithOverflow(OverflowSpill)},
+			wantStatus: []DeliveryStatus{DeliveryDelivered, DeliveryDelivered, DeliverySpilled, DeliverySpilled},
+			wantMsgs:   []any{"msg-0", "msg-1", "msg-2", "msg-3"},
+		},
+		{
+			name:       "spill limit",
+			opts:       []SubscribeOption{SubscribeWithOverflow(OverflowSpill), SubscribeWithSpillLimit(1)},
+			wantStatus: []DeliveryStatus{DeliveryDelivered, DeliveryDelivered, DeliverySpilled, DeliveryDropped},
+			wantMsgs:   []any{"msg-0", "msg-1", "msg-2"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := NewBroker()
+			defer b.Close("order")
+			require.NoError(t, subscribeErr(b.Subscribe("order", "consumer", append(tc.opts, SubscribeWithCapacity(2))...)))
+			var got []DeliveryStatus
+			for i := 0; i < 4; i++ {
+				report, err := b.Publish(context.Background(), Message{Topic: "order", Content: fmt.Sprintf("msg-%d", i)})
+				require.NoError(t, err)
+				require.Len(t, report.Results, 1)
+				assert.Equal(t, "consumer", report.Results[0].Queue)
+				got = append(got, statuses(report)...)
+			}
+			assert.Equal(t, tc.wantStatus, got)
+
+			ch, _ := b.Queue("order", "consumer")
+			var msgs []Message
+			for range tc.wantMsgs {
+				msgs = append(msgs, receiveMsg(t, ch))
+			}
+			assert.Equal(t, tc.wantMsgs, contents(msgs))
+			assertNoMsg(t, ch, 20*time.Millisecond)
+
+			errCh, _ := b.ErrQueue("order")
+			errs := drainErr(errCh)
+			assert.Len(t, errs, tc.wantErrs)
+			for _, errMsg := range errs {
+				assert.Equal(t, ErrQueueFull, errMsg.Err)
+				assert.Equal(t, "consumer", errMsg.Queue)
+			}
+		})
+	}
+}
+
+func drainErr(ch <-chan ErrMessage) []ErrMessage {
+	var res []ErrMessage
+	for {
+		select {
+		case msg := <-ch:
+			res = append(res, msg)
+		default:
+			return res
+		}
+	}
+}
+
+func TestBroker_OverflowBlock(t *testing.T) {
+	b := NewBroker()
+	defer b.Close("order")
+	require.NoError(t, subscribeErr(b.Subscribe("order", "slow", SubscribeWithCapacity(1), SubscribeWithOverflow(OverflowBlock))))
+	require.NoError(t, subscribeErr(b.Subscribe("order", "fast", SubscribeWithCapacity(10))))
+	sendN(t, b, "order", 0, 1)
+
+	// 队列已满，阻塞到ctx超时
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	report, err := b.Publish(ctx, Message{Topic: "order", Content: "msg-1"})
+	cancel()
+	require.NoError(t, err)
+	for _, r := range report.Results {
+		if r.Queue == "slow" {
+			assert.Equal(t, DeliveryTimeout, r.Status)
+			assert.Equal(t, context.DeadlineExceeded, r.Err)
+		} else {
+			assert.Equal(t, DeliveryDelivered, r.Status)
+		}
+	}
+
+	done := make(chan *DeliveryReport)
+	go func() {
+		report, err := b.Publish(context.Background(), Message{Topic: "order", Content: "msg-2"})
+		assert.NoError(t, err)
+		done <- report
+	}()
+	// 阻塞的时候没有持有Broker的锁，其他操作不受影响
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, subscribeErr(b.Subscribe("order", "other")))
+	fast, _ := b.Queue("order", "fast")
+	assert.Equal(t, []any{"msg-0", "msg-1", "msg-2"}, contents(drain(fast)))
+
+	// 消费者取走消息之后阻塞的Publish投递成功
+	slow, _ := b.Queue("order", "slow")
+	assert.Equal(t, "msg-0", receiveMsg(t, slow).Content)
+	report = <-done
+	for _, r := range report.Results {
+		assert.Equal(t, DeliveryDelivered, r.Status)
+	}
+	assert.Equal(t, "msg-2", receiveMsg(t, slow).Content)
+}
+
+func TestBroker_ErrQueueFull(t *testing.T) {
+	b := NewBroker()
+	defer b.Close("order")
+	require.NoError(t, subscribeErr(b.Subscribe("order", "consumer", SubscribeWithCapacity(1))))
+	// 错误队列满了之后不会阻塞Send
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sendN(t, b, "order", 0, 2*DefaultCapacity)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("错误队列满了之后Send被阻塞")
+	}
+	errCh, _ := b.ErrQueue("order")
+	assert.Len(t, drainErr(errCh), DefaultCapacity)
+}
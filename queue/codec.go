@@ -0,0 +1,26 @@
+package queue
+
+import "encoding/json"
+
+// Codec 消息的编解码器，持久化到磁盘或者跨进程传输时使用
+type Codec interface {
+	Encode(msg Message) ([]byte, error)
+	Decode(data []byte) (Message, error)
+}
+
+// JSONCodec 使用JSON编解码消息，默认的编解码器。
+// 注意解码之后Content会变成JSON对应的通用类型，例如结构体会变成map[string]any，数字会变成float64，
+// 需要保留具体类型时请使用自定义的Codec
+type JSONCodec struct{}
+
+var _ Codec = JSONCodec{}
+
+func (JSONCodec) Encode(msg Message) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+func (JSONCodec) Decode(data []byte) (Message, error) {
+	var msg Message
+	err := json.Unmarshal(data, &msg)
+	return msg, err
+}
@@ -0,0 +1,194 @@
+package queue
+
+import (
+	"errors"
+	"hash/fnv"
+	"sync/atomic"
+)
+
+// GroupMember 消费组中的一个消费者，Balancer根据它选择接收消息的消费者
+type GroupMember struct {
+	// Name 消费者的名称
+	Name string
+	// Pending 已经投递但是还没有处理完的消息数，
+	// 开启确认时是没有确认的消息数，否则是队列中还没有被取走的消息数
+	Pending int
+}
+
+// Balancer 消费组的负载均衡策略，决定一条消息投递给组中的哪一个消费者，
+// Publish持有Broker的锁调用Pick，实现不能阻塞，也不能调用Broker的方法
+type Balancer interface {
+	// Pick 返回接收msg的消费者在members中的下标，members按照加入消费组的顺序排列并且不为空
+	Pick(msg Message, members []GroupMember) int
+}
+
+// RoundRobinBalancer 轮询，默认的策略
+type RoundRobinBalancer struct {
+	cnt atomic.Uint64
+}
+
+func NewRoundRobinBalancer() *RoundRobinBalancer {
+	return &RoundRobinBalancer{}
+}
+
+func (r *RoundRobinBalancer) Pick(msg Message, members []GroupMember) int {
+	return int((r.cnt.Add(1) - 1) % uint64(len(members)))
+}
+
+// LeastLoadedBalancer 投递给未处理消息最少的消费者，处理得快的消费者会分到更多的消息
+type LeastLoadedBalancer struct{}
+
+func (LeastLoadedBalancer) Pick(msg Message, members []GroupMember) int {
+	idx := 0
+	for i, m := range members {
+		if m.Pending < members[idx].Pending {
+			idx = i
+		}
+	}
+	return idx
+}
+
+// KeyHashBalancer 按照Message.Key的哈希值选择消费者，Key相同的消息总是投递给同一个消费者，
+// 因此可以保证同一个Key的消息是按顺序处理的。Key为空时使用ID。
+// 消费者加入或者离开消费组时，部分Key会被分配给别的消费者
+type KeyHashBalancer struct{}
+
+func (KeyHashBalancer) Pick(msg Message, members []GroupMember) int {
+	key := msg.Key
+	if key == "" {
+		key = msg.ID
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(members)))
+}
+
+var (
+	_ Balancer = (*RoundRobinBalancer)(nil)
+	_ Balancer = LeastLoadedBalancer{}
+	_ Balancer = KeyHashBalancer{}
+)
+
+// SubscribeWithBalancer 设置消费组的负载均衡策略，默认为RoundRobinBalancer，
+// 只在创建消费组的时候(第一个消费者加入时)生效
+func SubscribeWithBalancer(balancer Balancer) SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		cfg.balancer = balancer
+	}
+}
+
+// consumerGroup 消费组，组中的消费者竞争消费，每条消息只会投递给其中一个消费者
+type consumerGroup struct {
+	balancer Balancer
+	// 只接收Headers中包含这些键值对的消息
+	headers map[string]string
+	names   []string
+	members []*subscription
+}
+
+// pick 选择接收msg的消费者
+func (g *consumerGroup) pick(msg Message) (string, *subscription) {
+	members := make([]GroupMember, len(g.members))
+	for i, sub := range g.members {
+		members[i] = GroupMember{
+			Name:    g.names[i],
+			Pending: sub.pending(),
+		}
+	}
+	idx := g.balancer.Pick(msg, members)
+	return g.names[idx], g.members[idx]
+}
+
+// SubscribeGroup 以消费组的方式订阅topic，同一个消费组中的消费者竞争消费，每条消息只会投递给其中一个，
+// 不同的消费组以及Subscribe订阅的队列都会收到全部的消息。通过GroupQueue获取消费者接收消息的管道。
+// 消费组只接收订阅之后发送的消息，不支持SubscribeFromEarliest和SubscribeFromOffset，topic可以使用通配符
+func (b *Broker) SubscribeGroup(topic, group, consumer string, opts ...SubscribeOption) (*Subscription, error) {
+	cfg := newSubscribeConfig(opts)
+	if cfg.start != startLatest {
+		return nil, errors.New("消费组不支持指定开始消费的位置")
+	}
+	if err := validatePattern(topic); err != nil {
+		return nil, err
+	}
+	sub := newSubscription(cfg)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closing {
+		return nil, ErrBrokerClosed
+	}
+	groups, ok := b.groups[topic]
+	if !ok {
+		groups = map[string]*consumerGroup{}
+		b.groups[topic] = groups
+	}
+	g, ok := groups[group]
+	if !ok {
+		g = &consumerGroup{balancer: cfg.balancer, headers: cfg.headers}
+		if g.balancer == nil {
+			g.balancer = NewRoundRobinBalancer()
+		}
+		groups[group] = g
+	}
+	for _, name := range g.names {
+		if name == consumer {
+			return nil, errors.New("消费者已存在，请勿重复订阅")
+		}
+	}
+
+	b.startSubscription(topic, sub)
+	b.ensureTopic(topic)
+	g.names = append(g.names, consumer)
+	g.members = append(g.members, sub)
+	return b.newHandle(topic, group, consumer, sub), nil
+}
+
+// GroupQueue 获取消费组中的消费者接收消息的管道
+func (b *Broker) GroupQueue(topic, group, consumer string) (<-chan Message, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	g, ok := b.groups[topic][group]
+	if !ok {
+		return nil, false
+	}
+	for i, name := range g.names {
+		if name == consumer {
+			return g.members[i].out, true
+		}
+	}
+	return nil, false
+}
+
+// LeaveGroup 消费者离开消费组并关闭它的管道，之后的消息由组中剩下的消费者处理，
+// 已经投递给它但是还没有处理的消息会丢失
+func (b *Broker) LeaveGroup(topic, group, consumer string) {
+	b.mu.Lock()
+	sub, errCh := b.removeMember(topic, group, consumer, nil)
+	b.mu.Unlock()
+	stopSubscription(sub, errCh)
+}
+
+// removeMember 把消费者从消费组中删除，expect不为nil时只有消费者还是expect的时候才删除，
+// 返回被删除的订阅和需要关闭的错误队列，调用方需要持有b.mu
+func (b *Broker) removeMember(topic, group, consumer string, expect *subscription) (*subscription, chan ErrMessage) {
+	g, ok := b.groups[topic][group]
+	if !ok {
+		return nil, nil
+	}
+	var sub *subscription
+	for i, name := range g.names {
+		if name == consumer && (expect == nil || g.members[i] == expect) {
+			sub = g.members[i]
+			g.names = append(g.names[:i:i], g.names[i+1:]...)
+			g.members = append(g.members[:i:i], g.members[i+1:]...)
+			break
+		}
+	}
+	if sub == nil {
+		return nil, nil
+	}
+	if len(g.members) == 0 {
+		delete(b.groups[topic], group)
+	}
+	return sub, b.unusedErrQueue(topic)
+}
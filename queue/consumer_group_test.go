@@ -0,0 +1,152 @@
+package queue
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// drain 不阻塞地读出ch中所有的消息
+func drain(ch <-chan Message) []Message {
+	var res []Message
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return res
+			}
+			res = append(res, msg)
+		default:
+			return res
+		}
+	}
+}
+
+func groupQueues(t *testing.T, b *Broker, topic, group string, consumers ...string) []<-chan Message {
+	res := make([]<-chan Message, 0, len(consumers))
+	for _, c := range consumers {
+		ch, ok := b.GroupQueue(topic, group, c)
+		require.True(t, ok)
+		res = append(res, ch)
+	}
+	return res
+}
+
+func TestBroker_SubscribeGroup(t *testing.T) {
+	b := NewBroker()
+	defer b.Close("order")
+	for _, c := range []string{"c1", "c2", "c3"} {
+		require.NoError(t, subscribeErr(b.SubscribeGroup("order", "billing", c)))
+	}
+	require.NoError(t, subscribeErr(b.SubscribeGroup("order", "shipping", "c1")))
+	require.NoError(t, subscribeErr(b.Subscribe("order", "audit")))
+	assert.EqualError(t, subscribeErr(b.SubscribeGroup("order", "billing", "c1")), "消费者已存在，请勿重复订阅")
+	assert.EqualError(t, subscribeErr(b.SubscribeGroup("order", "billing", "c4", SubscribeFromEarliest())),
+		"消费组不支持指定开始消费的位置")
+
+	sendN(t, b, "order", 0, 6)
+	// 组内轮询，每个消费者分到两条
+	for _, ch := range groupQueues(t, b, "order", "billing", "c1", "c2", "c3") {
+		assert.Len(t, drain(ch), 2)
+	}
+	// 不同的消费组和普通的队列都能收到全部的消息
+	assert.Len(t, drain(groupQueues(t, b, "order", "shipping", "c1")[0]), 6)
+	audit, _ := b.Queue("order", "audit")
+	assert.Len(t, drain(audit), 6)
+}
+
+func TestBroker_LeaveGroup(t *testing.T) {
+	b := NewBroker()
+	require.NoError(t, subscribeErr(b.SubscribeGroup("order", "billing", "c1")))
+	require.NoError(t, subscribeErr(b.SubscribeGroup("order", "billing", "c2")))
+	chs := groupQueues(t, b, "order", "billing", "c1", "c2")
+
+	b.LeaveGroup("order", "billing", "c1")
+	_, ok := b.GroupQueue("order", "billing", "c1")
+	assert.False(t, ok)
+	_, ok = <-chs[0]
+	assert.False(t, ok)
+
+	// 剩下的消费者处理所有的消息
+	sendN(t, b, "order", 0, 3)
+	assert.Len(t, drain(chs[1]), 3)
+
+	// 最后一个消费者离开之后topic不存在了
+	b.LeaveGroup("order", "billing", "c2")
+	assert.EqualError(t, b.Send(Message{Topic: "order"}), "topic不存在")
+}
+
+func TestKeyHashBalancer(t *testing.T) {
+	b := NewBroker()
+	defer b.Close("order")
+	for _, c := range []string{"c1", "c2", "c3"} {
+		require.NoError(t, subscribeErr(b.SubscribeGroup("order", "billing", c, SubscribeWithBalancer(KeyHashBalancer{}))))
+	}
+
+	for i := 0; i < 30; i++ {
+		require.NoError(t, b.Send(Message{
+			Topic:   "order",
+			Key:     fmt.Sprintf("user-%d", i%5),
+			Content: i,
+		}))
+	}
+
+	// 同一个Key的消息只会投递给一个消费者，并且保持发送的顺序
+	owners := map[string]int{}
+	total := 0
+	for i, ch := range groupQueues(t, b, "order", "billing", "c1", "c2", "c3") {
+		last := map[string]int{}
+		for _, msg := range drain(ch) {
+			total++
+			if owner, ok := owners[msg.Key]; ok {
+				assert.Equal(t, owner, i)
+			}
+			owners[msg.Key] = i
+			if prev, ok := last[msg.Key]; ok {
+				assert.Greater(t, msg.Content, prev)
+			}
+			last[msg.Key] = msg.Content.(int)
+		}
+	}
+	assert.Equal(t, 30, total)
+	assert.Len(t, owners, 5)
+}
+
+func TestLeastLoadedBalancer(t *testing.T) {
+	testCases := []struct {
+		name    string
+		members []GroupMember
+		want    int
+	}{
+		{
+			name:    "least pending",
+			members: []GroupMember{{Name: "c1", Pending: 3}, {Name: "c2", Pending: 1}, {Name: "c3", Pending: 2}},
+			want:    1,
+		},
+		{
+			name:    "first on tie",
+			members: []GroupMember{{Name: "c1", Pending: 1}, {Name: "c2", Pending: 1}},
+			want:    0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, LeastLoadedBalancer{}.Pick(Message{}, tc.members))
+		})
+	}
+
+	// 处理得快的消费者分到更多的消息
+	b := NewBroker()
+	defer b.Close("order")
+	require.NoError(t, subscribeErr(b.SubscribeGroup("order", "billing", "slow", SubscribeWithBalancer(LeastLoadedBalancer{}))))
+	require.NoError(t, subscribeErr(b.SubscribeGroup("order", "billing", "fast")))
+	chs := groupQueues(t, b, "order", "billing", "slow", "fast")
+	for i := 0; i < 5; i++ {
+		sendN(t, b, "order", i, 1)
+		drain(chs[1])
+	}
+	assert.Len(t, drain(chs[0]), 1)
+}
@@ -0,0 +1,115 @@
+package idempotent
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/liquanhui-99/gotool/queue"
+)
+
+const (
+	DefaultWindow = 24 * time.Hour   // 默认的去重窗口
+	DefaultLease  = 30 * time.Second // 默认处理一条消息的租约
+)
+
+// Handler 处理一条消息，返回error表示处理失败
+type Handler func(ctx context.Context, msg queue.Message) error
+
+type ConsumerOption func(*Consumer)
+
+// ConsumerWithWindow 设置去重窗口，处理成功之后window内重复投递的消息都会被跳过，默认为DefaultWindow
+func ConsumerWithWindow(window time.Duration) ConsumerOption {
+	return func(c *Consumer) {
+		c.window = window
+	}
+}
+
+// ConsumerWithLease 设置处理一条消息的租约，需要大于handler的执行时间，
+// 处理的过程中宕机时超过lease之后才能重新处理，默认为DefaultLease
+func ConsumerWithLease(lease time.Duration) ConsumerOption {
+	return func(c *Consumer) {
+		c.lease = lease
+	}
+}
+
+// ConsumerWithKey 设置去重使用的标识，默认是消息的ID，返回空字符串的消息不去重
+func ConsumerWithKey(key func(msg queue.Message) string) ConsumerOption {
+	return func(c *Consumer) {
+		c.key = key
+	}
+}
+
+// Consumer 幂等的消费者，按照消息的ID去重，同一条消息在去重窗口内只会被成功处理一次
+type Consumer struct {
+	// name 消费者的名称，不同名称的消费者分别去重，同一条消息可以被它们各处理一次
+	name   string
+	store  Store
+	window time.Duration
+	lease  time.Duration
+	key    func(msg queue.Message) string
+}
+
+func NewConsumer(name string, store Store, opts ...ConsumerOption) *Consumer {
+	c := &Consumer{
+		name:   name,
+		store:  store,
+		window: DefaultWindow,
+		lease:  DefaultLease,
+		key: func(msg queue.Message) string {
+			return msg.ID
+		},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Handle 处理一条消息，已经处理过时不调用handler，返回ErrDuplicate，
+// 其他消费者正在处理时返回ErrInProgress。handler失败时释放处理状态，重新投递的消息可以再次处理。
+// 处理的时间超过lease，处理状态已经被其他消费者重新占用时不覆盖它，返回ErrReservationLost
+func (c *Consumer) Handle(ctx context.Context, msg queue.Message, handler Handler) error {
+	id := c.key(msg)
+	if id == "" {
+		return handler(ctx, msg)
+	}
+	key := c.name + ":" + id
+	token, err := c.store.Reserve(ctx, key, c.lease)
+	if err != nil {
+		return err
+	}
+
+	if err := handler(ctx, msg); err != nil {
+		if relErr := c.store.Release(ctx, key, token); relErr != nil {
+			return errors.Join(err, relErr)
+		}
+		return err
+	}
+	return c.store.Commit(ctx, key, token, c.window)
+}
+
+// Consume 依次处理sub中的消息，直到sub被取消或者ctx结束，ctx结束时取消订阅。
+// sub必须开启确认(queue.SubscribeWithAck，redis_stream的订阅总是需要确认)，没有开启时Ack和Nack什么都不做，
+// 处理失败的消息不会重新投递。处理成功和重复的消息会被确认，处理失败和其他消费者正在处理的消息Nack之后重新投递，
+// 其他消费者处理完之后重新投递的消息会作为重复的消息确认，一直没有处理完时超过最大投递次数之后进入死信队列
+func (c *Consumer) Consume(ctx context.Context, sub *queue.Subscription, handler Handler) {
+	for {
+		select {
+		case msg, ok := <-sub.C():
+			if !ok {
+				return
+			}
+			err := c.Handle(ctx, msg, handler)
+			switch {
+			case err == nil, errors.Is(err, ErrDuplicate):
+				_ = msg.Ack()
+			default:
+				_ = msg.Nack()
+			}
+		case <-ctx.Done():
+			sub.Unsubscribe()
+			return
+		}
+	}
+}
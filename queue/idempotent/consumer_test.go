@@ -0,0 +1,142 @@
+package idempotent
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/liquanhui-99/gotool/queue"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsumer_Handle(t *testing.T) {
+	ctx := context.Background()
+	c := NewConsumer("billing", NewCacheStore(newLocalCache()))
+	var calls int
+	ok := func(ctx context.Context, msg queue.Message) error {
+		calls++
+		return nil
+	}
+	fail := func(ctx context.Context, msg queue.Message) error {
+		calls++
+		return errors.New("处理失败")
+	}
+
+	// 处理失败之后可以重新处理，处理成功之后重复的消息被跳过
+	assert.EqualError(t, c.Handle(ctx, queue.Message{ID: "msg-1"}, fail), "处理失败")
+	assert.NoError(t, c.Handle(ctx, queue.Message{ID: "msg-1"}, ok))
+	assert.Equal(t, ErrDuplicate, c.Handle(ctx, queue.Message{ID: "msg-1"}, ok))
+	assert.Equal(t, 2, calls)
+
+	// 不同的消费者分别去重
+	other := NewConsumer("shipping", c.store)
+	assert.NoError(t, other.Handle(ctx, queue.Message{ID: "msg-1"}, ok))
+	// 没有标识的消息不去重
+	assert.NoError(t, c.Handle(ctx, queue.Message{}, ok))
+	assert.NoError(t, c.Handle(ctx, queue.Message{}, ok))
+	assert.Equal(t, 5, calls)
+}
+
+func TestConsumer_HandleInProgress(t *testing.T) {
+	ctx := context.Background()
+	c := NewConsumer("billing", NewCacheStore(newLocalCache()),
+		ConsumerWithKey(func(msg queue.Message) string {
+			return msg.Key
+		}))
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- c.Handle(ctx, queue.Message{ID: "msg-1", Key: "order-1"}, func(ctx context.Context, msg queue.Message) error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+
+	<-started
+	// 使用Key去重，ID不同的消息也会被认为是同一条
+	assert.Equal(t, ErrInProgress, c.Handle(ctx, queue.Message{ID: "msg-2", Key: "order-1"}, nil))
+	close(release)
+	require.NoError(t, <-done)
+	assert.Equal(t, ErrDuplicate, c.Handle(ctx, queue.Message{ID: "msg-2", Key: "order-1"}, nil))
+}
+
+func TestConsumer_Consume(t *testing.T) {
+	b := queue.NewBroker()
+	sub, err := b.Subscribe("order", "billing", queue.SubscribeWithAck(time.Second))
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	c := NewConsumer("billing", NewCacheStore(newLocalCache()))
+
+	var calls atomic.Int32
+	handled := make(chan string, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Consume(ctx, sub, func(ctx context.Context, msg queue.Message) error {
+			// 第一次处理失败，Nack之后重新投递
+			if calls.Add(1) == 1 {
+				return errors.New("处理失败")
+			}
+			handled <- msg.ID
+			return nil
+		})
+	}()
+
+	// 同一条消息发送了两次，只会处理成功一次
+	for _, id := range []string{"msg-1", "msg-1", "msg-2"} {
+		require.NoError(t, b.Send(queue.Message{ID: id, Topic: "order"}))
+	}
+	for _, want := range []string{"msg-1", "msg-2"} {
+		select {
+		case id := <-handled:
+			assert.Equal(t, want, id)
+		case <-time.After(time.Second):
+			t.Fatal("没有处理消息")
+		}
+	}
+	select {
+	case id := <-handled:
+		t.Fatalf("重复处理了%s", id)
+	case <-time.After(20 * time.Millisecond):
+	}
+	assert.Equal(t, int32(3), calls.Load())
+
+	// ctx结束之后取消订阅
+	cancel()
+	<-done
+	_, ok := b.Queue("order", "billing")
+	assert.False(t, ok)
+}
+
+func TestConsumer_ConsumeInProgress(t *testing.T) {
+	b := queue.NewBroker()
+	sub, err := b.Subscribe("order", "billing", queue.SubscribeWithAck(time.Minute), queue.SubscribeWithMaxAttempts(2))
+	require.NoError(t, err)
+	dead, err := b.Subscribe(queue.DeadLetterTopic("order"), "dead")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// 其他消费者一直在处理这条消息
+	store := NewCacheStore(newLocalCache())
+	_, err = store.Reserve(ctx, "billing:msg-1", time.Minute)
+	require.NoError(t, err)
+	c := NewConsumer("billing", store)
+	go c.Consume(ctx, sub, func(ctx context.Context, msg queue.Message) error {
+		t.Errorf("正在被处理的消息不应该再次处理: %v", msg.ID)
+		return nil
+	})
+
+	// 每次投递都Nack，不会等到确认超时，超过最大投递次数之后进入死信队列
+	require.NoError(t, b.Send(queue.Message{ID: "msg-1", Topic: "order"}))
+	select {
+	case msg := <-dead.C():
+		assert.Equal(t, "msg-1", msg.ID)
+	case <-time.After(time.Second):
+		t.Fatal("正在被处理的消息没有Nack")
+	}
+}
@@ -0,0 +1,11 @@
+-- 处理状态还是自己写入的才标记为处理完成，过期之后被其他消费者重新占用时返回0
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+    if tonumber(ARGV[3]) > 0 then
+        redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+    else
+        redis.call("SET", KEYS[1], ARGV[2])
+    end
+    return 1
+else
+    return 0
+end
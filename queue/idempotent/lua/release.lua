@@ -0,0 +1,6 @@
+-- 处理状态还是自己写入的才删除，避免删掉其他消费者的处理状态
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+    return redis.call("DEL", KEYS[1])
+else
+    return 0
+end
@@ -0,0 +1,241 @@
+package idempotent
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/liquanhui-99/gotool/queue"
+)
+
+const (
+	DefaultProcessedTable = "processed_message" // 默认的处理记录表名
+	DefaultOutboxTable    = "outbox_message"    // 默认的发件箱表名
+	DefaultRelayBatch     = 100                 // Relay默认每次读取的消息数
+)
+
+type OutboxOption func(*Outbox)
+
+// OutboxWithTables 设置处理记录表和发件箱表的表名
+func OutboxWithTables(processed, outbox string) OutboxOption {
+	return func(o *Outbox) {
+		o.processedTable = processed
+		o.outboxTable = outbox
+	}
+}
+
+// OutboxWithDollarPlaceholder 使用PostgreSQL的$1、$2形式的占位符，默认是?
+func OutboxWithDollarPlaceholder() OutboxOption {
+	return func(o *Outbox) {
+		o.placeholder = func(i int) string {
+			return fmt.Sprintf("$%d", i)
+		}
+	}
+}
+
+// OutboxWithCodec 设置发件箱中的消息的编解码器，编码的结果以文本的形式保存，默认为queue.JSONCodec
+func OutboxWithCodec(c queue.Codec) OutboxOption {
+	return func(o *Outbox) {
+		o.codec = c
+	}
+}
+
+// Outbox 事务性发件箱。处理消息的时候在业务的数据库事务中插入处理记录，同时写入要发送的消息，
+// 和业务数据一起提交，重复投递的消息会因为处理记录已经存在而跳过；事务提交之后由Relay把消息发送出去。
+// 插入依赖INSERT ... ON CONFLICT语法，PostgreSQL和SQLite都支持
+type Outbox struct {
+	db             *sql.DB
+	processedTable string
+	outboxTable    string
+	// 生成第i个(从1开始)参数的占位符
+	placeholder func(i int) string
+	codec       queue.Codec
+	// 获取当前时间，测试时可以替换
+	now func() time.Time
+
+	processQuery string
+	publishQuery string
+	relayQuery   string
+	deleteQuery  string
+	purgeQuery   string
+}
+
+func NewOutbox(db *sql.DB, opts ...OutboxOption) *Outbox {
+	o := &Outbox{
+		db:             db,
+		processedTable: DefaultProcessedTable,
+		outboxTable:    DefaultOutboxTable,
+		placeholder: func(i int) string {
+			return "?"
+		},
+		codec: queue.JSONCodec{},
+		now:   time.Now,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	p := o.placeholder
+	// 处理记录已经存在时什么都不做，影响行数为0
+	o.processQuery = fmt.Sprintf("INSERT INTO %s (message_key, processed_at) VALUES (%s, %s) "+
+		"ON CONFLICT (message_key) DO NOTHING", o.processedTable, p(1), p(2))
+	o.publishQuery = fmt.Sprintf("INSERT INTO %s (id, topic, payload, created_at) VALUES (%s, %s, %s, %s)",
+		o.outboxTable, p(1), p(2), p(3), p(4))
+	o.relayQuery = fmt.Sprintf("SELECT seq, payload FROM %s ORDER BY seq LIMIT %s", o.outboxTable, p(1))
+	o.deleteQuery = fmt.Sprintf("DELETE FROM %s WHERE seq = %s", o.outboxTable, p(1))
+	o.purgeQuery = fmt.Sprintf("DELETE FROM %s WHERE processed_at < %s", o.processedTable, p(1))
+	return o
+}
+
+// CreateTables 创建处理记录表和发件箱表，表已经存在时什么都不做。
+// 发件箱表的自增主键使用的是SQLite的语法，PostgreSQL中请自行建表，把seq定义为BIGSERIAL
+func (o *Outbox) CreateTables(ctx context.Context) error {
+	_, err := o.db.ExecContext(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s ("+
+		"message_key VARCHAR(255) NOT NULL PRIMARY KEY, "+
+		"processed_at BIGINT NOT NULL)", o.processedTable))
+	if err != nil {
+		return err
+	}
+	_, err = o.db.ExecContext(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s ("+
+		"seq INTEGER PRIMARY KEY AUTOINCREMENT, "+
+		"id VARCHAR(255) NOT NULL, "+
+		"topic VARCHAR(255) NOT NULL, "+
+		"payload TEXT NOT NULL, "+
+		"created_at BIGINT NOT NULL)", o.outboxTable))
+	return err
+}
+
+// Tx Outbox.Process中的事务，业务的读写都需要通过它完成
+type Tx struct {
+	*sql.Tx
+	outbox *Outbox
+}
+
+// Publish 把消息写入发件箱，事务提交之后才会被Relay发送出去
+func (tx *Tx) Publish(ctx context.Context, msg queue.Message) error {
+	o := tx.outbox
+	if msg.ID == "" {
+		msg.ID = uuid.New().String()
+	}
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = o.now()
+	}
+	data, err := o.codec.Encode(msg)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, o.publishQuery, msg.ID, msg.Topic, string(data), o.now().UnixMilli())
+	return err
+}
+
+// Process 在一个事务中处理key对应的消息：插入处理记录，调用fn执行业务逻辑，然后一起提交。
+// 已经处理过时不调用fn，返回ErrDuplicate；fn返回error或者panic时回滚，重新投递的消息可以再次处理
+func (o *Outbox) Process(ctx context.Context, key string, fn func(tx *Tx) error) (err error) {
+	sqlTx, err := o.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			_ = sqlTx.Rollback()
+			panic(r)
+		}
+		if err == nil {
+			return
+		}
+		if rbErr := ignoreDone(sqlTx.Rollback()); rbErr != nil {
+			err = errors.Join(err, rbErr)
+		}
+	}()
+
+	res, err := sqlTx.ExecContext(ctx, o.processQuery, key, o.now().UnixMilli())
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrDuplicate
+	}
+
+	if err = fn(&Tx{Tx: sqlTx, outbox: o}); err != nil {
+		return err
+	}
+	return sqlTx.Commit()
+}
+
+// ignoreDone 提交失败之后事务已经结束，回滚会返回sql.ErrTxDone
+func ignoreDone(err error) error {
+	if errors.Is(err, sql.ErrTxDone) {
+		return nil
+	}
+	return err
+}
+
+// Handle 以消息的ID作为处理记录，在事务中处理消息，consumer用于区分不同的消费者
+func (o *Outbox) Handle(ctx context.Context, consumer string, msg queue.Message, fn func(tx *Tx, msg queue.Message) error) error {
+	return o.Process(ctx, consumer+":"+msg.ID, func(tx *Tx) error {
+		return fn(tx, msg)
+	})
+}
+
+// SendFunc 发送一条消息，例如包装queue.Broker.Publish
+type SendFunc func(ctx context.Context, msg queue.Message) error
+
+// Relay 按照写入的顺序把发件箱中最多limit条消息发送出去，发送成功之后删除，返回发送的消息数，
+// limit小于等于0时使用DefaultRelayBatch。发送失败时停止，剩下的消息下次再发送。
+// 发送成功但是删除失败的消息会被再次发送，接收方需要去重
+func (o *Outbox) Relay(ctx context.Context, send SendFunc, limit int) (int, error) {
+	if limit <= 0 {
+		limit = DefaultRelayBatch
+	}
+	rows, err := o.db.QueryContext(ctx, o.relayQuery, limit)
+	if err != nil {
+		return 0, err
+	}
+	type record struct {
+		seq     int64
+		payload string
+	}
+	var records []record
+	for rows.Next() {
+		var r record
+		if err = rows.Scan(&r.seq, &r.payload); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		records = append(records, r)
+	}
+	// 先关闭结果集，SQLite只有一个连接的时候否则没法执行删除
+	if err = errors.Join(rows.Err(), rows.Close()); err != nil {
+		return 0, err
+	}
+
+	for i, r := range records {
+		msg, err := o.codec.Decode([]byte(r.payload))
+		if err != nil {
+			return i, err
+		}
+		if err = send(ctx, msg); err != nil {
+			return i, err
+		}
+		if _, err = o.db.ExecContext(ctx, o.deleteQuery, r.seq); err != nil {
+			return i + 1, err
+		}
+	}
+	return len(records), nil
+}
+
+// Purge 删除早于window的处理记录，之后重复投递的这些消息会被再次处理
+func (o *Outbox) Purge(ctx context.Context, window time.Duration) (int64, error) {
+	res, err := o.db.ExecContext(ctx, o.purgeQuery, o.now().Add(-window).UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,160 @@
+package idempotent
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/liquanhui-99/gotool/queue"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+// newSQLiteOutbox 每个测试用例使用一个独立的SQLite数据库文件
+func newSQLiteOutbox(t *testing.T) *Outbox {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "outbox.db")+"?_pragma=busy_timeout(5000)")
+	require.NoError(t, err)
+	// SQLite只支持单个写连接
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	_, err = db.Exec("CREATE TABLE balance (account VARCHAR(255) PRIMARY KEY, amount BIGINT NOT NULL)")
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO balance VALUES ('alice', 0)")
+	require.NoError(t, err)
+
+	o := NewOutbox(db)
+	require.NoError(t, o.CreateTables(context.Background()))
+	return o
+}
+
+func balance(t *testing.T, o *Outbox) int {
+	var amount int
+	require.NoError(t, o.db.QueryRow("SELECT amount FROM balance WHERE account = 'alice'").Scan(&amount))
+	return amount
+}
+
+func TestOutbox_Query(t *testing.T) {
+	o := NewOutbox(nil, OutboxWithTables("processed", "outbox"), OutboxWithDollarPlaceholder())
+	assert.Equal(t, "INSERT INTO processed (message_key, processed_at) VALUES ($1, $2) "+
+		"ON CONFLICT (message_key) DO NOTHING", o.processQuery)
+	assert.Equal(t, "INSERT INTO outbox (id, topic, payload, created_at) VALUES ($1, $2, $3, $4)", o.publishQuery)
+	assert.Equal(t, "SELECT seq, payload FROM outbox ORDER BY seq LIMIT $1", o.relayQuery)
+	assert.Equal(t, "DELETE FROM outbox WHERE seq = $1", o.deleteQuery)
+	assert.Equal(t, "DELETE FROM processed WHERE processed_at < $1", o.purgeQuery)
+}
+
+func TestOutbox_Handle(t *testing.T) {
+	ctx := context.Background()
+	o := newSQLiteOutbox(t)
+	deposit := func(tx *Tx, msg queue.Message) error {
+		if _, err := tx.ExecContext(ctx, "UPDATE balance SET amount = amount + 10 WHERE account = 'alice'"); err != nil {
+			return err
+		}
+		return tx.Publish(ctx, queue.Message{Topic: "deposited", Content: msg.ID})
+	}
+	failed := func(tx *Tx, msg queue.Message) error {
+		if err := deposit(tx, msg); err != nil {
+			return err
+		}
+		return errors.New("处理失败")
+	}
+
+	// 处理失败时业务数据、处理记录和发件箱中的消息一起回滚
+	assert.EqualError(t, o.Handle(ctx, "billing", queue.Message{ID: "msg-1"}, failed), "处理失败")
+	assert.Equal(t, 0, balance(t, o))
+	assert.Panics(t, func() {
+		_ = o.Handle(ctx, "billing", queue.Message{ID: "msg-1"}, func(tx *Tx, msg queue.Message) error {
+			_ = deposit(tx, msg)
+			panic("boom")
+		})
+	})
+	assert.Equal(t, 0, balance(t, o))
+
+	// 重复投递的消息被跳过
+	require.NoError(t, o.Handle(ctx, "billing", queue.Message{ID: "msg-1"}, deposit))
+	assert.Equal(t, ErrDuplicate, o.Handle(ctx, "billing", queue.Message{ID: "msg-1"}, deposit))
+	require.NoError(t, o.Handle(ctx, "billing", queue.Message{ID: "msg-2"}, deposit))
+	assert.Equal(t, 20, balance(t, o))
+
+	// 按照写入的顺序发送
+	var sent []any
+	send := func(ctx context.Context, msg queue.Message) error {
+		sent = append(sent, msg.Content)
+		return nil
+	}
+	n, err := o.Relay(ctx, send, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []any{"msg-1", "msg-2"}, sent)
+	n, err = o.Relay(ctx, send, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestOutbox_Relay(t *testing.T) {
+	ctx := context.Background()
+	o := newSQLiteOutbox(t)
+	for _, id := range []string{"msg-1", "msg-2", "msg-3"} {
+		require.NoError(t, o.Process(ctx, id, func(tx *Tx) error {
+			return tx.Publish(ctx, queue.Message{ID: id, Topic: "order"})
+		}))
+	}
+
+	// 发送失败时停止，剩下的消息下次再发送
+	var sent []string
+	n, err := o.Relay(ctx, func(ctx context.Context, msg queue.Message) error {
+		if msg.ID == "msg-2" {
+			return errors.New("发送失败")
+		}
+		sent = append(sent, msg.ID)
+		return nil
+	}, 0)
+	assert.EqualError(t, err, "发送失败")
+	assert.Equal(t, 1, n)
+
+	// 发送到Broker
+	b := queue.NewBroker()
+	sub, err := b.Subscribe("order", "consumer")
+	require.NoError(t, err)
+	n, err = o.Relay(ctx, func(ctx context.Context, msg queue.Message) error {
+		_, err := b.Publish(ctx, msg)
+		return err
+	}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = o.Relay(ctx, func(ctx context.Context, msg queue.Message) error {
+		_, err := b.Publish(ctx, msg)
+		return err
+	}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	for _, want := range []string{"msg-2", "msg-3"} {
+		msg := <-sub.C()
+		assert.Equal(t, want, msg.ID)
+		assert.Equal(t, "order", msg.Topic)
+	}
+}
+
+func TestOutbox_Purge(t *testing.T) {
+	ctx := context.Background()
+	o := newSQLiteOutbox(t)
+	now := time.Now()
+	o.now = func() time.Time { return now }
+	noop := func(tx *Tx) error { return nil }
+	require.NoError(t, o.Process(ctx, "msg-1", noop))
+	now = now.Add(time.Hour)
+	require.NoError(t, o.Process(ctx, "msg-2", noop))
+
+	// 超过窗口的处理记录被删除之后可以再次处理
+	n, err := o.Purge(ctx, 30*time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.NoError(t, o.Process(ctx, "msg-1", noop))
+	assert.Equal(t, ErrDuplicate, o.Process(ctx, "msg-2", noop))
+}
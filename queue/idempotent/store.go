@@ -0,0 +1,237 @@
+package idempotent
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/liquanhui-99/gotool/cache"
+	"github.com/liquanhui-99/gotool/cache/local_cache"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	DefaultKeyPrefix = "idempotent:" // CacheStore默认的key前缀
+
+	statusProcessing = "processing"
+	statusDone       = "done"
+)
+
+//go:embed lua/commit.lua
+var commitScript string
+
+//go:embed lua/release.lua
+var releaseScript string
+
+var (
+	ErrDuplicate       = errors.New("消息已经处理过")
+	ErrInProgress      = errors.New("消息正在被处理")
+	ErrReservationLost = errors.New("处理状态已经过期，被其他消费者重新占用")
+)
+
+// Store 记录消息的处理状态，用于去重
+type Store interface {
+	// Reserve 开始处理key对应的消息，处理状态保留lease，超过之后其他消费者可以重新处理。
+	// 返回的token标识这一次占用，Commit和Release时传入。
+	// 已经处理过时返回ErrDuplicate，别人正在处理时返回ErrInProgress
+	Reserve(ctx context.Context, key string, lease time.Duration) (string, error)
+	// Commit 标记处理完成，window内重复的消息都会被跳过。
+	// 处理状态已经过期并且被其他消费者重新占用时返回ErrReservationLost
+	Commit(ctx context.Context, key, token string, window time.Duration) error
+	// Release 处理失败时删除处理状态，重新投递的消息可以再次处理。
+	// 处理状态已经不是token对应的这一次占用时不删除，返回ErrReservationLost
+	Release(ctx context.Context, key, token string) error
+}
+
+// newReservation 生成一次占用的token和写入的处理状态
+func newReservation() (token, val string) {
+	token = uuid.New().String()
+	return token, statusProcessing + ":" + token
+}
+
+// inProgress 处理状态是不是正在处理
+func inProgress(val string) bool {
+	return strings.HasPrefix(val, statusProcessing+":")
+}
+
+type CacheStoreOption func(*CacheStore)
+
+// CacheStoreWithPrefix 设置写入缓存的key的前缀，默认为DefaultKeyPrefix
+func CacheStoreWithPrefix(prefix string) CacheStoreOption {
+	return func(s *CacheStore) {
+		s.prefix = prefix
+	}
+}
+
+var _ Store = (*CacheStore)(nil)
+
+// CacheStore 基于cache.Cache的Store，适用于单个进程内的去重。
+// cache.Cache没有原子的SetNX，Reserve的先查后写只在同一个进程内是原子的，
+// 多个进程共享Redis时请使用RedisStore
+type CacheStore struct {
+	cache  cache.Cache
+	prefix string
+	// 保护Reserve中的先查后写
+	mu sync.Mutex
+}
+
+func NewCacheStore(c cache.Cache, opts ...CacheStoreOption) *CacheStore {
+	s := &CacheStore{
+		cache:  c,
+		prefix: DefaultKeyPrefix,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *CacheStore) Reserve(ctx context.Context, key string, lease time.Duration) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	val, err := s.get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case val == statusDone:
+		return "", ErrDuplicate
+	case inProgress(val):
+		return "", ErrInProgress
+	}
+	token, val := newReservation()
+	return token, s.cache.Set(ctx, s.prefix+key, val, lease)
+}
+
+func (s *CacheStore) Commit(ctx context.Context, key, token string, window time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.hold(ctx, key, token); err != nil {
+		return err
+	}
+	return s.cache.Set(ctx, s.prefix+key, statusDone, window)
+}
+
+func (s *CacheStore) Release(ctx context.Context, key, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.hold(ctx, key, token); err != nil {
+		return err
+	}
+	err := s.cache.Delete(ctx, s.prefix+key)
+	if notFound(err) {
+		return nil
+	}
+	return err
+}
+
+// get 读取处理状态，不存在时返回空字符串
+func (s *CacheStore) get(ctx context.Context, key string) (string, error) {
+	val, err := s.cache.Get(ctx, s.prefix+key)
+	if err != nil {
+		if notFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	str, _ := val.(string)
+	return str, nil
+}
+
+// hold 处理状态是否还是token对应的这一次占用
+func (s *CacheStore) hold(ctx context.Context, key, token string) error {
+	val, err := s.get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if val != statusProcessing+":"+token {
+		return ErrReservationLost
+	}
+	return nil
+}
+
+// notFound 各个缓存实现在key不存在时返回的错误不同，BuildInMapCache中过期的key返回的是nil, nil
+func notFound(err error) bool {
+	return errors.Is(err, cache.ErrKeyNotFound) || errors.Is(err, local_cache.ErrKeyNotFound) ||
+		errors.Is(err, redis.Nil)
+}
+
+type RedisStoreOption func(*RedisStore)
+
+// RedisStoreWithPrefix 设置写入Redis的key的前缀，默认为DefaultKeyPrefix
+func RedisStoreWithPrefix(prefix string) RedisStoreOption {
+	return func(s *RedisStore) {
+		s.prefix = prefix
+	}
+}
+
+var _ Store = (*RedisStore)(nil)
+
+// RedisStore 基于Redis的Store，Reserve通过SETNX原子地写入处理状态，多个进程共享同一个Redis时也只有一个能处理成功
+type RedisStore struct {
+	client redis.Cmdable
+	prefix string
+}
+
+func NewRedisStore(client redis.Cmdable, opts ...RedisStoreOption) *RedisStore {
+	s := &RedisStore{
+		client: client,
+		prefix: DefaultKeyPrefix,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *RedisStore) Reserve(ctx context.Context, key string, lease time.Duration) (string, error) {
+	token, reserved := newReservation()
+	for {
+		ok, err := s.client.SetNX(ctx, s.prefix+key, reserved, lease).Result()
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return token, nil
+		}
+		val, err := s.client.Get(ctx, s.prefix+key).Result()
+		if errors.Is(err, redis.Nil) {
+			// SETNX之后key刚好过期了，重新尝试
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if val == statusDone {
+			return "", ErrDuplicate
+		}
+		return "", ErrInProgress
+	}
+}
+
+func (s *RedisStore) Commit(ctx context.Context, key, token string, window time.Duration) error {
+	res, err := s.client.Eval(ctx, commitScript, []string{s.prefix + key},
+		statusProcessing+":"+token, statusDone, window.Milliseconds()).Int64()
+	if err != nil {
+		return err
+	}
+	if res != 1 {
+		return ErrReservationLost
+	}
+	return nil
+}
+
+func (s *RedisStore) Release(ctx context.Context, key, token string) error {
+	res, err := s.client.Eval(ctx, releaseScript, []string{s.prefix + key}, statusProcessing+":"+token).Int64()
+	if err != nil {
+		return err
+	}
+	if res != 1 {
+		return ErrReservationLost
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+package idempotent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/liquanhui-99/gotool/cache/local_cache"
+	"github.com/liquanhui-99/gotool/cache/redis_cache/mocks"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newLocalCache Close会等到下一次轮询过期数据的时候才返回，测试中不关闭
+func newLocalCache() *local_cache.BuildInMapCache {
+	return local_cache.NewBuildInMapCache(16)
+}
+
+func TestCacheStore(t *testing.T) {
+	testCases := []struct {
+		name   string
+		before func(ctx context.Context, s *CacheStore) error
+		want   error
+	}{
+		{
+			name: "new",
+			before: func(ctx context.Context, s *CacheStore) error {
+				return nil
+			},
+		},
+		{
+			name: "in progress",
+			before: func(ctx context.Context, s *CacheStore) error {
+				_, err := s.Reserve(ctx, "msg", time.Minute)
+				return err
+			},
+			want: ErrInProgress,
+		},
+		{
+			name: "lease expired",
+			before: func(ctx context.Context, s *CacheStore) error {
+				_, err := s.Reserve(ctx, "msg", time.Millisecond)
+				time.Sleep(5 * time.Millisecond)
+				return err
+			},
+		},
+		{
+			name: "duplicate",
+			before: func(ctx context.Context, s *CacheStore) error {
+				token, err := s.Reserve(ctx, "msg", time.Minute)
+				return errors.Join(err, s.Commit(ctx, "msg", token, time.Minute))
+			},
+			want: ErrDuplicate,
+		},
+		{
+			name: "window expired",
+			before: func(ctx context.Context, s *CacheStore) error {
+				token, err := s.Reserve(ctx, "msg", time.Minute)
+				err = errors.Join(err, s.Commit(ctx, "msg", token, time.Millisecond))
+				time.Sleep(5 * time.Millisecond)
+				return err
+			},
+		},
+		{
+			name: "released",
+			before: func(ctx context.Context, s *CacheStore) error {
+				token, err := s.Reserve(ctx, "msg", time.Minute)
+				return errors.Join(err, s.Release(ctx, "msg", token))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := NewCacheStore(newLocalCache())
+			require.NoError(t, tc.before(ctx, s))
+			_, err := s.Reserve(ctx, "msg", time.Minute)
+			assert.Equal(t, tc.want, err)
+		})
+	}
+}
+
+// TestCacheStore_ExpiredHolder 处理状态过期之后被新的消费者占用，原来的消费者不能覆盖或者删除它
+func TestCacheStore_ExpiredHolder(t *testing.T) {
+	ctx := context.Background()
+	s := NewCacheStore(newLocalCache())
+	expired, err := s.Reserve(ctx, "msg", time.Millisecond)
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	holder, err := s.Reserve(ctx, "msg", time.Minute)
+	require.NoError(t, err)
+	assert.NotEqual(t, expired, holder)
+
+	assert.Equal(t, ErrReservationLost, s.Release(ctx, "msg", expired))
+	assert.Equal(t, ErrReservationLost, s.Commit(ctx, "msg", expired, time.Minute))
+	_, err = s.Reserve(ctx, "msg", time.Minute)
+	assert.Equal(t, ErrInProgress, err)
+
+	// 新的消费者依旧可以正常地提交
+	require.NoError(t, s.Commit(ctx, "msg", holder, time.Minute))
+	_, err = s.Reserve(ctx, "msg", time.Minute)
+	assert.Equal(t, ErrDuplicate, err)
+	assert.Equal(t, ErrReservationLost, s.Release(ctx, "msg", holder))
+}
+
+func TestRedisStore_Reserve(t *testing.T) {
+	testCases := []struct {
+		name string
+		mock func(cmd *mocks.MockCmdable)
+		want error
+	}{
+		{
+			name: "new",
+			mock: func(cmd *mocks.MockCmdable) {
+				cmd.EXPECT().SetNX(gomock.Any(), "order:msg", gomock.Any(), time.Minute).
+					Return(redis.NewBoolResult(true, nil))
+			},
+		},
+		{
+			name: "in progress",
+			mock: func(cmd *mocks.MockCmdable) {
+				cmd.EXPECT().SetNX(gomock.Any(), "order:msg", gomock.Any(), time.Minute).
+					Return(redis.NewBoolResult(false, nil))
+				cmd.EXPECT().Get(gomock.Any(), "order:msg").Return(redis.NewStringResult(statusProcessing+":token", nil))
+			},
+			want: ErrInProgress,
+		},
+		{
+			name: "duplicate",
+			mock: func(cmd *mocks.MockCmdable) {
+				cmd.EXPECT().SetNX(gomock.Any(), "order:msg", gomock.Any(), time.Minute).
+					Return(redis.NewBoolResult(false, nil))
+				cmd.EXPECT().Get(gomock.Any(), "order:msg").Return(redis.NewStringResult(statusDone, nil))
+			},
+			want: ErrDuplicate,
+		},
+		{
+			name: "expired after setnx",
+			mock: func(cmd *mocks.MockCmdable) {
+				gomock.InOrder(
+					cmd.EXPECT().SetNX(gomock.Any(), "order:msg", gomock.Any(), time.Minute).
+						Return(redis.NewBoolResult(false, nil)),
+					cmd.EXPECT().Get(gomock.Any(), "order:msg").Return(redis.NewStringResult("", redis.Nil)),
+					cmd.EXPECT().SetNX(gomock.Any(), "order:msg", gomock.Any(), time.Minute).
+						Return(redis.NewBoolResult(true, nil)),
+				)
+			},
+		},
+		{
+			name: "setnx error",
+			mock: func(cmd *mocks.MockCmdable) {
+				cmd.EXPECT().SetNX(gomock.Any(), "order:msg", gomock.Any(), time.Minute).
+					Return(redis.NewBoolResult(false, context.DeadlineExceeded))
+			},
+			want: context.DeadlineExceeded,
+		},
+		{
+			name: "get error",
+			mock: func(cmd *mocks.MockCmdable) {
+				cmd.EXPECT().SetNX(gomock.Any(), "order:msg", gomock.Any(), time.Minute).
+					Return(redis.NewBoolResult(false, nil))
+				cmd.EXPECT().Get(gomock.Any(), "order:msg").Return(redis.NewStringResult("", context.DeadlineExceeded))
+			},
+			want: context.DeadlineExceeded,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			cmd := mocks.NewMockCmdable(ctrl)
+			tc.mock(cmd)
+			s := NewRedisStore(cmd, RedisStoreWithPrefix("order:"))
+			token, err := s.Reserve(context.Background(), "msg", time.Minute)
+			assert.Equal(t, tc.want, err)
+			assert.Equal(t, err == nil, token != "")
+		})
+	}
+}
+
+func TestRedisStore_CommitAndRelease(t *testing.T) {
+	testCases := []struct {
+		name string
+		mock func(cmd *mocks.MockCmdable)
+		// 依次调用Commit和Release
+		wantCommit  error
+		wantRelease error
+	}{
+		{
+			name: "hold",
+			mock: func(cmd *mocks.MockCmdable) {
+				cmd.EXPECT().Eval(gomock.Any(), commitScript, []string{DefaultKeyPrefix + "msg"},
+					statusProcessing+":token", statusDone, int64(time.Hour/time.Millisecond)).
+					Return(redis.NewCmdResult(int64(1), nil))
+				cmd.EXPECT().Eval(gomock.Any(), releaseScript, []string{DefaultKeyPrefix + "msg"},
+					statusProcessing+":token").
+					Return(redis.NewCmdResult(int64(1), nil))
+			},
+		},
+		{
+			// 处理状态过期之后被新的消费者占用，比对token失败，不会覆盖或者删除它
+			name: "expired holder",
+			mock: func(cmd *mocks.MockCmdable) {
+				cmd.EXPECT().Eval(gomock.Any(), commitScript, []string{DefaultKeyPrefix + "msg"},
+					statusProcessing+":token", statusDone, int64(time.Hour/time.Millisecond)).
+					Return(redis.NewCmdResult(int64(0), nil))
+				cmd.EXPECT().Eval(gomock.Any(), releaseScript, []string{DefaultKeyPrefix + "msg"},
+					statusProcessing+":token").
+					Return(redis.NewCmdResult(int64(0), nil))
+			},
+			wantCommit:  ErrReservationLost,
+			wantRelease: ErrReservationLost,
+		},
+		{
+			name: "eval error",
+			mock: func(cmd *mocks.MockCmdable) {
+				cmd.EXPECT().Eval(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					Return(redis.NewCmdResult(nil, context.DeadlineExceeded)).Times(2)
+			},
+			wantCommit:  context.DeadlineExceeded,
+			wantRelease: context.DeadlineExceeded,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			cmd := mocks.NewMockCmdable(ctrl)
+			tc.mock(cmd)
+			s := NewRedisStore(cmd)
+			assert.Equal(t, tc.wantCommit, s.Commit(context.Background(), "msg", "token", time.Hour))
+			assert.Equal(t, tc.wantRelease, s.Release(context.Background(), "msg", "token"))
+		})
+	}
+}
+
+// TestRedisStore_ExpiredHolder 处理状态过期之后被新的消费者占用，两次占用的token不同，
+// 原来的消费者提交时比对token失败
+func TestRedisStore_ExpiredHolder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cmd := mocks.NewMockCmdable(ctrl)
+	// 模拟Redis中的key，SETNX只有在key不存在时成功，脚本比对值之后再写入
+	var current string
+	cmd.EXPECT().SetNX(gomock.Any(), "order:msg", gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, key string, val any, expiration time.Duration) *redis.BoolCmd {
+			if current != "" {
+				return redis.NewBoolResult(false, nil)
+			}
+			current = val.(string)
+			return redis.NewBoolResult(true, nil)
+		}).Times(2)
+	cmd.EXPECT().Eval(gomock.Any(), commitScript, []string{"order:msg"}, gomock.Any()).
+		DoAndReturn(func(ctx context.Context, script string, keys []string, args ...any) *redis.Cmd {
+			if current != args[0] {
+				return redis.NewCmdResult(int64(0), nil)
+			}
+			current = args[1].(string)
+			return redis.NewCmdResult(int64(1), nil)
+		}).Times(2)
+
+	s := NewRedisStore(cmd, RedisStoreWithPrefix("order:"))
+	ctx := context.Background()
+	expired, err := s.Reserve(ctx, "msg", time.Millisecond)
+	require.NoError(t, err)
+	// 处理状态过期
+	current = ""
+	holder, err := s.Reserve(ctx, "msg", time.Minute)
+	require.NoError(t, err)
+	assert.NotEqual(t, expired, holder)
+
+	assert.Equal(t, ErrReservationLost, s.Commit(ctx, "msg", expired, time.Hour))
+	assert.Equal(t, statusProcessing+":"+holder, current)
+	assert.NoError(t, s.Commit(ctx, "msg", holder, time.Hour))
+	assert.Equal(t, statusDone, current)
+}
@@ -0,0 +1,42 @@
+package queue
+
+import "context"
+
+// MessageBroker 消息队列的通用接口，Broker和redis_stream.Broker都实现了它，
+// 业务代码依赖它就可以在进程内的队列和基于Redis的队列之间切换
+type MessageBroker interface {
+	// Send 发送消息
+	Send(msg Message) error
+	// Publish 发送消息并返回投递报告
+	Publish(ctx context.Context, msg Message) (*DeliveryReport, error)
+	// Subscribe 订阅topic，通过返回的句柄接收消息和取消订阅
+	Subscribe(topic, queueName string, opts ...SubscribeOption) (*Subscription, error)
+	// ErrQueue 获取topic下的错误消息队列
+	ErrQueue(topic string) (<-chan ErrMessage, bool)
+	// Close 关闭topic下所有的队列
+	Close(topic string)
+	// Shutdown 优雅地关闭，等已经投递的消息处理完之后关闭所有的管道
+	Shutdown(ctx context.Context) error
+}
+
+var _ MessageBroker = (*Broker)(nil)
+
+// SubscribeSettings 解析之后的订阅配置，其他的MessageBroker实现通过ResolveSubscribeOptions读取
+type SubscribeSettings struct {
+	// Capacity 接收消息的管道的容量
+	Capacity int
+	// FromEarliest 是否从最早的消息开始消费，对应SubscribeFromEarliest
+	FromEarliest bool
+	// MaxAttempts 最大投递次数，小于等于0表示不限制
+	MaxAttempts int
+}
+
+// ResolveSubscribeOptions 解析订阅的配置项，没有设置的使用默认值
+func ResolveSubscribeOptions(opts ...SubscribeOption) SubscribeSettings {
+	cfg := newSubscribeConfig(opts)
+	return SubscribeSettings{
+		Capacity:     cfg.capacity,
+		FromEarliest: cfg.start == startEarliest,
+		MaxAttempts:  cfg.maxAttempts,
+	}
+}
@@ -1,8 +1,13 @@
 package queue
 
 import (
+	"context"
 	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
 	"sync"
+	"time"
 )
 
 const (
@@ -14,44 +19,136 @@ type Broker struct {
 	// key是topic，val是map，存储了所有订阅了该消息的broker，内部map的key是队列的名称，
 	// val对应的是channel，订阅的队列
 	// 如果不关心topic，可以使用切片，每次消息过来发送到所有的broker上即可
-	brokerChain map[string]map[string]chan Message
+	brokerChain map[string]map[string]*subscription
 	// 加锁保护
 	mu sync.RWMutex
 	// 返回发送错误消息的队列，每一个topic对应队列，这个队列是错误队列，用于返回发送失败的消息及错误的内容
 	// 容量是默认容量
 	topicErrQueue map[string]chan ErrMessage
+	// 每个topic下的消费组，key是topic，val的key是消费组的名称
+	groups map[string]map[string]*consumerGroup
+	// brokerChain中包含通配符的key，发送消息时只需要检查它们，不用遍历所有的topic
+	patterns map[string]struct{}
+
+	// 持久化的目录，为空表示不开启持久化
+	dir    string
+	logCfg logConfig
+	// 每个topic的消息日志，开启持久化或者保留之后发送的消息会先写入日志
+	logs map[string]messageLog
+	// 内存中每个topic保留的消息数和时长，消息数为0表示不保留
+	retentionCount int
+	retentionAge   time.Duration
+	// 保存还没有到投递时间的延时消息
+	scheduler *scheduler
+	// NewBroker中恢复持久化数据失败的错误，Publish和Subscribe会直接返回它
+	initErr error
+	// 调用Shutdown之后为true，不再接受新的消息和订阅
+	closing bool
 }
 
-func NewBroker() *Broker {
-	return &Broker{
-		brokerChain:   map[string]map[string]chan Message{},
-		topicErrQueue: map[string]chan ErrMessage{},
+type BrokerOption func(*Broker)
+
+// BrokerWithPersistence 开启持久化，每个topic在dir下有一个独立的目录保存追加写的消息日志，
+// 重启之后可以通过SubscribeFromEarliest、SubscribeFromOffset等选项重新消费历史消息。
+// 没有投递的延时消息也会保存下来，重启时恢复的延时消息需要在订阅完成之后调用Broker.Start才会开始投递
+func BrokerWithPersistence(dir string, opts ...LogOption) BrokerOption {
+	return func(b *Broker) {
+		b.dir = dir
+		b.logCfg = newLogConfig(opts)
 	}
 }
 
-// Send 发送消息
-func (b *Broker) Send(msg Message) error {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-	chains, ok := b.brokerChain[msg.Topic]
-	if !ok {
-		return errors.New("topic不存在")
+func NewBroker(opts ...BrokerOption) *Broker {
+	b := &Broker{
+		brokerChain:   map[string]map[string]*subscription{},
+		topicErrQueue: map[string]chan ErrMessage{},
+		groups:        map[string]map[string]*consumerGroup{},
+		patterns:      map[string]struct{}{},
+		logs:          map[string]messageLog{},
+		logCfg:        newLogConfig(nil),
 	}
 
-	for ne, ch := range chains {
-		name := ne
-		select {
-		case ch <- msg:
-		default:
-			msg.Queue = name
-			b.topicErrQueue[msg.Topic] <- ErrMessage{
-				Message: msg,
-				Err:     errors.New("消息队列已满"),
-			}
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	b.scheduler = newScheduler(b.publishScheduled, b.logCfg)
+	if b.dir != "" {
+		if b.initErr = os.MkdirAll(b.dir, 0o755); b.initErr == nil {
+			b.initErr = b.scheduler.load(b.dir)
 		}
 	}
+	return b
+}
 
-	return nil
+// subscription 一个订阅了topic的队列
+type subscription struct {
+	ch chan Message
+	// 消费者接收消息的管道，开启确认并且有缓冲时是relay转发的无缓冲管道，否则就是ch
+	out chan Message
+	// 是否在接收实时消息，从日志中追赶历史消息的时候为false，追上之后才会接收Send发送的消息
+	live bool
+	// 只接收Headers中包含这些键值对的消息
+	headers map[string]string
+	// 开启确认时记录还没有确认的消息，为nil表示不需要确认
+	acks *ackTracker
+	// 队列已满时的处理策略
+	overflow OverflowPolicy
+	// OverflowSpill策略的溢出缓冲区
+	spillLimit  int
+	spillMu     sync.Mutex
+	spill       []Message
+	spillNotify chan struct{}
+	// 取消订阅时关闭，通知后台的goroutine退出
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// tryDeliver 不阻塞地投递消息，队列已满时返回false
+func (s *subscription) tryDeliver(msg Message) bool {
+	if s.acks != nil {
+		msg = s.acks.track(msg)
+	}
+	select {
+	case s.ch <- msg:
+		return true
+	default:
+		if s.acks != nil {
+			s.acks.untrack(msg)
+		}
+		return false
+	}
+}
+
+// pending 已经投递但是还没有处理完的消息数
+func (s *subscription) pending() int {
+	s.spillMu.Lock()
+	spilled := len(s.spill)
+	s.spillMu.Unlock()
+	if s.acks != nil {
+		return s.acks.pending() + spilled
+	}
+	return len(s.ch) + spilled
+}
+
+// deliver 阻塞地投递消息，取消订阅时返回false
+func (s *subscription) deliver(msg Message) bool {
+	if s.acks != nil {
+		msg = s.acks.track(msg)
+	}
+	select {
+	case s.ch <- msg:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// Send 发送消息，开启持久化或者保留时消息会先写入topic的日志，即使topic暂时没有订阅者也不会丢失。
+// 队列已满时按照订阅者的OverflowPolicy处理，需要知道每个订阅者的投递结果时使用Publish
+func (b *Broker) Send(msg Message) error {
+	_, err := b.Publish(context.Background(), msg)
+	return err
 }
 
 //// SendG 并发推送消息
@@ -84,29 +181,36 @@ func (b *Broker) Send(msg Message) error {
 //	return nil
 //}
 
-// SendToSpecifyQueue 发送消息到指定的队列
+// SendToSpecifyQueue 发送消息到指定的队列，队列已满时按照订阅者的OverflowPolicy处理，Shutdown之后返回ErrBrokerClosed
 func (b *Broker) SendToSpecifyQueue(msg Message) error {
 	b.mu.Lock()
-	defer b.mu.Unlock()
-	queue, ok := b.brokerChain[msg.Topic][msg.Queue]
+	if b.closing {
+		b.mu.Unlock()
+		return ErrBrokerClosed
+	}
+	sub, ok := b.brokerChain[msg.Topic][msg.Queue]
 	if !ok {
+		b.mu.Unlock()
 		return errors.New("队列不存在")
 	}
 
-	select {
-	case queue <- msg:
-	default:
-		b.topicErrQueue[msg.Topic] <- ErrMessage{
-			Message: msg,
-			Err:     errors.New("消息队列已满"),
-		}
+	t := target{queue: msg.Queue, sub: sub}
+	_, block := b.offer(t, msg)
+	if block {
+		sub.wg.Add(1)
 	}
+	b.mu.Unlock()
 
+	if block {
+		sub.deliverCtx(context.Background(), t, msg)
+	}
 	return nil
 }
 
 // ErrQueue 获取topic下的错误消息队列
 func (b *Broker) ErrQueue(topic string) (<-chan ErrMessage, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
 	queue, ok := b.topicErrQueue[topic]
 	if !ok {
 		return nil, false
@@ -116,62 +220,308 @@ func (b *Broker) ErrQueue(topic string) (<-chan ErrMessage, bool) {
 
 // Queue 获取topic下的消息队列
 func (b *Broker) Queue(topic, queue string) (<-chan Message, bool) {
-	qu, ok := b.brokerChain[topic][queue]
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	sub, ok := b.brokerChain[topic][queue]
 	if !ok {
 		return nil, false
 	}
-	return qu, true
+	return sub.out, true
+}
+
+// SubscribeOption 订阅的配置项
+type SubscribeOption func(*subscribeConfig)
+
+type subscribeConfig struct {
+	capacity int
+	// 开始消费的位置
+	start  startPosition
+	offset uint64
+	// SubscribeFromTime的开始时间
+	since time.Time
+	// SubscribeFromLast的消息数
+	last uint64
+	// 确认超时时间，0表示不需要确认
+	visibilityTimeout time.Duration
+	maxAttempts       int
+	// 消费组的负载均衡策略
+	balancer Balancer
+	// 队列已满时的处理策略
+	overflow   OverflowPolicy
+	spillLimit int
+	// 按照消息头过滤消息
+	headers map[string]string
+}
+
+type startPosition int
+
+const (
+	startLatest startPosition = iota
+	startEarliest
+	startOffset
+	startTime
+	startLast
+)
+
+// SubscribeWithCapacity 设置接收消息的管道的容量，默认为DefaultCapacity
+func SubscribeWithCapacity(capacity int) SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		cfg.capacity = capacity
+	}
+}
+
+// SubscribeFromLatest 只接收订阅之后发送的消息，默认值
+func SubscribeFromLatest() SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		cfg.start = startLatest
+	}
+}
+
+// SubscribeFromEarliest 从日志中最早的消息开始消费，需要开启持久化或者保留
+func SubscribeFromEarliest() SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		cfg.start = startEarliest
+	}
+}
+
+// SubscribeFromOffset 从指定偏移量的消息开始消费，需要开启持久化或者保留，
+// 偏移量不在日志的范围内时返回ErrOffsetOutOfRange
+func SubscribeFromOffset(offset uint64) SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		cfg.start = startOffset
+		cfg.offset = offset
+	}
+}
+
+// SubscribeFromTime 从发送时间不早于t的消息开始消费，需要开启持久化或者保留
+func SubscribeFromTime(t time.Time) SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		cfg.start = startTime
+		cfg.since = t
+	}
+}
+
+// SubscribeFromLast 从日志中最后的n条消息开始消费，不足n条时从最早的消息开始，需要开启持久化或者保留
+func SubscribeFromLast(n int) SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		cfg.start = startLast
+		cfg.last = uint64(max(n, 0))
+	}
 }
 
 // Subscribe 订阅一个消息管道，用于接受消息
-// @param topic 是订阅消息的主题
-// @param capacity 接受消息的管道的容量，容量是可选的，如果传有容量，则使用用户传的，如果没有传则使用默认的
-// @return <-chan Message 一个只读的channel
+// @param topic 是订阅消息的主题，可以使用通配符订阅多个topic，例如orders.*.created、orders.#，
+// 通配符订阅只接收订阅之后发送的消息
+// @param opts 订阅的配置项，例如管道的容量、开始消费的位置
+// @return *Subscription 订阅的句柄，通过它接收消息和取消订阅
 // @return error 错误
-func (b *Broker) Subscribe(topic, queueName string, capacity ...int) error {
-	var cap int
-	if len(capacity) == 0 {
-		cap = DefaultCapacity
-	} else {
-		cap = capacity[0]
+func (b *Broker) Subscribe(topic, queueName string, opts ...SubscribeOption) (*Subscription, error) {
+	cfg := newSubscribeConfig(opts)
+	sub := newSubscription(cfg)
+
+	if b.initErr != nil {
+		return nil, b.initErr
+	}
+	if IsTopicPattern(topic) {
+		if err := validatePattern(topic); err != nil {
+			return nil, err
+		}
+		if cfg.start != startLatest {
+			return nil, errors.New("通配符订阅不支持指定开始消费的位置")
+		}
 	}
-	ch := make(chan Message, cap)
-	errCh := make(chan ErrMessage, DefaultCapacity)
 
 	b.mu.Lock()
 	defer b.mu.Unlock()
+	if b.closing {
+		return nil, ErrBrokerClosed
+	}
+	if _, ok := b.brokerChain[topic][queueName]; ok {
+		return nil, errors.New("队列已存在，请勿重复订阅")
+	}
+
+	if cfg.start != startLatest {
+		log, err := b.topicLog(topic)
+		if err != nil {
+			return nil, err
+		}
+		earliest, next := log.offsets()
+		from := earliest
+		switch cfg.start {
+		case startOffset:
+			if cfg.offset < earliest || cfg.offset > next {
+				return nil, ErrOffsetOutOfRange
+			}
+			from = cfg.offset
+		case startLast:
+			from = next - min(cfg.last, next-earliest)
+		}
+		if from < next {
+			sub.live = false
+			sub.wg.Add(1)
+			go b.catchUp(topic, sub, log, from, cfg.since)
+		}
+	}
+
+	b.startSubscription(topic, sub)
+	b.ensureTopic(topic)[queueName] = sub
+	return b.newHandle(topic, "", queueName, sub), nil
+}
+
+func newSubscribeConfig(opts []SubscribeOption) subscribeConfig {
+	cfg := subscribeConfig{
+		capacity:    DefaultCapacity,
+		maxAttempts: DefaultMaxAttempts,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+func newSubscription(cfg subscribeConfig) *subscription {
+	sub := &subscription{
+		ch:          make(chan Message, cfg.capacity),
+		live:        true,
+		headers:     cfg.headers,
+		overflow:    cfg.overflow,
+		spillLimit:  cfg.spillLimit,
+		spillNotify: make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+	sub.out = sub.ch
+	if cfg.visibilityTimeout > 0 {
+		sub.acks = newAckTracker(cfg.visibilityTimeout, cfg.maxAttempts)
+		// 有缓冲时消息可能在队列中等待很久，消费者取走之后才开始计算超时时间
+		if cfg.capacity > 0 {
+			sub.acks.startOnReceive = true
+			sub.out = make(chan Message)
+		}
+	}
+	return sub
+}
+
+// startSubscription 启动订阅的后台任务：重新投递超时没有确认的消息、投递溢出缓冲区中的消息
+func (b *Broker) startSubscription(topic string, sub *subscription) {
+	if sub.acks != nil {
+		sub.wg.Add(1)
+		go b.redeliver(topic, sub)
+	}
+	if sub.out != sub.ch {
+		go sub.relay()
+	}
+	if sub.overflow == OverflowSpill {
+		sub.wg.Add(1)
+		go sub.pumpSpill()
+	}
+}
+
+// ensureTopic 返回topic的订阅表，topic不存在时同时创建它的错误队列，调用方需要持有b.mu
+func (b *Broker) ensureTopic(topic string) map[string]*subscription {
 	chain, ok := b.brokerChain[topic]
 	if !ok {
-		b.brokerChain[topic] = map[string]chan Message{
-			queueName: ch,
+		chain = map[string]*subscription{}
+		b.brokerChain[topic] = chain
+		b.topicErrQueue[topic] = make(chan ErrMessage, DefaultCapacity)
+		if IsTopicPattern(topic) {
+			b.patterns[topic] = struct{}{}
+		}
+	}
+	return chain
+}
+
+// catchUp 把日志中from之后的历史消息投递给sub，跳过发送时间早于since的消息，
+// 追上最新的消息之后开始接收实时消息
+func (b *Broker) catchUp(topic string, sub *subscription, log messageLog, from uint64, since time.Time) {
+	defer sub.wg.Done()
+	for {
+		next, err := log.read(from, func(msg Message) bool {
+			if msg.Timestamp.Before(since) || !matchHeaders(sub.headers, msg) {
+				return true
+			}
+			return sub.deliver(msg)
+		})
+		select {
+		case <-sub.done:
+			return
+		default:
+		}
+
+		b.mu.Lock()
+		if err != nil {
+			select {
+			case b.topicErrQueue[topic] <- ErrMessage{Message: Message{Topic: topic, Offset: next}, Err: err}:
+			default:
+			}
+			b.mu.Unlock()
+			return
 		}
-		b.topicErrQueue[topic] = errCh
-	} else {
-		_, ok := chain[queueName]
-		if ok {
-			return errors.New("队列已存在，请勿重复订阅")
+		// 持有锁的时候不会有新消息写入，读到了日志末尾就可以切换成接收实时消息
+		if _, end := log.offsets(); next >= end {
+			sub.live = true
+			b.mu.Unlock()
+			return
 		}
-		chain[queueName] = ch
+		b.mu.Unlock()
+		from = next
 	}
+}
 
-	return nil
+// topicLog 返回topic的消息日志，不存在时打开，没有开启持久化和保留时返回ErrPersistenceNotSet，
+// 调用方需要持有b.mu
+func (b *Broker) topicLog(topic string) (messageLog, error) {
+	if log, ok := b.logs[topic]; ok {
+		return log, nil
+	}
+	var log messageLog
+	switch {
+	case b.dir != "":
+		segLog, err := openLog(filepath.Join(b.dir, url.PathEscape(topic)), b.logCfg)
+		if err != nil {
+			return nil, err
+		}
+		log = segLog
+	case b.retentionCount > 0:
+		log = newRingLog(b.retentionCount, b.retentionAge, b.logCfg.now)
+	default:
+		return nil, ErrPersistenceNotSet
+	}
+	b.logs[topic] = log
+	return log, nil
 }
 
-// Close 关闭订阅消息的队列
+// Close 关闭订阅消息的队列，开启持久化时同时关闭topic的日志，内存中保留的消息会被丢弃
 func (b *Broker) Close(topic string) {
 	b.mu.Lock()
-	defer b.mu.Unlock()
 	chain, ok := b.brokerChain[topic]
 	if !ok {
+		b.mu.Unlock()
 		return
 	}
-	// 避免了重复关闭问题
-	b.brokerChain[topic] = nil
-	// 关闭错误消息的队列
-	close(b.topicErrQueue[topic])
+	// 删除之后重复关闭会直接返回，之后也可以重新订阅这个topic
+	delete(b.brokerChain, topic)
+	delete(b.patterns, topic)
+	errCh := b.topicErrQueue[topic]
+	delete(b.topicErrQueue, topic)
+	groups := b.groups[topic]
+	delete(b.groups, topic)
+	log := b.logs[topic]
+	delete(b.logs, topic)
+	b.mu.Unlock()
 
-	for _, ch := range chain {
-		close(ch)
+	// 追赶历史消息的goroutine需要获取锁，所以在锁外面等待它们退出
+	for _, sub := range chain {
+		sub.stop()
+	}
+	for _, g := range groups {
+		for _, sub := range g.members {
+			sub.stop()
+		}
+	}
+	// 关闭错误消息的队列
+	close(errCh)
+	if log != nil {
+		_ = log.close()
 	}
 }
 
@@ -181,31 +531,83 @@ func (b *Broker) Close(topic string) {
 // @param queue 队列的名称
 func (b *Broker) ClosesQueue(topic, queue string) {
 	b.mu.Lock()
-	defer b.mu.Unlock()
-	chain, ok := b.brokerChain[topic]
-	if !ok {
-		return
+	sub, errCh := b.removeQueue(topic, queue, nil)
+	b.mu.Unlock()
+	stopSubscription(sub, errCh)
+}
+
+// removeQueue 把队列从topic中删除，expect不为nil时只有队列还是expect的时候才删除，
+// 返回被删除的订阅和需要关闭的错误队列，调用方需要持有b.mu
+func (b *Broker) removeQueue(topic, queue string, expect *subscription) (*subscription, chan ErrMessage) {
+	sub, ok := b.brokerChain[topic][queue]
+	if !ok || (expect != nil && sub != expect) {
+		return nil, nil
 	}
-	// 先拿到channel
-	ch, ok := chain[queue]
-	if !ok {
+	delete(b.brokerChain[topic], queue)
+	return sub, b.unusedErrQueue(topic)
+}
+
+// stopSubscription 在锁外面停止订阅并关闭错误队列，sub为nil时什么都不做
+func stopSubscription(sub *subscription, errCh chan ErrMessage) {
+	if sub == nil {
 		return
 	}
-	delete(chain, queue)
-	close(ch)
+	sub.stop()
+	if errCh != nil {
+		close(errCh)
+	}
+}
 
-	if len(b.brokerChain[topic]) == 0 {
-		close(b.topicErrQueue[topic])
+// unusedErrQueue topic下已经没有队列和消费组的时候删除topic，返回需要关闭的错误队列，
+// 调用方需要持有b.mu
+func (b *Broker) unusedErrQueue(topic string) chan ErrMessage {
+	if len(b.brokerChain[topic]) > 0 || len(b.groups[topic]) > 0 {
+		return nil
 	}
+	errCh := b.topicErrQueue[topic]
+	delete(b.brokerChain, topic)
+	delete(b.patterns, topic)
+	delete(b.topicErrQueue, topic)
+	return errCh
+}
+
+// stop 停止追赶历史消息和重新投递并关闭channel，调用之前需要把sub从brokerChain中删除
+func (s *subscription) stop() {
+	close(s.done)
+	s.wg.Wait()
+	close(s.ch)
 }
 
 type Message struct {
+	// 消息的唯一标识，为空时Send会自动生成
+	ID string
 	// 订阅的主题
 	Topic string
 	// 订阅的队列
 	Queue string
+	// 消息的分区键，使用KeyHashBalancer的消费组会把Key相同的消息投递给同一个消费者
+	Key string
+	// 消息在topic日志中的偏移量，只有开启持久化或者保留时才有意义
+	Offset uint64
 	// 消息的内容
 	Content any
+	// 消息头，订阅者可以通过SubscribeWithHeaders按照消息头过滤消息
+	Headers map[string]string
+	// 发送的时间，为空时Publish会自动设置
+	Timestamp time.Time
+	// 投递时间，晚于当前时间的消息会暂存起来，到期之后才投递给订阅者
+	DeliverAt time.Time
+	// 延迟投递的时间，Publish时会换算成DeliverAt
+	Delay time.Duration
+	// 第几次投递，开启确认之后超时或者Nack的消息重新投递时会加一
+	Attempt int
+	// 回复的地址，Request发送的请求会自动设置，Reply通过它找到请求方的临时队列
+	ReplyTo string
+	// 关联请求和回复的标识，为空时Request会自动生成，Reply会把它复制到回复中
+	CorrelationID string
+
+	// 开启确认时用于Ack和Nack
+	acker Acknowledger
 }
 
 type ErrMessage struct {
@@ -16,19 +16,19 @@ const (
 func TestQueue(t *testing.T) {
 	qu := NewBroker()
 	topic := "1234"
-	err := qu.Subscribe(topic, FirstCustomer)
+	_, err := qu.Subscribe(topic, FirstCustomer)
 	if err != nil {
 		t.Log("订阅错误", err)
 		return
 	}
 
-	err = qu.Subscribe(topic, SecondCustomer)
+	_, err = qu.Subscribe(topic, SecondCustomer)
 	if err != nil {
 		t.Log("订阅错误", err)
 		return
 	}
 
-	err = qu.Subscribe(topic, ThirdCustomer)
+	_, err = qu.Subscribe(topic, ThirdCustomer)
 	if err != nil {
 		t.Log("订阅错误", err)
 		return
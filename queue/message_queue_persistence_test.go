@@ -0,0 +1,98 @@
+package queue
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// receive 从队列中读取n条消息的内容
+func receive(t *testing.T, b *Broker, topic, queue string, n int) []any {
+	ch, ok := b.Queue(topic, queue)
+	require.True(t, ok)
+	res := make([]any, 0, n)
+	for i := 0; i < n; i++ {
+		select {
+		case msg := <-ch:
+			res = append(res, msg.Content)
+		case <-time.After(time.Second):
+			t.Fatalf("%s没有收到第%d条消息", queue, i)
+		}
+	}
+	return res
+}
+
+func sendN(t *testing.T, b *Broker, topic string, from, n int) {
+	for i := from; i < from+n; i++ {
+		require.NoError(t, b.Send(Message{Topic: topic, Content: fmt.Sprintf("msg-%d", i)}))
+	}
+}
+
+func TestBroker_Persistence(t *testing.T) {
+	dir := t.TempDir()
+	topic := "order"
+	b := NewBroker(BrokerWithPersistence(dir, LogWithSyncPolicy(SyncAlways)))
+	// 开启持久化之后没有订阅者的消息也会保存下来
+	sendN(t, b, topic, 0, 3)
+
+	require.NoError(t, subscribeErr(b.Subscribe(topic, "latest")))
+	require.NoError(t, subscribeErr(b.Subscribe(topic, "earliest", SubscribeFromEarliest())))
+	require.NoError(t, subscribeErr(b.Subscribe(topic, "offset", SubscribeFromOffset(2))))
+	assert.Equal(t, ErrOffsetOutOfRange, subscribeErr(b.Subscribe(topic, "out of range", SubscribeFromOffset(10))))
+
+	sendN(t, b, topic, 3, 2)
+	assert.Equal(t, []any{"msg-3", "msg-4"}, receive(t, b, topic, "latest", 2))
+	assert.Equal(t, []any{"msg-0", "msg-1", "msg-2", "msg-3", "msg-4"}, receive(t, b, topic, "earliest", 5))
+	assert.Equal(t, []any{"msg-2", "msg-3", "msg-4"}, receive(t, b, topic, "offset", 3))
+	b.Close(topic)
+
+	// 重启之后可以重新消费历史消息，偏移量接着之前的继续
+	b = NewBroker(BrokerWithPersistence(dir))
+	defer b.Close(topic)
+	require.NoError(t, subscribeErr(b.Subscribe(topic, "restart", SubscribeFromOffset(4))))
+	sendN(t, b, topic, 5, 1)
+	ch, _ := b.Queue(topic, "restart")
+	for i := 4; i < 6; i++ {
+		msg := <-ch
+		assert.Equal(t, fmt.Sprintf("msg-%d", i), msg.Content)
+		assert.Equal(t, uint64(i), msg.Offset)
+	}
+}
+
+func TestBroker_PersistenceCatchUp(t *testing.T) {
+	topic := "order"
+	b := NewBroker(BrokerWithPersistence(t.TempDir(), LogWithSyncPolicy(SyncNever)))
+	defer b.Close(topic)
+	sendN(t, b, topic, 0, 50)
+
+	// 容量比历史消息少，追赶的过程中继续发送消息，不能丢失也不能重复
+	require.NoError(t, subscribeErr(b.Subscribe(topic, "earliest", SubscribeFromEarliest(), SubscribeWithCapacity(10))))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 50; i < 100; i++ {
+			require.NoError(t, b.Send(Message{Topic: topic, Content: fmt.Sprintf("msg-%d", i)}))
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	ch, _ := b.Queue(topic, "earliest")
+	for i := 0; i < 100; i++ {
+		select {
+		case msg := <-ch:
+			assert.Equal(t, uint64(i), msg.Offset)
+		case <-time.After(time.Second):
+			t.Fatalf("没有收到第%d条消息", i)
+		}
+	}
+	<-done
+}
+
+func TestBroker_SubscribeWithoutPersistence(t *testing.T) {
+	b := NewBroker()
+	assert.Equal(t, ErrPersistenceNotSet, subscribeErr(b.Subscribe("order", "earliest", SubscribeFromEarliest())))
+	assert.EqualError(t, b.Send(Message{Topic: "order"}), "topic不存在")
+}
@@ -0,0 +1,36 @@
+package queue
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveSubscribeOptions(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts []SubscribeOption
+		want SubscribeSettings
+	}{
+		{
+			name: "default",
+			want: SubscribeSettings{Capacity: DefaultCapacity, MaxAttempts: DefaultMaxAttempts},
+		},
+		{
+			name: "options",
+			opts: []SubscribeOption{SubscribeWithCapacity(1), SubscribeFromEarliest(), SubscribeWithMaxAttempts(0)},
+			want: SubscribeSettings{Capacity: 1, FromEarliest: true},
+		},
+		{
+			name: "from offset",
+			opts: []SubscribeOption{SubscribeFromOffset(3)},
+			want: SubscribeSettings{Capacity: DefaultCapacity, MaxAttempts: DefaultMaxAttempts},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, ResolveSubscribeOptions(tc.opts...))
+		})
+	}
+}
@@ -0,0 +1,532 @@
+package redis_stream
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/liquanhui-99/gotool/queue"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// DefaultBlock XREADGROUP阻塞等待新消息的时间，也是关闭订阅时最长的等待时间
+	DefaultBlock = time.Second
+	// DefaultClaimIdle 消息超过这个时间没有确认就会被其他消费者认领，通常是因为原来的消费者宕机了
+	DefaultClaimIdle = 30 * time.Second
+	// DefaultBatch 每次读取的最大消息数
+	DefaultBatch = 16
+
+	// payloadField 消息在stream中保存的字段名
+	payloadField = "payload"
+	// busyGroupErr 消费组已经存在时XGROUP CREATE返回的错误前缀
+	busyGroupErr = "BUSYGROUP"
+)
+
+var (
+	ErrQueueExists       = errors.New("队列已存在，请勿重复订阅")
+	ErrInvalidEntry      = errors.New("stream中的消息格式错误")
+	ErrDelayNotSupported = errors.New("不支持延时消息")
+)
+
+// Broker 基于Redis Streams的消息队列，和queue.Broker一样实现了queue.MessageBroker，多个进程可以共享同一个topic。
+// 每个topic对应一个stream，每个队列对应stream上的一个消费组，同一个队列名称的多个副本竞争消费，
+// 不同的队列都会收到全部的消息。消息需要调用Message.Ack确认，没有确认的消息超过claimIdle之后
+// 会被同一个队列的其他副本通过XCLAIM认领并重新投递
+type Broker struct {
+	client    redis.Cmdable
+	codec     queue.Codec
+	consumer  string
+	block     time.Duration
+	claimIdle time.Duration
+	batch     int64
+	maxLen    int64
+
+	mu            sync.Mutex
+	subs          map[string]map[string]*subscription
+	topicErrQueue map[string]chan queue.ErrMessage
+	// Shutdown之后不再接受新的订阅
+	closing bool
+}
+
+var _ queue.MessageBroker = (*Broker)(nil)
+
+type BrokerOption func(b *Broker)
+
+// BrokerWithConsumer 设置消费者的名称，同一个队列的不同副本需要使用不同的名称，默认是随机生成的
+func BrokerWithConsumer(consumer string) BrokerOption {
+	return func(b *Broker) {
+		b.consumer = consumer
+	}
+}
+
+// BrokerWithCodec 设置消息的编解码器，默认为queue.JSONCodec
+func BrokerWithCodec(codec queue.Codec) BrokerOption {
+	return func(b *Broker) {
+		b.codec = codec
+	}
+}
+
+// BrokerWithBlock 设置XREADGROUP阻塞等待的时间，默认为DefaultBlock
+func BrokerWithBlock(block time.Duration) BrokerOption {
+	return func(b *Broker) {
+		b.block = block
+	}
+}
+
+// BrokerWithClaimIdle 设置认领其他消费者的消息的空闲时间，默认为DefaultClaimIdle
+func BrokerWithClaimIdle(idle time.Duration) BrokerOption {
+	return func(b *Broker) {
+		b.claimIdle = idle
+	}
+}
+
+// BrokerWithBatch 设置每次读取的最大消息数，默认为DefaultBatch
+func BrokerWithBatch(batch int64) BrokerOption {
+	return func(b *Broker) {
+		b.batch = batch
+	}
+}
+
+// BrokerWithMaxLen 设置stream的最大长度，超过之后XADD会近似地删除最旧的消息，默认不限制
+func BrokerWithMaxLen(maxLen int64) BrokerOption {
+	return func(b *Broker) {
+		b.maxLen = maxLen
+	}
+}
+
+func NewBroker(client redis.Cmdable, opts ...BrokerOption) *Broker {
+	b := &Broker{
+		client:        client,
+		codec:         queue.JSONCodec{},
+		consumer:      uuid.New().String(),
+		block:         DefaultBlock,
+		claimIdle:     DefaultClaimIdle,
+		batch:         DefaultBatch,
+		subs:          map[string]map[string]*subscription{},
+		topicErrQueue: map[string]chan queue.ErrMessage{},
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Send 把消息追加到topic对应的stream，需要知道消息ID时使用Publish
+func (b *Broker) Send(msg queue.Message) error {
+	_, err := b.Publish(context.Background(), msg)
+	return err
+}
+
+// Publish 把消息追加到topic对应的stream，ID为空时自动生成。消息由Redis保存，
+// 返回的报告中没有每个订阅者的投递结果。不支持延时消息，设置了Delay或者DeliverAt时返回ErrDelayNotSupported。
+// Shutdown之后返回queue.ErrBrokerClosed
+func (b *Broker) Publish(ctx context.Context, msg queue.Message) (*queue.DeliveryReport, error) {
+	b.mu.Lock()
+	closing := b.closing
+	b.mu.Unlock()
+	if closing {
+		return nil, queue.ErrBrokerClosed
+	}
+	if queue.IsTopicPattern(msg.Topic) {
+		return nil, queue.ErrPublishToPattern
+	}
+	if msg.Delay > 0 || !msg.DeliverAt.IsZero() {
+		return nil, ErrDelayNotSupported
+	}
+	if msg.ID == "" {
+		msg.ID = uuid.New().String()
+	}
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+	if err := b.add(ctx, msg.Topic, msg); err != nil {
+		return nil, err
+	}
+	return &queue.DeliveryReport{ID: msg.ID}, nil
+}
+
+func (b *Broker) add(ctx context.Context, stream string, msg queue.Message) error {
+	data, err := b.codec.Encode(msg)
+	if err != nil {
+		return err
+	}
+	return b.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: stream,
+		MaxLen: b.maxLen,
+		Approx: b.maxLen > 0,
+		Values: map[string]any{payloadField: data},
+	}).Err()
+}
+
+type subscribeConfig struct {
+	capacity    int
+	start       string
+	maxAttempts int
+}
+
+// Subscribe 订阅topic，queueName对应stream上的消费组，不存在时创建。
+// 通过返回的句柄接收消息和取消订阅，处理完成之后需要调用Message.Ack。
+// 支持queue.SubscribeWithCapacity、queue.SubscribeFromEarliest和queue.SubscribeWithMaxAttempts，
+// SubscribeFromEarliest只在第一次创建消费组时生效，之后从上次消费的位置继续，其他的配置项会被忽略。
+// 需要控制创建消费组的超时时间时使用SubscribeContext
+func (b *Broker) Subscribe(topic, queueName string, opts ...queue.SubscribeOption) (*queue.Subscription, error) {
+	return b.SubscribeContext(context.Background(), topic, queueName, opts...)
+}
+
+// SubscribeContext 和Subscribe一样，ctx用于创建消费组，不影响之后的消费
+func (b *Broker) SubscribeContext(ctx context.Context, topic, queueName string,
+	opts ...queue.SubscribeOption) (*queue.Subscription, error) {
+	settings := queue.ResolveSubscribeOptions(opts...)
+	cfg := subscribeConfig{
+		capacity:    settings.Capacity,
+		start:       "$",
+		maxAttempts: settings.MaxAttempts,
+	}
+	if settings.FromEarliest {
+		cfg.start = "0"
+	}
+
+	if err := b.checkSubscribe(topic, queueName); err != nil {
+		return nil, err
+	}
+	// 创建消费组需要访问Redis，不能持有锁，之后重新检查一遍
+	err := b.client.XGroupCreateMkStream(ctx, topic, queueName, cfg.start).Err()
+	if err != nil && !strings.HasPrefix(err.Error(), busyGroupErr) {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.checkSubscribeLocked(topic, queueName); err != nil {
+		return nil, err
+	}
+	sub := newSubscription(topic, queueName, cfg)
+	chain, ok := b.subs[topic]
+	if !ok {
+		chain = map[string]*subscription{}
+		b.subs[topic] = chain
+		b.topicErrQueue[topic] = make(chan queue.ErrMessage, queue.DefaultCapacity)
+	}
+	chain[queueName] = sub
+	go b.consume(sub)
+	go sub.relay()
+	return queue.NewSubscription(sub.out, b.topicErrQueue[topic], &closer{broker: b, sub: sub}), nil
+}
+
+// checkSubscribe 检查是否可以订阅
+func (b *Broker) checkSubscribe(topic, queueName string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.checkSubscribeLocked(topic, queueName)
+}
+
+func (b *Broker) checkSubscribeLocked(topic, queueName string) error {
+	if b.closing {
+		return queue.ErrBrokerClosed
+	}
+	if _, ok := b.subs[topic][queueName]; ok {
+		return ErrQueueExists
+	}
+	return nil
+}
+
+// Queue 获取topic下的消息队列
+func (b *Broker) Queue(topic, queueName string) (<-chan queue.Message, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub, ok := b.subs[topic][queueName]
+	if !ok {
+		return nil, false
+	}
+	return sub.out, true
+}
+
+// ErrQueue 获取topic下的错误消息队列，读取或者确认消息失败时会放进这里
+func (b *Broker) ErrQueue(topic string) (<-chan queue.ErrMessage, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	errCh, ok := b.topicErrQueue[topic]
+	return errCh, ok
+}
+
+// ClosesQueue 停止消费并关闭队列的管道，Redis中的消费组会保留，没有确认的消息之后会被其他副本认领。
+// 需要等待正在进行的XREADGROUP返回，最多阻塞block的时间
+func (b *Broker) ClosesQueue(topic, queueName string) {
+	b.mu.Lock()
+	sub, ok := b.subs[topic][queueName]
+	b.mu.Unlock()
+	if ok {
+		(&closer{broker: b, sub: sub}).Unsubscribe()
+	}
+}
+
+// remove 把订阅从Broker中删除，topic下没有订阅时返回需要关闭的错误队列，已经删除时返回false
+func (b *Broker) remove(sub *subscription) (chan queue.ErrMessage, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[sub.topic][sub.group] != sub {
+		return nil, false
+	}
+	delete(b.subs[sub.topic], sub.group)
+	if len(b.subs[sub.topic]) > 0 {
+		return nil, true
+	}
+	errCh := b.topicErrQueue[sub.topic]
+	delete(b.subs, sub.topic)
+	delete(b.topicErrQueue, sub.topic)
+	return errCh, true
+}
+
+// Close 关闭topic下所有的队列
+func (b *Broker) Close(topic string) {
+	b.mu.Lock()
+	names := make([]string, 0, len(b.subs[topic]))
+	for name := range b.subs[topic] {
+		names = append(names, name)
+	}
+	b.mu.Unlock()
+
+	for _, name := range names {
+		b.ClosesQueue(topic, name)
+	}
+}
+
+// consume 读取消息并投递到sub的管道，直到取消订阅
+func (b *Broker) consume(sub *subscription) {
+	ctx := sub.readCtx
+	defer close(sub.ch)
+	var lastClaim time.Time
+	for ctx.Err() == nil {
+		if time.Since(lastClaim) >= b.claimIdle {
+			lastClaim = time.Now()
+			if !b.claim(ctx, sub) {
+				return
+			}
+		}
+		if !b.redeliverNacked(ctx, sub) {
+			return
+		}
+
+		streams, err := b.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    sub.group,
+			Consumer: b.consumer,
+			Streams:  []string{sub.topic, ">"},
+			Count:    b.batch,
+			Block:    b.block,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			if !b.backoff(ctx, sub, err) {
+				return
+			}
+			continue
+		}
+		for _, stream := range streams {
+			for _, entry := range stream.Messages {
+				if !b.deliver(ctx, sub, entry, 1) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// claim 通过XAUTOCLAIM认领超过claimIdle没有确认的消息，包括自己之前没有确认的。
+// 还在本地缓冲中的消息已经在等待消费者取走，认领之后跳过，不会重复投递
+func (b *Broker) claim(ctx context.Context, sub *subscription) bool {
+	start := "0-0"
+	for {
+		entries, next, err := b.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   sub.topic,
+			Group:    sub.group,
+			Consumer: b.consumer,
+			MinIdle:  b.claimIdle,
+			Start:    start,
+			Count:    b.batch,
+		}).Result()
+		if err != nil {
+			return b.backoff(ctx, sub, err)
+		}
+
+		claimed := make([]redis.XMessage, 0, len(entries))
+		sub.mu.Lock()
+		for _, entry := range entries {
+			if _, ok := sub.buffered[entry.ID]; !ok {
+				claimed = append(claimed, entry)
+			}
+		}
+		sub.mu.Unlock()
+		if !b.redeliver(ctx, sub, claimed) {
+			return false
+		}
+		if next == "0-0" || len(entries) == 0 {
+			return true
+		}
+		start = next
+	}
+}
+
+// redeliverNacked 通过XCLAIM把Nack的消息重新认领给自己，这样会增加消息的投递次数
+func (b *Broker) redeliverNacked(ctx context.Context, sub *subscription) bool {
+	sub.mu.Lock()
+	ids := sub.nacked
+	sub.nacked = nil
+	sub.mu.Unlock()
+	if len(ids) == 0 {
+		return true
+	}
+
+	entries, err := b.client.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   sub.topic,
+		Group:    sub.group,
+		Consumer: b.consumer,
+		Messages: ids,
+	}).Result()
+	if err != nil {
+		return b.backoff(ctx, sub, err)
+	}
+	return b.redeliver(ctx, sub, entries)
+}
+
+// redeliver 逐条查询重新认领的消息的投递次数之后重新投递。按照ID范围查询时，
+// 范围内其他消费者的消息也会占用Count，导致部分消息查不到投递次数
+func (b *Broker) redeliver(ctx context.Context, sub *subscription, entries []redis.XMessage) bool {
+	for _, entry := range entries {
+		pending, err := b.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream:   sub.topic,
+			Group:    sub.group,
+			Start:    entry.ID,
+			End:      entry.ID,
+			Count:    1,
+			Consumer: b.consumer,
+		}).Result()
+		if err != nil {
+			return b.backoff(ctx, sub, err)
+		}
+		// 不在自己的待确认列表中，说明已经被确认或者被其他副本认领了
+		if len(pending) == 0 {
+			continue
+		}
+		if !b.deliver(ctx, sub, entry, int(pending[0].RetryCount)) {
+			return false
+		}
+	}
+	return true
+}
+
+// deliver 解码消息并投递到sub的管道，超过最大投递次数的消息发送到死信队列，取消订阅时返回false
+func (b *Broker) deliver(ctx context.Context, sub *subscription, entry redis.XMessage, attempt int) bool {
+	msg, err := b.decode(entry)
+	if err != nil {
+		// 无法解码的消息重试也没有用，确认之后通过错误队列通知
+		b.reportErr(sub, queue.ErrMessage{Message: queue.Message{Topic: sub.topic, Queue: sub.group, ID: entry.ID}, Err: err})
+		_ = b.client.XAck(ctx, sub.topic, sub.group, entry.ID).Err()
+		return true
+	}
+	msg.Queue = sub.group
+	msg.Attempt = max(attempt, 1)
+	if sub.cfg.maxAttempts > 0 && msg.Attempt > sub.cfg.maxAttempts {
+		b.deadLetter(ctx, sub, entry.ID, msg)
+		return true
+	}
+
+	msg = msg.WithAcknowledger(&acker{broker: b, sub: sub, id: entry.ID})
+	sub.mu.Lock()
+	sub.buffered[entry.ID] = struct{}{}
+	sub.unacked[entry.ID] = struct{}{}
+	sub.mu.Unlock()
+	select {
+	case sub.ch <- delivery{id: entry.ID, msg: msg}:
+		return true
+	case <-ctx.Done():
+		sub.mu.Lock()
+		delete(sub.buffered, entry.ID)
+		delete(sub.unacked, entry.ID)
+		sub.mu.Unlock()
+		return false
+	}
+}
+
+func (b *Broker) decode(entry redis.XMessage) (queue.Message, error) {
+	data, ok := entry.Values[payloadField].(string)
+	if !ok {
+		return queue.Message{}, ErrInvalidEntry
+	}
+	return b.codec.Decode([]byte(data))
+}
+
+// deadLetter 把消息发送到死信队列之后确认原来的消息
+func (b *Broker) deadLetter(ctx context.Context, sub *subscription, id string, msg queue.Message) {
+	msg.Attempt = 0
+	err := b.add(ctx, queue.DeadLetterTopic(sub.topic), msg)
+	if err == nil {
+		err = b.client.XAck(ctx, sub.topic, sub.group, id).Err()
+	}
+	if err != nil {
+		b.reportErr(sub, queue.ErrMessage{Message: msg, Err: err})
+	}
+}
+
+// backoff 通过错误队列通知Redis的错误，等待一段时间之后重试，取消订阅时返回false
+func (b *Broker) backoff(ctx context.Context, sub *subscription, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	b.reportErr(sub, queue.ErrMessage{Message: queue.Message{Topic: sub.topic, Queue: sub.group}, Err: err})
+	timer := time.NewTimer(b.block)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// reportErr 不阻塞地放进错误队列，错误队列已满时丢弃
+func (b *Broker) reportErr(sub *subscription, errMsg queue.ErrMessage) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	select {
+	case b.topicErrQueue[sub.topic] <- errMsg:
+	default:
+	}
+}
+
+// acker 确认一条从stream中读取的消息
+type acker struct {
+	broker *Broker
+	sub    *subscription
+	// stream中的消息ID
+	id string
+}
+
+var _ queue.Acknowledger = (*acker)(nil)
+
+// Ack 通过XACK确认消息，消息已经确认过时返回queue.ErrMessageNotInFlight
+func (a *acker) Ack(msg queue.Message) error {
+	a.sub.settle(a.id)
+	cnt, err := a.broker.client.XAck(context.Background(), a.sub.topic, a.sub.group, a.id).Result()
+	if err != nil {
+		return err
+	}
+	if cnt != 1 {
+		return queue.ErrMessageNotInFlight
+	}
+	return nil
+}
+
+// Nack 在下一次读取之前重新投递消息，正在阻塞读取时最多延迟block的时间
+func (a *acker) Nack(msg queue.Message) error {
+	a.sub.mu.Lock()
+	defer a.sub.mu.Unlock()
+	delete(a.sub.unacked, a.id)
+	a.sub.nacked = append(a.sub.nacked, a.id)
+	return nil
+}
@@ -0,0 +1,149 @@
+//go:build e2e
+
+package redis_stream
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/liquanhui-99/gotool/queue"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func receiveE2E(t *testing.T, ch <-chan queue.Message) queue.Message {
+	select {
+	case msg, ok := <-ch:
+		require.True(t, ok)
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("没有收到消息")
+		return queue.Message{}
+	}
+}
+
+func TestBroker_SendAndAck_e2e(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     "122.9.137.145:6319",
+		Password: "123456",
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	topic := "e2e-order"
+	require.NoError(t, client.Del(ctx, topic, queue.DeadLetterTopic(topic)).Err())
+	defer client.Del(ctx, topic, queue.DeadLetterTopic(topic))
+
+	b := NewBroker(client, BrokerWithBlock(100*time.Millisecond))
+	defer b.Close(topic)
+	subs := map[string]*queue.Subscription{}
+	for _, name := range []string{"a", "b"} {
+		sub, err := b.Subscribe(topic, name)
+		require.NoError(t, err)
+		subs[name] = sub
+	}
+	for i := 0; i < 3; i++ {
+		require.NoError(t, b.Send(queue.Message{Topic: topic, Content: fmt.Sprintf("msg-%d", i)}))
+	}
+
+	// 不同的队列都会收到全部的消息
+	for _, sub := range subs {
+		ch := sub.C()
+		for i := 0; i < 3; i++ {
+			msg := receiveE2E(t, ch)
+			assert.Equal(t, fmt.Sprintf("msg-%d", i), msg.Content)
+			assert.Equal(t, 1, msg.Attempt)
+			require.NoError(t, msg.Ack())
+		}
+	}
+	pending, err := client.XPending(ctx, topic, "a").Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), pending.Count)
+}
+
+func TestBroker_Replicas_e2e(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     "122.9.137.145:6319",
+		Password: "123456",
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	topic := "e2e-replicas"
+	require.NoError(t, client.Del(ctx, topic, queue.DeadLetterTopic(topic)).Err())
+	defer client.Del(ctx, topic, queue.DeadLetterTopic(topic))
+
+	// 同一个队列的两个副本竞争消费
+	replicas := []*Broker{
+		NewBroker(client, BrokerWithConsumer("r1"), BrokerWithBlock(100*time.Millisecond), BrokerWithBatch(1)),
+		NewBroker(client, BrokerWithConsumer("r2"), BrokerWithBlock(100*time.Millisecond), BrokerWithBatch(1)),
+	}
+	subs := make([]*queue.Subscription, 0, len(replicas))
+	for _, r := range replicas {
+		sub, err := r.Subscribe(topic, "consumer", queue.SubscribeWithCapacity(0))
+		require.NoError(t, err)
+		defer sub.Unsubscribe()
+		subs = append(subs, sub)
+	}
+	const n = 10
+	for i := 0; i < n; i++ {
+		require.NoError(t, replicas[0].Send(queue.Message{Topic: topic, Content: fmt.Sprintf("msg-%d", i)}))
+	}
+
+	seen := map[any]string{}
+	for len(seen) < n {
+		for i, r := range replicas {
+			select {
+			case msg := <-subs[i].C():
+				_, ok := seen[msg.Content]
+				assert.False(t, ok, "消息被重复投递: %v", msg.Content)
+				seen[msg.Content] = r.consumer
+				require.NoError(t, msg.Ack())
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+		require.NoError(t, ctx.Err())
+	}
+}
+
+func TestBroker_Claim_e2e(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     "122.9.137.145:6319",
+		Password: "123456",
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	topic := "e2e-claim"
+	require.NoError(t, client.Del(ctx, topic, queue.DeadLetterTopic(topic)).Err())
+	defer client.Del(ctx, topic, queue.DeadLetterTopic(topic))
+
+	// 第一个副本收到消息之后没有确认就退出了
+	crashed := NewBroker(client, BrokerWithConsumer("crashed"), BrokerWithBlock(100*time.Millisecond))
+	sub, err := crashed.Subscribe(topic, "consumer")
+	require.NoError(t, err)
+	require.NoError(t, crashed.Send(queue.Message{Topic: topic, Content: "msg"}))
+	assert.Equal(t, "msg", receiveE2E(t, sub.C()).Content)
+	sub.Unsubscribe()
+
+	// 超过claimIdle之后被另一个副本认领
+	b := NewBroker(client, BrokerWithConsumer("alive"), BrokerWithBlock(100*time.Millisecond),
+		BrokerWithClaimIdle(200*time.Millisecond))
+	defer b.Close(topic)
+	sub, err = b.Subscribe(topic, "consumer", queue.SubscribeWithMaxAttempts(3))
+	require.NoError(t, err)
+	ch := sub.C()
+	msg := receiveE2E(t, ch)
+	assert.Equal(t, "msg", msg.Content)
+	assert.Equal(t, 2, msg.Attempt)
+
+	// Nack之后重新投递，超过最大投递次数之后发送到死信队列
+	require.NoError(t, msg.Nack())
+	msg = receiveE2E(t, ch)
+	assert.Equal(t, 3, msg.Attempt)
+	require.NoError(t, msg.Nack())
+	require.Eventually(t, func() bool {
+		n, err := client.XLen(ctx, queue.DeadLetterTopic(topic)).Result()
+		return err == nil && n == 1
+	}, 3*time.Second, 50*time.Millisecond)
+}
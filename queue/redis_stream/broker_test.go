@@ -0,0 +1,412 @@
+package redis_stream
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/liquanhui-99/gotool/cache/redis_cache/mocks"
+	"github.com/liquanhui-99/gotool/queue"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBroker_Publish(t *testing.T) {
+	msg := queue.Message{ID: "id-1", Topic: "order", Content: "msg", Timestamp: time.UnixMilli(1000)}
+	data, err := queue.JSONCodec{}.Encode(msg)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name    string
+		opts    []BrokerOption
+		msg     func(msg queue.Message) queue.Message
+		mock    func(ctrl *gomock.Controller) redis.Cmdable
+		wantErr error
+	}{
+		{
+			name: "send",
+			mock: func(ctrl *gomock.Controller) redis.Cmdable {
+				cmd := mocks.NewMockCmdable(ctrl)
+				res := redis.NewStringCmd(context.Background())
+				res.SetVal("1-0")
+				cmd.EXPECT().XAdd(gomock.Any(), &redis.XAddArgs{
+					Stream: "order",
+					Values: map[string]any{payloadField: data},
+				}).Return(res)
+				return cmd
+			},
+		},
+		{
+			name: "max len",
+			opts: []BrokerOption{BrokerWithMaxLen(100)},
+			mock: func(ctrl *gomock.Controller) redis.Cmdable {
+				cmd := mocks.NewMockCmdable(ctrl)
+				res := redis.NewStringCmd(context.Background())
+				res.SetVal("1-0")
+				cmd.EXPECT().XAdd(gomock.Any(), &redis.XAddArgs{
+					Stream: "order",
+					MaxLen: 100,
+					Approx: true,
+					Values: map[string]any{payloadField: data},
+				}).Return(res)
+				return cmd
+			},
+		},
+		{
+			name: "redis error",
+			mock: func(ctrl *gomock.Controller) redis.Cmdable {
+				cmd := mocks.NewMockCmdable(ctrl)
+				res := redis.NewStringCmd(context.Background())
+				res.SetErr(errors.New("network error"))
+				cmd.EXPECT().XAdd(gomock.Any(), gomock.Any()).Return(res)
+				return cmd
+			},
+			wantErr: errors.New("network error"),
+		},
+		{
+			name: "delay",
+			msg: func(msg queue.Message) queue.Message {
+				msg.Delay = time.Second
+				return msg
+			},
+			mock: func(ctrl *gomock.Controller) redis.Cmdable {
+				return mocks.NewMockCmdable(ctrl)
+			},
+			wantErr: ErrDelayNotSupported,
+		},
+		{
+			name: "pattern",
+			msg: func(msg queue.Message) queue.Message {
+				msg.Topic = "order.*"
+				return msg
+			},
+			mock: func(ctrl *gomock.Controller) redis.Cmdable {
+				return mocks.NewMockCmdable(ctrl)
+			},
+			wantErr: queue.ErrPublishToPattern,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			b := NewBroker(tc.mock(ctrl), tc.opts...)
+			m := msg
+			if tc.msg != nil {
+				m = tc.msg(m)
+			}
+			report, err := b.Publish(context.Background(), m)
+			assert.Equal(t, tc.wantErr, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, &queue.DeliveryReport{ID: "id-1"}, report)
+		})
+	}
+}
+
+func TestBroker_Subscribe(t *testing.T) {
+	testCases := []struct {
+		name    string
+		opts    []queue.SubscribeOption
+		mock    func(cmd *mocks.MockCmdable)
+		wantErr error
+	}{
+		{
+			name: "create group",
+			mock: func(cmd *mocks.MockCmdable) {
+				cmd.EXPECT().XGroupCreateMkStream(gomock.Any(), "order", "consumer", "$").
+					Return(redis.NewStatusResult("OK", nil))
+			},
+		},
+		{
+			name: "from earliest",
+			opts: []queue.SubscribeOption{queue.SubscribeFromEarliest()},
+			mock: func(cmd *mocks.MockCmdable) {
+				cmd.EXPECT().XGroupCreateMkStream(gomock.Any(), "order", "consumer", "0").
+					Return(redis.NewStatusResult("OK", nil))
+			},
+		},
+		{
+			name: "group exists",
+			mock: func(cmd *mocks.MockCmdable) {
+				cmd.EXPECT().XGroupCreateMkStream(gomock.Any(), "order", "consumer", "$").
+					Return(redis.NewStatusResult("", errors.New("BUSYGROUP Consumer Group name already exists")))
+			},
+		},
+		{
+			name: "redis error",
+			mock: func(cmd *mocks.MockCmdable) {
+				cmd.EXPECT().XGroupCreateMkStream(gomock.Any(), "order", "consumer", "$").
+					Return(redis.NewStatusResult("", errors.New("network error")))
+			},
+			wantErr: errors.New("network error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			cmd := mocks.NewMockCmdable(ctrl)
+			tc.mock(cmd)
+			expectIdle(cmd)
+			b := NewBroker(cmd)
+			defer b.Close("order")
+
+			_, err := b.Subscribe("order", "consumer", tc.opts...)
+			assert.Equal(t, tc.wantErr, err)
+			if err != nil {
+				return
+			}
+			_, err = b.Subscribe("order", "consumer")
+			assert.Equal(t, ErrQueueExists, err)
+		})
+	}
+}
+
+func TestBroker_SubscribeContext(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cmd := mocks.NewMockCmdable(ctrl)
+	b := NewBroker(cmd)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	// 创建消费组时使用调用方的ctx，并且不持有Broker的锁
+	cmd.EXPECT().XGroupCreateMkStream(ctx, "order", "consumer", "$").
+		DoAndReturn(func(ctx context.Context, stream, group, start string) *redis.StatusCmd {
+			_, ok := b.ErrQueue("order")
+			assert.False(t, ok)
+			return redis.NewStatusResult("", context.DeadlineExceeded)
+		})
+
+	_, err := b.SubscribeContext(ctx, "order", "consumer")
+	assert.Equal(t, context.DeadlineExceeded, err)
+	_, ok := b.Queue("order", "consumer")
+	assert.False(t, ok)
+}
+
+// expectIdle 没有新消息的时候XAUTOCLAIM返回空，XREADGROUP阻塞到取消订阅
+func expectIdle(cmd *mocks.MockCmdable) {
+	cmd.EXPECT().XAutoClaim(gomock.Any(), gomock.Any()).
+		Return(autoClaimResult(nil)).AnyTimes()
+	cmd.EXPECT().XReadGroup(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, a *redis.XReadGroupArgs) *redis.XStreamSliceCmd {
+			<-ctx.Done()
+			return redis.NewXStreamSliceCmdResult(nil, ctx.Err())
+		}).AnyTimes()
+}
+
+func autoClaimResult(msgs []redis.XMessage) *redis.XAutoClaimCmd {
+	res := redis.NewXAutoClaimCmd(context.Background())
+	res.SetVal(msgs, "0-0")
+	return res
+}
+
+func pendingResult(pending []redis.XPendingExt) *redis.XPendingExtCmd {
+	res := redis.NewXPendingExtCmd(context.Background())
+	res.SetVal(pending)
+	return res
+}
+
+// expectPending 逐条查询认领到的消息的投递次数
+func expectPending(cmd *mocks.MockCmdable, id string, cnt int64) {
+	cmd.EXPECT().XPendingExt(gomock.Any(), &redis.XPendingExtArgs{
+		Stream:   "order",
+		Group:    "consumer",
+		Start:    id,
+		End:      id,
+		Count:    1,
+		Consumer: "c1",
+	}).Return(pendingResult([]redis.XPendingExt{{ID: id, RetryCount: cnt}}))
+}
+
+func entry(t *testing.T, id string, msg queue.Message) redis.XMessage {
+	data, err := queue.JSONCodec{}.Encode(msg)
+	require.NoError(t, err)
+	return redis.XMessage{ID: id, Values: map[string]any{payloadField: string(data)}}
+}
+
+func TestBroker_Consume(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cmd := mocks.NewMockCmdable(ctrl)
+	cmd.EXPECT().XGroupCreateMkStream(gomock.Any(), "order", "consumer", "$").
+		Return(redis.NewStatusResult("OK", nil))
+	cmd.EXPECT().XAutoClaim(gomock.Any(), &redis.XAutoClaimArgs{
+		Stream:   "order",
+		Group:    "consumer",
+		Consumer: "c1",
+		MinIdle:  DefaultClaimIdle,
+		Start:    "0-0",
+		Count:    DefaultBatch,
+	}).Return(autoClaimResult(nil))
+	cmd.EXPECT().XReadGroup(gomock.Any(), &redis.XReadGroupArgs{
+		Group:    "consumer",
+		Consumer: "c1",
+		Streams:  []string{"order", ">"},
+		Count:    DefaultBatch,
+		Block:    DefaultBlock,
+	}).Return(redis.NewXStreamSliceCmdResult([]redis.XStream{{
+		Stream: "order",
+		Messages: []redis.XMessage{
+			{ID: "1-0", Values: map[string]any{"other": "invalid"}},
+			entry(t, "2-0", queue.Message{ID: "id-2", Topic: "order", Content: "msg"}),
+		},
+	}}, nil))
+	expectIdle(cmd)
+	// 无法解码的消息直接确认
+	cmd.EXPECT().XAck(gomock.Any(), "order", "consumer", "1-0").Return(redis.NewIntResult(1, nil))
+	cmd.EXPECT().XAck(gomock.Any(), "order", "consumer", "2-0").Return(redis.NewIntResult(1, nil))
+	cmd.EXPECT().XAck(gomock.Any(), "order", "consumer", "2-0").Return(redis.NewIntResult(0, nil))
+
+	b := NewBroker(cmd, BrokerWithConsumer("c1"))
+	sub, err := b.Subscribe("order", "consumer")
+	require.NoError(t, err)
+	ch, errCh := sub.C(), sub.Err()
+
+	msg := <-ch
+	assert.Equal(t, "id-2", msg.ID)
+	assert.Equal(t, "consumer", msg.Queue)
+	assert.Equal(t, "msg", msg.Content)
+	assert.Equal(t, 1, msg.Attempt)
+	assert.NoError(t, msg.Ack())
+	assert.Equal(t, queue.ErrMessageNotInFlight, msg.Ack())
+
+	errMsg := <-errCh
+	assert.Equal(t, ErrInvalidEntry, errMsg.Err)
+	assert.Equal(t, "1-0", errMsg.ID)
+
+	sub.Unsubscribe()
+	_, ok := <-ch
+	assert.False(t, ok)
+	_, ok = <-errCh
+	assert.False(t, ok)
+	_, ok = b.Queue("order", "consumer")
+	assert.False(t, ok)
+	// 重复取消订阅
+	sub.Unsubscribe()
+}
+
+func TestBroker_Redeliver(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cmd := mocks.NewMockCmdable(ctrl)
+	cmd.EXPECT().XGroupCreateMkStream(gomock.Any(), "order", "consumer", "$").
+		Return(redis.NewStatusResult("OK", nil))
+	// 认领到的消息按照XPENDING中的投递次数判断是否超过了最大投递次数，XAUTOCLAIM已经把投递次数加一
+	cmd.EXPECT().XAutoClaim(gomock.Any(), gomock.Any()).Return(autoClaimResult([]redis.XMessage{
+		entry(t, "1-0", queue.Message{ID: "id-1", Topic: "order", Content: "claimed"}),
+		entry(t, "2-0", queue.Message{ID: "id-2", Topic: "order", Content: "dead"}),
+	}))
+	expectPending(cmd, "1-0", 2)
+	expectPending(cmd, "2-0", 3)
+	// 2-0在认领时就超过了最大投递次数，1-0在Nack之后超过
+	dead := redis.NewStringCmd(context.Background())
+	dead.SetVal("1-0")
+	cmd.EXPECT().XAdd(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, a *redis.XAddArgs) *redis.StringCmd {
+		assert.Equal(t, queue.DeadLetterTopic("order"), a.Stream)
+		return dead
+	}).Times(2)
+	cmd.EXPECT().XAck(gomock.Any(), "order", "consumer", "2-0").Return(redis.NewIntResult(1, nil))
+	cmd.EXPECT().XAck(gomock.Any(), "order", "consumer", "1-0").Return(redis.NewIntResult(1, nil))
+	// Nack之后通过XCLAIM重新认领
+	cmd.EXPECT().XClaim(gomock.Any(), &redis.XClaimArgs{
+		Stream:   "order",
+		Group:    "consumer",
+		Consumer: "c1",
+		Messages: []string{"1-0"},
+	}).Return(redis.NewXMessageSliceCmdResult([]redis.XMessage{
+		entry(t, "1-0", queue.Message{ID: "id-1", Topic: "order", Content: "claimed"}),
+	}, nil))
+	expectPending(cmd, "1-0", 3)
+	cmd.EXPECT().XReadGroup(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, a *redis.XReadGroupArgs) *redis.XStreamSliceCmd {
+			// 模拟XREADGROUP超时，进入下一次循环处理Nack的消息
+			time.Sleep(10 * time.Millisecond)
+			return redis.NewXStreamSliceCmdResult(nil, redis.Nil)
+		}).Times(1)
+	expectIdle(cmd)
+
+	b := NewBroker(cmd, BrokerWithConsumer("c1"))
+	defer b.Close("order")
+	sub, err := b.Subscribe("order", "consumer", queue.SubscribeWithMaxAttempts(2))
+	require.NoError(t, err)
+	ch := sub.C()
+
+	msg := <-ch
+	assert.Equal(t, "claimed", msg.Content)
+	assert.Equal(t, 2, msg.Attempt)
+	require.NoError(t, msg.Nack())
+
+	// 第三次投递超过了最大投递次数，发送到死信队列
+	select {
+	case msg, ok := <-ch:
+		t.Fatalf("超过最大投递次数的消息不应该投递: %v %v", msg, ok)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestBroker_ClaimSkipsBuffered(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cmd := mocks.NewMockCmdable(ctrl)
+	cmd.EXPECT().XGroupCreateMkStream(gomock.Any(), "order", "consumer", "$").
+		Return(redis.NewStatusResult("OK", nil))
+	// 第一次认领的时候没有空闲的消息，第二次认领到的1-0和2-0还在本地缓冲中，只投递3-0
+	var claims atomic.Int32
+	cmd.EXPECT().XAutoClaim(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, a *redis.XAutoClaimArgs) *redis.XAutoClaimCmd {
+			if claims.Add(1) != 2 {
+				return autoClaimResult(nil)
+			}
+			return autoClaimResult([]redis.XMessage{
+				entry(t, "1-0", queue.Message{ID: "id-1", Topic: "order"}),
+				entry(t, "2-0", queue.Message{ID: "id-2", Topic: "order"}),
+				entry(t, "3-0", queue.Message{ID: "id-3", Topic: "order"}),
+			})
+		}).AnyTimes()
+	expectPending(cmd, "3-0", 2)
+	var reads atomic.Int32
+	cmd.EXPECT().XReadGroup(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, a *redis.XReadGroupArgs) *redis.XStreamSliceCmd {
+			if reads.Add(1) == 1 {
+				return redis.NewXStreamSliceCmdResult([]redis.XStream{{
+					Stream: "order",
+					Messages: []redis.XMessage{
+						entry(t, "1-0", queue.Message{ID: "id-1", Topic: "order"}),
+						entry(t, "2-0", queue.Message{ID: "id-2", Topic: "order"}),
+					},
+				}}, nil)
+			}
+			time.Sleep(5 * time.Millisecond)
+			return redis.NewXStreamSliceCmdResult(nil, redis.Nil)
+		}).AnyTimes()
+	cmd.EXPECT().XAck(gomock.Any(), "order", "consumer", gomock.Any()).Return(redis.NewIntResult(1, nil)).AnyTimes()
+
+	b := NewBroker(cmd, BrokerWithConsumer("c1"), BrokerWithClaimIdle(20*time.Millisecond))
+	defer b.Close("order")
+	sub, err := b.Subscribe("order", "consumer", queue.SubscribeWithCapacity(1))
+	require.NoError(t, err)
+	ch := sub.C()
+
+	// 等待认领完成之后再开始消费
+	time.Sleep(100 * time.Millisecond)
+	var ids []string
+	for i := 0; i < 3; i++ {
+		msg := <-ch
+		ids = append(ids, msg.ID)
+		require.NoError(t, msg.Ack())
+	}
+	assert.Equal(t, []string{"id-1", "id-2", "id-3"}, ids)
+	select {
+	case msg := <-ch:
+		t.Fatalf("缓冲中的消息不应该重复投递: %v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
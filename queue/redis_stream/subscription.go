@@ -0,0 +1,185 @@
+package redis_stream
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/liquanhui-99/gotool/queue"
+)
+
+// drainInterval Drain检查消息是否都确认了的间隔
+const drainInterval = 10 * time.Millisecond
+
+// subscription 一个订阅了topic的队列
+type subscription struct {
+	topic string
+	group string
+	cfg   subscribeConfig
+	// 读取到的消息先放进ch，再由relay转发到out
+	ch  chan delivery
+	out chan queue.Message
+
+	mu sync.Mutex
+	// Nack的消息，下一次读取的时候重新投递
+	nacked []string
+	// 已经读取但是还没有被消费者取走的消息ID，认领的时候跳过，避免重复投递
+	buffered map[string]struct{}
+	// 已经投递但是还没有Ack或者Nack的消息ID，Drain的时候等待它们
+	unacked map[string]struct{}
+
+	// readCtx 结束之后不再读取新的消息
+	readCtx    context.Context
+	cancelRead context.CancelFunc
+	// relayCtx 结束之后丢弃还没有被取走的消息
+	relayCtx    context.Context
+	cancelRelay context.CancelFunc
+	// relay退出之后关闭
+	done chan struct{}
+}
+
+// delivery 等待消费者取走的消息
+type delivery struct {
+	// stream中的消息ID
+	id  string
+	msg queue.Message
+}
+
+func newSubscription(topic, group string, cfg subscribeConfig) *subscription {
+	sub := &subscription{
+		topic:    topic,
+		group:    group,
+		cfg:      cfg,
+		ch:       make(chan delivery, cfg.capacity),
+		out:      make(chan queue.Message),
+		buffered: map[string]struct{}{},
+		unacked:  map[string]struct{}{},
+		done:     make(chan struct{}),
+	}
+	sub.readCtx, sub.cancelRead = context.WithCancel(context.Background())
+	sub.relayCtx, sub.cancelRelay = context.WithCancel(context.Background())
+	return sub
+}
+
+// relay 把ch中的消息转发给消费者，消费者取走之后才从buffered中删除。
+// 取消订阅之后丢弃还没有取走的消息，它们没有确认，之后会被重新认领
+func (s *subscription) relay() {
+	defer close(s.done)
+	defer close(s.out)
+	for d := range s.ch {
+		select {
+		case s.out <- d.msg:
+		case <-s.relayCtx.Done():
+		}
+		s.mu.Lock()
+		delete(s.buffered, d.id)
+		s.mu.Unlock()
+	}
+}
+
+// settle 消息已经确认，Drain不再等待它
+func (s *subscription) settle(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.unacked, id)
+}
+
+// stop 停止读取和转发消息，等待后台的goroutine退出
+func (s *subscription) stop() {
+	s.cancelRead()
+	s.cancelRelay()
+	<-s.done
+}
+
+// drain 停止读取新的消息，等待缓冲中的消息都被取走并且确认
+func (s *subscription) drain(ctx context.Context) error {
+	s.cancelRead()
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		n := len(s.unacked)
+		s.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// closer 取消Broker中的订阅
+type closer struct {
+	broker *Broker
+	sub    *subscription
+}
+
+var _ queue.SubscriptionCloser = (*closer)(nil)
+
+// Unsubscribe 立即取消订阅并关闭管道，缓冲中还没有取走的消息会被丢弃，之后被重新认领。
+// 需要等待正在进行的XREADGROUP返回，最多阻塞block的时间
+func (c *closer) Unsubscribe() {
+	errCh, ok := c.broker.remove(c.sub)
+	if !ok {
+		return
+	}
+	c.sub.stop()
+	if errCh != nil {
+		close(errCh)
+	}
+}
+
+// Drain 停止读取新的消息，等已经读取的消息都被取走并且Ack或者Nack之后再关闭管道。
+// ctx结束时依旧会取消订阅，返回ctx.Err()。Nack的消息不会再投递给这个副本
+func (c *closer) Drain(ctx context.Context) error {
+	errCh, ok := c.broker.remove(c.sub)
+	if !ok {
+		return nil
+	}
+	err := c.sub.drain(ctx)
+	c.sub.stop()
+	if errCh != nil {
+		close(errCh)
+	}
+	return err
+}
+
+// Shutdown 优雅地关闭Broker：不再接受新的订阅，等所有订阅中已经读取的消息都处理完之后关闭所有的管道。
+// ctx结束时不再等待，直接关闭，返回ctx.Err()
+func (b *Broker) Shutdown(ctx context.Context) error {
+	b.mu.Lock()
+	if b.closing {
+		b.mu.Unlock()
+		return queue.ErrBrokerClosed
+	}
+	b.closing = true
+	var closers []*closer
+	for _, chain := range b.subs {
+		for _, sub := range chain {
+			closers = append(closers, &closer{broker: b, sub: sub})
+		}
+	}
+	b.mu.Unlock()
+
+	errs := make([]error, len(closers))
+	var wg sync.WaitGroup
+	for i, c := range closers {
+		wg.Add(1)
+		go func(i int, c *closer) {
+			defer wg.Done()
+			errs[i] = c.Drain(ctx)
+		}(i, c)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,102 @@
+package redis_stream
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/liquanhui-99/gotool/cache/redis_cache/mocks"
+	"github.com/liquanhui-99/gotool/queue"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// expectBatch 第一次XREADGROUP返回msg-0和msg-1，之后阻塞到停止读取
+func expectBatch(t *testing.T, cmd *mocks.MockCmdable) {
+	cmd.EXPECT().XGroupCreateMkStream(gomock.Any(), "order", "consumer", "$").
+		Return(redis.NewStatusResult("OK", nil))
+	var reads atomic.Int32
+	cmd.EXPECT().XReadGroup(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, a *redis.XReadGroupArgs) *redis.XStreamSliceCmd {
+			if reads.Add(1) == 1 {
+				return redis.NewXStreamSliceCmdResult([]redis.XStream{{
+					Stream: "order",
+					Messages: []redis.XMessage{
+						entry(t, "1-0", queue.Message{ID: "msg-0", Topic: "order"}),
+						entry(t, "2-0", queue.Message{ID: "msg-1", Topic: "order"}),
+					},
+				}}, nil)
+			}
+			<-ctx.Done()
+			return redis.NewXStreamSliceCmdResult(nil, ctx.Err())
+		}).AnyTimes()
+	expectIdle(cmd)
+	cmd.EXPECT().XAck(gomock.Any(), "order", "consumer", gomock.Any()).Return(redis.NewIntResult(1, nil)).AnyTimes()
+}
+
+func TestSubscription_Drain(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cmd := mocks.NewMockCmdable(ctrl)
+	expectBatch(t, cmd)
+
+	b := NewBroker(cmd, BrokerWithConsumer("c1"))
+	sub, err := b.Subscribe("order", "consumer")
+	require.NoError(t, err)
+	first := <-sub.C()
+	assert.Equal(t, "msg-0", first.ID)
+
+	drained := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		drained <- sub.Drain(ctx)
+	}()
+
+	// 缓冲中的消息依旧可以取走，所有的消息都确认之后Drain才返回
+	second := <-sub.C()
+	assert.Equal(t, "msg-1", second.ID)
+	require.NoError(t, second.Ack())
+	select {
+	case err := <-drained:
+		t.Fatalf("还有消息没有确认，Drain不应该返回: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	require.NoError(t, first.Ack())
+	assert.NoError(t, <-drained)
+	_, ok := <-sub.C()
+	assert.False(t, ok)
+	_, ok = <-sub.Err()
+	assert.False(t, ok)
+	assert.NoError(t, sub.Drain(context.Background()))
+}
+
+func TestBroker_Shutdown(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cmd := mocks.NewMockCmdable(ctrl)
+	expectBatch(t, cmd)
+
+	b := NewBroker(cmd, BrokerWithConsumer("c1"))
+	sub, err := b.Subscribe("order", "consumer")
+	require.NoError(t, err)
+	assert.Equal(t, "msg-0", (<-sub.C()).ID)
+
+	// 消息一直没有确认，超时之后直接关闭
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, b.Shutdown(ctx), context.DeadlineExceeded)
+	for range sub.C() {
+	}
+
+	assert.Equal(t, queue.ErrBrokerClosed, b.Shutdown(context.Background()))
+	_, err = b.Subscribe("order", "other")
+	assert.Equal(t, queue.ErrBrokerClosed, err)
+	// 关闭之后不再接受新的消息
+	_, err = b.Publish(context.Background(), queue.Message{Topic: "order"})
+	assert.Equal(t, queue.ErrBrokerClosed, err)
+	assert.Equal(t, queue.ErrBrokerClosed, b.Send(queue.Message{Topic: "order"}))
+}
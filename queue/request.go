@@ -0,0 +1,173 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// replyTopic Request创建的临时回复队列所在的topic
+	replyTopic = systemTopicPrefix + "reply"
+	// HeaderReplyError Responder返回error时，回复中保存错误信息的消息头
+	HeaderReplyError = "reply-error"
+)
+
+var (
+	ErrNoReplyTo     = errors.New("消息没有回复地址")
+	ErrRequestGone   = errors.New("请求方已经不再等待回复")
+	ErrNoResponder   = errors.New("没有订阅者接收请求")
+	ErrRequestFailed = errors.New("请求处理失败")
+)
+
+// Request 发送一条请求并等待回复。CorrelationID为空时自动生成，同时创建一个临时的回复队列，
+// 收到回复或者ctx结束之后删除。请求没有进入任何订阅者的队列时立刻返回ErrNoResponder，
+// 开启持久化或者保留时也一样，不会等到之后订阅的Responder，
+// ctx结束时返回ctx.Err()，回复中带有HeaderReplyError时返回回复和ErrRequestFailed
+func (b *Broker) Request(ctx context.Context, msg Message) (Message, error) {
+	if msg.CorrelationID == "" {
+		msg.CorrelationID = uuid.New().String()
+	}
+	// 回复队列的名称和CorrelationID分开生成，调用方自己设置的CorrelationID可能会重复
+	msg.ReplyTo = uuid.New().String()
+	sub, err := b.Subscribe(replyTopic, msg.ReplyTo, SubscribeWithCapacity(1))
+	if err != nil {
+		return Message{}, err
+	}
+	defer sub.Unsubscribe()
+
+	report, err := b.Publish(ctx, msg)
+	if err != nil {
+		return Message{}, err
+	}
+	if !accepted(report) {
+		return Message{}, ErrNoResponder
+	}
+
+	for {
+		select {
+		case resp, ok := <-sub.C():
+			if !ok {
+				return Message{}, ErrBrokerClosed
+			}
+			if resp.CorrelationID != msg.CorrelationID {
+				continue
+			}
+			if reason, ok := resp.Headers[HeaderReplyError]; ok {
+				return resp, fmt.Errorf("%w: %s", ErrRequestFailed, reason)
+			}
+			return resp, nil
+		case <-ctx.Done():
+			return Message{}, ctx.Err()
+		}
+	}
+}
+
+// accepted 请求是否可能被处理：进入了至少一个订阅者的队列，或者是暂存起来等待之后投递的延时请求
+func accepted(report *DeliveryReport) bool {
+	if report.Scheduled {
+		return true
+	}
+	for _, res := range report.Results {
+		switch res.Status {
+		case DeliveryDelivered, DeliveryEvicted, DeliverySpilled:
+			return true
+		}
+	}
+	return false
+}
+
+// Reply 回复Request发送的请求，resp的Topic、Queue、CorrelationID会被覆盖。
+// req没有回复地址时返回ErrNoReplyTo，请求方已经超时离开时返回ErrRequestGone
+func (b *Broker) Reply(req, resp Message) error {
+	if req.ReplyTo == "" {
+		return ErrNoReplyTo
+	}
+	resp.Topic = replyTopic
+	resp.Queue = req.ReplyTo
+	resp.CorrelationID = req.CorrelationID
+	if resp.ID == "" {
+		resp.ID = uuid.New().String()
+	}
+	if resp.Timestamp.IsZero() {
+		resp.Timestamp = b.logCfg.now()
+	}
+	// 回复队列只有在请求方离开之后才会不存在
+	err := b.SendToSpecifyQueue(resp)
+	switch {
+	case err == nil, errors.Is(err, ErrBrokerClosed):
+		return err
+	default:
+		return ErrRequestGone
+	}
+}
+
+// Responder 处理一条请求并返回回复，返回error时回复中带有HeaderReplyError
+type Responder func(ctx context.Context, req Message) (Message, error)
+
+// Respond 订阅topic，在后台的goroutine中依次调用responder处理请求并回复，ctx结束之后取消订阅。
+// 没有回复地址的消息只处理不回复，请求方已经离开时丢弃回复
+func (b *Broker) Respond(ctx context.Context, topic, queueName string, responder Responder, opts ...SubscribeOption) (*Subscription, error) {
+	sub, err := b.Subscribe(topic, queueName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	go b.serve(ctx, sub, queueName, responder)
+	return sub, nil
+}
+
+// RespondGroup 以消费组的方式订阅topic并回复请求，同一个组内的Responder分担请求，其他行为和Respond一致
+func (b *Broker) RespondGroup(ctx context.Context, topic, group, consumer string, responder Responder, opts ...SubscribeOption) (*Subscription, error) {
+	sub, err := b.SubscribeGroup(topic, group, consumer, opts...)
+	if err != nil {
+		return nil, err
+	}
+	go b.serve(ctx, sub, consumer, responder)
+	return sub, nil
+}
+
+func (b *Broker) serve(ctx context.Context, sub *Subscription, queueName string, responder Responder) {
+	for {
+		select {
+		case req, ok := <-sub.C():
+			if !ok {
+				return
+			}
+			req.Queue = queueName
+			resp, err := callResponder(ctx, responder, req)
+			if err != nil {
+				resp = errorReply(resp, err)
+			}
+			if req.ReplyTo != "" {
+				_ = b.Reply(req, resp)
+			}
+			// 处理失败的结果也已经回复给请求方了，不需要重新投递
+			_ = req.Ack()
+		case <-ctx.Done():
+			sub.Unsubscribe()
+			return
+		}
+	}
+}
+
+func callResponder(ctx context.Context, responder Responder, req Message) (resp Message, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("处理请求时发生panic: %v", r)
+		}
+	}()
+	return responder(ctx, req)
+}
+
+// errorReply 在回复中加上错误信息，复制消息头，避免修改Responder持有的map
+func errorReply(resp Message, err error) Message {
+	headers := make(map[string]string, len(resp.Headers)+1)
+	for k, v := range resp.Headers {
+		headers[k] = v
+	}
+	headers[HeaderReplyError] = err.Error()
+	resp.Headers = headers
+	return resp
+}
@@ -0,0 +1,153 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echo 把请求的内容加上前缀之后回复
+func echo(prefix string) Responder {
+	return func(ctx context.Context, req Message) (Message, error) {
+		return Message{Content: fmt.Sprintf("%s%v", prefix, req.Content)}, nil
+	}
+}
+
+func TestBroker_Request(t *testing.T) {
+	testCases := []struct {
+		name      string
+		responder Responder
+		timeout   time.Duration
+
+		wantContent any
+		wantErr     error
+	}{
+		{
+			name:        "reply",
+			responder:   echo("re: "),
+			timeout:     time.Second,
+			wantContent: "re: ping",
+		},
+		{
+			name: "responder error",
+			responder: func(ctx context.Context, req Message) (Message, error) {
+				return Message{Content: "partial"}, errors.New("处理失败")
+			},
+			timeout:     time.Second,
+			wantContent: "partial",
+			wantErr:     ErrRequestFailed,
+		},
+		{
+			name: "responder panic",
+			responder: func(ctx context.Context, req Message) (Message, error) {
+				panic("boom")
+			},
+			timeout: time.Second,
+			wantErr: ErrRequestFailed,
+		},
+		{
+			name: "timeout",
+			responder: func(ctx context.Context, req Message) (Message, error) {
+				time.Sleep(50 * time.Millisecond)
+				return Message{}, nil
+			},
+			timeout: 10 * time.Millisecond,
+			wantErr: context.DeadlineExceeded,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := NewBroker()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			require.NoError(t, subscribeErr(b.Respond(ctx, "rpc", "server", tc.responder)))
+
+			reqCtx, reqCancel := context.WithTimeout(context.Background(), tc.timeout)
+			defer reqCancel()
+			resp, err := b.Request(reqCtx, Message{Topic: "rpc", Content: "ping", CorrelationID: "req-1"})
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.Equal(t, tc.wantContent, resp.Content)
+			if err == nil {
+				assert.Equal(t, "req-1", resp.CorrelationID)
+			}
+
+			// 请求结束之后临时的回复队列被删除
+			b.mu.RLock()
+			_, ok := b.brokerChain[replyTopic]
+			b.mu.RUnlock()
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestBroker_RequestNoResponder(t *testing.T) {
+	b := NewBroker()
+	_, err := b.Request(context.Background(), Message{Topic: "rpc"})
+	assert.EqualError(t, err, "topic不存在")
+
+	// 订阅者的队列满了，请求没有被接收
+	require.NoError(t, subscribeErr(b.Subscribe("rpc", "full", SubscribeWithCapacity(0))))
+	_, err = b.Request(context.Background(), Message{Topic: "rpc"})
+	assert.Equal(t, ErrNoResponder, err)
+
+	// 开启保留时没有订阅者的请求也会保存下来，但是不会等待之后订阅的Responder
+	b = NewBroker(BrokerWithRetention(10, 0))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = b.Request(ctx, Message{Topic: "rpc"})
+	assert.Equal(t, ErrNoResponder, err)
+	assert.NoError(t, ctx.Err())
+}
+
+func TestBroker_RequestConcurrent(t *testing.T) {
+	b := NewBroker()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// 消费组中的Responder分担请求，每个请求只会收到自己的回复
+	for _, c := range []string{"c1", "c2"} {
+		require.NoError(t, subscribeErr(b.RespondGroup(ctx, "rpc", "server", c, echo(c+": "))))
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			reqCtx, reqCancel := context.WithTimeout(context.Background(), time.Second)
+			defer reqCancel()
+			resp, err := b.Request(reqCtx, Message{Topic: "rpc", Content: i})
+			assert.NoError(t, err)
+			assert.Regexp(t, fmt.Sprintf("^c[12]: %d$", i), resp.Content)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestBroker_Reply(t *testing.T) {
+	b := NewBroker()
+	sub, err := b.Subscribe("rpc", "server")
+	require.NoError(t, err)
+	assert.Equal(t, ErrNoReplyTo, b.Reply(Message{Topic: "rpc"}, Message{}))
+
+	// 请求方超时离开之后回复被丢弃
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = b.Request(ctx, Message{Topic: "rpc"})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	req := receiveMsg(t, sub.C())
+	assert.NotEmpty(t, req.ReplyTo)
+	assert.NotEmpty(t, req.CorrelationID)
+	assert.Equal(t, ErrRequestGone, b.Reply(req, Message{}))
+
+	// Shutdown之后不再接受回复
+	require.NoError(t, b.Shutdown(context.Background()))
+	assert.Equal(t, ErrBrokerClosed, b.SendToSpecifyQueue(Message{Topic: "rpc", Queue: "server"}))
+	assert.Equal(t, ErrBrokerClosed, b.Reply(req, Message{}))
+}
@@ -0,0 +1,135 @@
+package queue
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRetentionCount = 1024 // 默认每个topic在内存中保留的消息数
+)
+
+// messageLog topic的消息日志，开启持久化时是磁盘上的segmentLog，只开启保留时是内存中的ringLog
+type messageLog interface {
+	append(msg Message) (uint64, error)
+	read(offset uint64, fn func(msg Message) bool) (uint64, error)
+	offsets() (uint64, uint64)
+	close() error
+}
+
+// BrokerWithRetention 在内存中为每个topic保留最近的count条消息，count小于等于0时使用DefaultRetentionCount，
+// age大于0时同时丢弃写入超过age的消息。开启之后即使topic暂时没有订阅者也可以发送消息，
+// 之后通过SubscribeFromEarliest、SubscribeFromOffset、SubscribeFromTime、SubscribeFromLast重放。
+// 同时开启持久化时使用磁盘上的日志，保留策略通过LogWithRetentionBytes、LogWithRetentionAge设置
+func BrokerWithRetention(count int, age time.Duration) BrokerOption {
+	return func(b *Broker) {
+		if count <= 0 {
+			count = DefaultRetentionCount
+		}
+		b.retentionCount = count
+		b.retentionAge = age
+	}
+}
+
+// retained 是否保留发送过的消息，调用方需要持有b.mu
+func (b *Broker) retained() bool {
+	return b.dir != "" || b.retentionCount > 0
+}
+
+type ringEntry struct {
+	msg Message
+	// 写入的时间，用于按时长清理
+	at time.Time
+}
+
+// ringLog 内存中容量固定的环形缓冲区，写满之后覆盖最早的消息，偏移量和segmentLog一样单调递增
+type ringLog struct {
+	mu      sync.Mutex
+	entries []ringEntry
+	// 最早的消息在entries中的下标
+	head int
+	size int
+	// 下一条消息的偏移量
+	next   uint64
+	maxAge time.Duration
+	now    func() time.Time
+}
+
+func newRingLog(count int, age time.Duration, now func() time.Time) *ringLog {
+	return &ringLog{
+		entries: make([]ringEntry, count),
+		maxAge:  age,
+		now:     now,
+	}
+}
+
+func (l *ringLog) append(msg Message) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.trimLocked()
+	if l.size == len(l.entries) {
+		l.popLocked()
+	}
+
+	msg.Offset = l.next
+	l.entries[(l.head+l.size)%len(l.entries)] = ringEntry{msg: msg, at: l.now()}
+	l.size++
+	l.next++
+	return msg.Offset, nil
+}
+
+// read 从offset开始按顺序读取调用时保留的消息，fn返回false时停止读取。
+// 返回下一条要读取的消息的偏移量；offset之前的消息被覆盖的时候从最早的消息开始读
+func (l *ringLog) read(offset uint64, fn func(msg Message) bool) (uint64, error) {
+	l.mu.Lock()
+	l.trimLocked()
+	earliest := l.next - uint64(l.size)
+	if offset < earliest {
+		offset = earliest
+	}
+	var msgs []Message
+	for off := offset; off < l.next; off++ {
+		idx := (l.head + int(off-earliest)) % len(l.entries)
+		msgs = append(msgs, l.entries[idx].msg)
+	}
+	end := l.next
+	l.mu.Unlock()
+
+	// 在锁外面回调，fn可能会阻塞
+	for _, msg := range msgs {
+		if !fn(msg) {
+			return msg.Offset, nil
+		}
+	}
+	return max(offset, end), nil
+}
+
+func (l *ringLog) offsets() (uint64, uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.trimLocked()
+	return l.next - uint64(l.size), l.next
+}
+
+func (l *ringLog) close() error {
+	return nil
+}
+
+// trimLocked 丢弃超过保留时长的消息，调用方需要持有l.mu
+func (l *ringLog) trimLocked() {
+	if l.maxAge <= 0 {
+		return
+	}
+	deadline := l.now().Add(-l.maxAge)
+	for l.size > 0 && l.entries[l.head].at.Before(deadline) {
+		l.popLocked()
+	}
+}
+
+// popLocked 丢弃最早的消息，调用方需要持有l.mu
+func (l *ringLog) popLocked() {
+	// 清空引用，让消息可以被回收
+	l.entries[l.head] = ringEntry{}
+	l.head = (l.head + 1) % len(l.entries)
+	l.size--
+}
@@ -0,0 +1,179 @@
+package queue
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRingLog(t *testing.T) {
+	testCases := []struct {
+		name  string
+		count int
+		age   time.Duration
+		// 每条消息写入之间经过的时间
+		interval time.Duration
+		from     uint64
+
+		wantEarliest uint64
+		wantContents []any
+		wantNext     uint64
+	}{
+		{
+			name:         "all",
+			count:        10,
+			wantContents: []any{"msg-0", "msg-1", "msg-2", "msg-3", "msg-4"},
+			wantNext:     5,
+		},
+		{
+			name:         "count",
+			count:        3,
+			wantEarliest: 2,
+			wantContents: []any{"msg-2", "msg-3", "msg-4"},
+			wantNext:     5,
+		},
+		{
+			name:         "age",
+			count:        10,
+			age:          25 * time.Second,
+			interval:     10 * time.Second,
+			wantEarliest: 3,
+			wantContents: []any{"msg-3", "msg-4"},
+			wantNext:     5,
+		},
+		{
+			name:         "from middle",
+			count:        10,
+			from:         3,
+			wantContents: []any{"msg-3", "msg-4"},
+			wantNext:     5,
+		},
+		{
+			name:         "from end",
+			count:        10,
+			from:         5,
+			wantContents: []any{},
+			wantNext:     5,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			now := time.Unix(1700000000, 0)
+			log := newRingLog(tc.count, tc.age, func() time.Time { return now })
+			for i := 0; i < 5; i++ {
+				off, err := log.append(Message{Content: fmt.Sprintf("msg-%d", i)})
+				require.NoError(t, err)
+				assert.Equal(t, uint64(i), off)
+				now = now.Add(tc.interval)
+			}
+
+			earliest, next := log.offsets()
+			assert.Equal(t, tc.wantEarliest, earliest)
+			assert.Equal(t, uint64(5), next)
+			contents := []any{}
+			next, err := log.read(tc.from, func(msg Message) bool {
+				contents = append(contents, msg.Content)
+				return true
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantContents, contents)
+			assert.Equal(t, tc.wantNext, next)
+		})
+	}
+}
+
+func TestRingLog_Stop(t *testing.T) {
+	log := newRingLog(10, 0, time.Now)
+	for i := 0; i < 5; i++ {
+		_, err := log.append(Message{Content: i})
+		require.NoError(t, err)
+	}
+	// fn返回false时返回停下的消息的偏移量，下次从它开始读
+	next, err := log.read(0, func(msg Message) bool {
+		return msg.Content.(int) < 2
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), next)
+}
+
+func TestBroker_Retention(t *testing.T) {
+	topic := "order"
+	b := NewBroker(BrokerWithRetention(4, 0))
+	defer b.Close(topic)
+	// 开启保留之后没有订阅者的消息也会保存下来，超过数量的消息被覆盖
+	sendN(t, b, topic, 0, 6)
+
+	require.NoError(t, subscribeErr(b.Subscribe(topic, "latest")))
+	require.NoError(t, subscribeErr(b.Subscribe(topic, "earliest", SubscribeFromEarliest())))
+	require.NoError(t, subscribeErr(b.Subscribe(topic, "offset", SubscribeFromOffset(4))))
+	require.NoError(t, subscribeErr(b.Subscribe(topic, "last", SubscribeFromLast(1))))
+	require.NoError(t, subscribeErr(b.Subscribe(topic, "last all", SubscribeFromLast(10))))
+	assert.Equal(t, ErrOffsetOutOfRange, subscribeErr(b.Subscribe(topic, "overwritten", SubscribeFromOffset(1))))
+
+	assert.Equal(t, []any{"msg-2", "msg-3", "msg-4", "msg-5"}, receive(t, b, topic, "earliest", 4))
+	assert.Equal(t, []any{"msg-4", "msg-5"}, receive(t, b, topic, "offset", 2))
+	assert.Equal(t, []any{"msg-5"}, receive(t, b, topic, "last", 1))
+	assert.Equal(t, []any{"msg-2", "msg-3", "msg-4", "msg-5"}, receive(t, b, topic, "last all", 4))
+
+	// 追上之后接收新的消息
+	sendN(t, b, topic, 6, 1)
+	for _, name := range []string{"latest", "earliest", "offset", "last", "last all"} {
+		assert.Equal(t, []any{"msg-6"}, receive(t, b, topic, name, 1))
+	}
+	assertNoMsg(t, mustQueue(t, b, topic, "last"), 10*time.Millisecond)
+}
+
+func TestBroker_RetentionAge(t *testing.T) {
+	topic := "order"
+	now := time.Unix(1700000000, 0)
+	b := NewBroker(BrokerWithRetention(0, time.Minute))
+	b.logCfg.now = func() time.Time { return now }
+	defer b.Close(topic)
+	sendN(t, b, topic, 0, 2)
+	now = now.Add(2 * time.Minute)
+	sendN(t, b, topic, 2, 1)
+
+	// 超过保留时长的消息不会重放
+	require.NoError(t, subscribeErr(b.Subscribe(topic, "earliest", SubscribeFromEarliest())))
+	assert.Equal(t, []any{"msg-2"}, receive(t, b, topic, "earliest", 1))
+	assertNoMsg(t, mustQueue(t, b, topic, "earliest"), 10*time.Millisecond)
+}
+
+func TestBroker_ReplayFromTime(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts []BrokerOption
+	}{
+		{name: "retention", opts: []BrokerOption{BrokerWithRetention(10, 0)}},
+		{name: "persistence", opts: []BrokerOption{BrokerWithPersistence(t.TempDir())}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			topic := "order"
+			b := NewBroker(tc.opts...)
+			defer b.Close(topic)
+			start := time.Unix(1700000000, 0)
+			for i := 0; i < 4; i++ {
+				require.NoError(t, b.Send(Message{
+					Topic:     topic,
+					Content:   fmt.Sprintf("msg-%d", i),
+					Timestamp: start.Add(time.Duration(i) * time.Minute),
+				}))
+			}
+
+			require.NoError(t, subscribeErr(b.Subscribe(topic, "time", SubscribeFromTime(start.Add(90*time.Second)))))
+			require.NoError(t, subscribeErr(b.Subscribe(topic, "future", SubscribeFromTime(start.Add(time.Hour)))))
+			require.NoError(t, subscribeErr(b.Subscribe(topic, "last", SubscribeFromLast(2))))
+			sendN(t, b, topic, 4, 1)
+			assert.Equal(t, []any{"msg-2", "msg-3", "msg-4"}, receive(t, b, topic, "time", 3))
+			assert.Equal(t, []any{"msg-2", "msg-3", "msg-4"}, receive(t, b, topic, "last", 3))
+			// 没有满足条件的历史消息时只接收新的消息
+			assert.Equal(t, []any{"msg-4"}, receive(t, b, topic, "future", 1))
+		})
+	}
+}
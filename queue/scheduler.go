@@ -0,0 +1,420 @@
+package queue
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// 开启持久化时保存延时消息的文件，%在topic的目录名中会被转义，不会和topic冲突
+	scheduledFile = "%scheduled.log"
+	// scheduledPublishTimeout 投递一条到期的延时消息时OverflowBlock的订阅者最多阻塞的时间
+	scheduledPublishTimeout = time.Second
+	// scheduledCompactRecords 文件中的记录数超过这个值并且超过剩下的延时消息数的两倍时重写文件
+	scheduledCompactRecords = 1024
+)
+
+// 延时消息文件中的记录类型，保存在记录头部的偏移量字段中。
+// 发送和取消都只追加一条记录，记录太多的时候重写文件，只保留还没有投递的消息
+const (
+	// scheduledAdd 新的延时消息，数据是编码之后的消息
+	scheduledAdd uint64 = iota
+	// scheduledRemove 投递或者取消了的延时消息，数据是消息的ID
+	scheduledRemove
+)
+
+var ErrMessageNotScheduled = errors.New("延时消息不存在，可能已经投递或者取消了")
+
+type scheduledItem struct {
+	msg   Message
+	index int
+	// 重启时恢复的消息，Start之前保存在scheduler.recovered中
+	recovered bool
+}
+
+// scheduledHeap 按照投递时间排序的小顶堆
+type scheduledHeap []*scheduledItem
+
+func (h scheduledHeap) Len() int { return len(h) }
+
+func (h scheduledHeap) Less(i, j int) bool { return h[i].msg.DeliverAt.Before(h[j].msg.DeliverAt) }
+
+func (h scheduledHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *scheduledHeap) Push(x any) {
+	item := x.(*scheduledItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *scheduledHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
+
+// scheduler 保存还没有到投递时间的消息，到期之后交给publish投递
+type scheduler struct {
+	publish func(ctx context.Context, msg Message)
+	// 投递一条消息的超时时间
+	publishTimeout time.Duration
+	// close的时候取消，放弃正在阻塞的投递
+	ctx       context.Context
+	cancelCtx context.CancelFunc
+
+	mu    sync.Mutex
+	items scheduledHeap
+	// 开启持久化时重启恢复的消息，到期的消息要等订阅者订阅完成之后再投递，Start之后移到items中
+	recovered scheduledHeap
+	index     map[string]*scheduledItem
+	// 开启持久化时保存延时消息的文件，为空表示不持久化
+	path string
+	file *os.File
+	// 文件的大小和记录数，追加失败时截断到size
+	size    int64
+	records int
+	cfg     logConfig
+	wake    chan struct{}
+	stop    chan struct{}
+	wg      sync.WaitGroup
+	active  bool
+}
+
+func newScheduler(publish func(ctx context.Context, msg Message), cfg logConfig) *scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &scheduler{
+		publish:        publish,
+		publishTimeout: scheduledPublishTimeout,
+		ctx:            ctx,
+		cancelCtx:      cancel,
+		index:          map[string]*scheduledItem{},
+		cfg:            cfg,
+		wake:           make(chan struct{}, 1),
+		stop:           make(chan struct{}),
+	}
+}
+
+// Start 开始投递重启时恢复的延时消息，可以重复调用。开启持久化时NewBroker会恢复上次没有投递的延时消息，
+// 其中已经到期的消息需要等订阅者订阅完成之后才能投递，否则只会写入日志，实时订阅的订阅者收不到。
+// 所以恢复的消息要等到调用Start之后才开始投递，NewBroker之后新发送的延时消息不受影响；没有开启持久化时不需要调用
+func (b *Broker) Start() {
+	b.scheduler.start()
+}
+
+// publishScheduled 投递到期的延时消息，阻塞到ctx结束还没有投递的订阅者通过错误队列通知
+func (b *Broker) publishScheduled(ctx context.Context, msg Message) {
+	report, err := b.publish(ctx, msg)
+	// 到期的时候topic可能已经不存在了，这时候消息会被丢弃
+	if err != nil {
+		return
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, res := range report.Results {
+		if res.Status == DeliveryTimeout {
+			errMsg := msg
+			errMsg.Queue = res.Queue
+			b.reportErr(msg.Topic, ErrMessage{Message: errMsg, Err: res.Err})
+		}
+	}
+}
+
+// load 从dir中恢复上次没有投递的延时消息，文件末尾写了一半或者校验失败的记录会被丢弃，恢复的消息在start之后才会投递
+func (s *scheduler) load(dir string) error {
+	s.path = filepath.Join(dir, scheduledFile)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.replayLocked(); err != nil {
+		return err
+	}
+	s.recovered, s.items = s.items, nil
+	for _, item := range s.recovered {
+		item.recovered = true
+	}
+	// 重写一次文件，去掉末尾写了一半的记录，之后的记录才能追加在完整的记录后面
+	return s.compactLocked()
+}
+
+// replayLocked 按顺序重放文件中的记录，调用方需要持有s.mu
+func (s *scheduler) replayLocked() error {
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		kind, data, _, err := readRecord(r)
+		// 读到末尾、崩溃时写了一半或者校验失败的记录，和segmentLog一样丢弃之后的数据，
+		// load之后的compactLocked会把文件截断到最后一条完整的记录
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, ErrCorruptedLog) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if kind == scheduledRemove {
+			s.removeLocked(string(data))
+			continue
+		}
+		msg, err := s.cfg.codec.Decode(data)
+		if err != nil {
+			return err
+		}
+		s.addLocked(msg)
+	}
+}
+
+// start 开始投递重启时恢复的延时消息
+func (s *scheduler) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.recovered) == 0 {
+		return
+	}
+	for _, item := range s.recovered {
+		item.recovered = false
+		heap.Push(&s.items, item)
+	}
+	s.recovered = nil
+	s.startLocked()
+	s.notify()
+}
+
+// schedule 保存一条延时消息
+func (s *scheduler) schedule(msg Message) error {
+	data, err := s.cfg.codec.Encode(msg)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addLocked(msg)
+	if err = s.appendLocked(encodeRecord(scheduledAdd, data)); err != nil {
+		s.removeLocked(msg.ID)
+		return err
+	}
+	s.startLocked()
+	s.notify()
+	return nil
+}
+
+func (s *scheduler) addLocked(msg Message) {
+	// 相同ID的消息重复发送时以最后一次为准
+	if old, ok := s.index[msg.ID]; ok {
+		heap.Remove(s.heapOf(old), old.index)
+	}
+	item := &scheduledItem{msg: msg}
+	heap.Push(&s.items, item)
+	s.index[msg.ID] = item
+}
+
+// cancel 取消一条还没有投递的延时消息
+func (s *scheduler) cancel(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.index[id]; !ok {
+		return ErrMessageNotScheduled
+	}
+	s.removeLocked(id)
+	s.notify()
+	return s.appendLocked(encodeRecord(scheduledRemove, []byte(id)))
+}
+
+func (s *scheduler) removeLocked(id string) {
+	if item, ok := s.index[id]; ok {
+		heap.Remove(s.heapOf(item), item.index)
+		delete(s.index, id)
+	}
+}
+
+// heapOf 返回item所在的堆
+func (s *scheduler) heapOf(item *scheduledItem) *scheduledHeap {
+	if item.recovered {
+		return &s.recovered
+	}
+	return &s.items
+}
+
+func (s *scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// startLocked 第一次有延时消息的时候启动后台的goroutine，调用方需要持有s.mu
+func (s *scheduler) startLocked() {
+	if s.active {
+		return
+	}
+	s.active = true
+	s.wg.Add(1)
+	go s.run()
+}
+
+func (s *scheduler) run() {
+	defer s.wg.Done()
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		due, wait := s.popDue()
+		for _, msg := range due {
+			// 每条消息单独限制阻塞的时间，一个阻塞的订阅者不能卡住所有的延时消息
+			ctx, cancel := context.WithTimeout(s.ctx, s.publishTimeout)
+			s.publish(ctx, msg)
+			cancel()
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		// 没有延时消息的时候等待新消息的通知
+		if wait >= 0 {
+			timer.Reset(wait)
+		}
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// popDue 取出所有到期的消息，同时返回距离下一条消息到期的时间，没有消息时返回-1
+func (s *scheduler) popDue() ([]Message, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.cfg.now()
+	var due []Message
+	for len(s.items) > 0 && !s.items[0].msg.DeliverAt.After(now) {
+		item := heap.Pop(&s.items).(*scheduledItem)
+		delete(s.index, item.msg.ID)
+		due = append(due, item.msg)
+	}
+	if len(due) > 0 {
+		removed := make([][]byte, len(due))
+		for i, msg := range due {
+			removed[i] = encodeRecord(scheduledRemove, []byte(msg.ID))
+		}
+		// 持久化失败时消息依旧会投递，重启之后可能会重复投递
+		_ = s.appendLocked(removed...)
+	}
+	if len(s.items) == 0 {
+		return due, -1
+	}
+	return due, s.items[0].msg.DeliverAt.Sub(now)
+}
+
+// appendLocked 把编码之后的记录追加到文件末尾，记录太多的时候重写文件，调用方需要持有s.mu
+func (s *scheduler) appendLocked(records ...[]byte) error {
+	if s.file == nil {
+		return nil
+	}
+	buf := bytes.Join(records, nil)
+
+	_, err := s.file.Write(buf)
+	if err == nil && s.cfg.syncPolicy != SyncNever {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		// 去掉写了一半的记录，否则之后追加的记录在重启的时候读不到
+		_ = s.file.Truncate(s.size)
+		return err
+	}
+	s.size += int64(len(buf))
+	s.records += len(records)
+	if s.records >= scheduledCompactRecords && s.records > 2*len(s.index) {
+		return s.compactLocked()
+	}
+	return nil
+}
+
+// compactLocked 把剩下的延时消息写入临时文件之后原子地替换掉原来的文件，调用方需要持有s.mu
+func (s *scheduler) compactLocked() error {
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	var size int64
+	for _, item := range append(s.recovered[:len(s.recovered):len(s.recovered)], s.items...) {
+		data, err := s.cfg.codec.Encode(item.msg)
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+		record := encodeRecord(scheduledAdd, data)
+		if _, err = w.Write(record); err != nil {
+			_ = f.Close()
+			return err
+		}
+		size += int64(len(record))
+	}
+	if err = w.Flush(); err == nil && s.cfg.syncPolicy != SyncNever {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if s.file != nil {
+		_ = s.file.Close()
+	}
+	s.file, s.size, s.records = file, size, len(s.index)
+	return nil
+}
+
+// close 停止后台的goroutine，还没有到期的消息保留在文件中，下次启动时恢复
+func (s *scheduler) close() {
+	s.mu.Lock()
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+		s.cancelCtx()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file != nil {
+		_ = s.file.Close()
+		s.file = nil
+	}
+}
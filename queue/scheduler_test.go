@@ -0,0 +1,230 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBroker_Delay(t *testing.T) {
+	b := NewBroker()
+	defer b.Close("order")
+	require.NoError(t, subscribeErr(b.Subscribe("order", "consumer")))
+	ch, _ := b.Queue("order", "consumer")
+
+	now := time.Now()
+	report, err := b.Publish(context.Background(), Message{Topic: "order", Content: "delay", Delay: 100 * time.Millisecond})
+	require.NoError(t, err)
+	assert.True(t, report.Scheduled)
+	assert.NotEmpty(t, report.ID)
+	assert.Empty(t, report.Results)
+
+	// DeliverAt早的消息先投递，已经到期的消息立即投递
+	_, err = b.Publish(context.Background(), Message{Topic: "order", Content: "deliver at", DeliverAt: now.Add(50 * time.Millisecond)})
+	require.NoError(t, err)
+	report, err = b.Publish(context.Background(), Message{Topic: "order", Content: "now", DeliverAt: now.Add(-time.Second)})
+	require.NoError(t, err)
+	assert.False(t, report.Scheduled)
+
+	assert.Equal(t, "now", receiveMsg(t, ch).Content)
+	msg := receiveMsg(t, ch)
+	assert.Equal(t, "deliver at", msg.Content)
+	assert.GreaterOrEqual(t, time.Since(now), 50*time.Millisecond)
+	msg = receiveMsg(t, ch)
+	assert.Equal(t, "delay", msg.Content)
+	assert.GreaterOrEqual(t, time.Since(now), 100*time.Millisecond)
+	assert.Zero(t, msg.Delay)
+
+	_, err = b.Publish(context.Background(), Message{Topic: "unknown", Content: "delay", Delay: time.Second})
+	assert.EqualError(t, err, "topic不存在")
+}
+
+func TestBroker_Cancel(t *testing.T) {
+	b := NewBroker()
+	defer b.Close("order")
+	require.NoError(t, subscribeErr(b.Subscribe("order", "consumer")))
+	ch, _ := b.Queue("order", "consumer")
+
+	report, err := b.Publish(context.Background(), Message{Topic: "order", Content: "canceled", Delay: 50 * time.Millisecond})
+	require.NoError(t, err)
+	_, err = b.Publish(context.Background(), Message{Topic: "order", Content: "kept", Delay: 80 * time.Millisecond})
+	require.NoError(t, err)
+
+	require.NoError(t, b.Cancel(report.ID))
+	assert.Equal(t, ErrMessageNotScheduled, b.Cancel(report.ID))
+	assert.Equal(t, "kept", receiveMsg(t, ch).Content)
+	assertNoMsg(t, ch, 50*time.Millisecond)
+	assert.Equal(t, ErrMessageNotScheduled, b.Cancel("unknown"))
+}
+
+func TestBroker_DelayBlocked(t *testing.T) {
+	b := NewBroker()
+	defer b.Close("order")
+	b.scheduler.publishTimeout = 20 * time.Millisecond
+	require.NoError(t, subscribeErr(b.Subscribe("order", "blocked",
+		SubscribeWithCapacity(1), SubscribeWithOverflow(OverflowBlock))))
+	require.NoError(t, subscribeErr(b.Subscribe("order", "consumer")))
+	ch, _ := b.Queue("order", "consumer")
+	errCh, _ := b.ErrQueue("order")
+	// blocked的队列已满并且没有人消费
+	require.NoError(t, b.Send(Message{Topic: "order", Content: "full"}))
+	assert.Equal(t, "full", receiveMsg(t, ch).Content)
+
+	for _, content := range []string{"delay-1", "delay-2"} {
+		_, err := b.Publish(context.Background(), Message{Topic: "order", Content: content, Delay: 10 * time.Millisecond})
+		require.NoError(t, err)
+	}
+
+	// 阻塞的订阅者不会卡住其他的延时消息，没有投递的消息通过错误队列通知
+	assert.Equal(t, "delay-1", receiveMsg(t, ch).Content)
+	assert.Equal(t, "delay-2", receiveMsg(t, ch).Content)
+	for _, content := range []string{"delay-1", "delay-2"} {
+		select {
+		case errMsg := <-errCh:
+			assert.Equal(t, content, errMsg.Content)
+			assert.Equal(t, "blocked", errMsg.Queue)
+			assert.Equal(t, context.DeadlineExceeded, errMsg.Err)
+		case <-time.After(time.Second):
+			t.Fatal("没有收到错误消息")
+		}
+	}
+}
+
+func TestBroker_DelayPersistence(t *testing.T) {
+	dir := t.TempDir()
+	b := NewBroker(BrokerWithPersistence(dir))
+	// 开启持久化时topic没有订阅者也可以发送延时消息
+	_, err := b.Publish(context.Background(), Message{Topic: "order", Content: "overdue", Delay: 50 * time.Millisecond})
+	require.NoError(t, err)
+	_, err = b.Publish(context.Background(), Message{Topic: "order", Content: "delay", Delay: time.Hour})
+	require.NoError(t, err)
+	report, err := b.Publish(context.Background(), Message{Topic: "order", Content: "canceled", Delay: time.Hour})
+	require.NoError(t, err)
+	require.NoError(t, b.Cancel(report.ID))
+	b.scheduler.close()
+	b.Close("order")
+	time.Sleep(60 * time.Millisecond)
+
+	// 重启之后恢复没有投递的延时消息，取消的消息不会恢复。
+	// 已经到期的消息在Start之后才投递，实时订阅的订阅者也能收到
+	b = NewBroker(BrokerWithPersistence(dir))
+	defer b.Close("order")
+	require.NoError(t, subscribeErr(b.Subscribe("order", "consumer")))
+	ch, _ := b.Queue("order", "consumer")
+	assertNoMsg(t, ch, 50*time.Millisecond)
+	// 重启之后新发送的延时消息不需要等Start
+	_, err = b.Publish(context.Background(), Message{Topic: "order", Content: "fresh", Delay: 10 * time.Millisecond})
+	require.NoError(t, err)
+	assert.Equal(t, "fresh", receiveMsg(t, ch).Content)
+	assertNoMsg(t, ch, 50*time.Millisecond)
+	b.Start()
+	msg := receiveMsg(t, ch)
+	assert.Equal(t, "overdue", msg.Content)
+	// 投递的时候才写入日志，排在fresh之后
+	assert.Equal(t, uint64(1), msg.Offset)
+	assertNoMsg(t, ch, 50*time.Millisecond)
+	assert.Equal(t, ErrMessageNotScheduled, b.Cancel(report.ID))
+	assert.Equal(t, 1, len(b.scheduler.index))
+}
+
+func TestScheduler_Persistence(t *testing.T) {
+	dir := t.TempDir()
+	open := func() *scheduler {
+		s := newScheduler(func(ctx context.Context, msg Message) {}, newLogConfig([]LogOption{LogWithSyncPolicy(SyncNever)}))
+		require.NoError(t, s.load(dir))
+		t.Cleanup(s.close)
+		return s
+	}
+	deliverAt := time.Now().Add(time.Hour)
+	s := open()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, s.schedule(Message{ID: fmt.Sprintf("msg-%d", i), DeliverAt: deliverAt}))
+	}
+	// 取消只追加一条记录，不会重写文件
+	size := s.size
+	require.NoError(t, s.cancel("msg-0"))
+	assert.Equal(t, size+recordHeaderSize+int64(len("msg-0")), s.size)
+	assert.Equal(t, 11, s.records)
+
+	// 记录太多的时候重写文件，只保留剩下的延时消息
+	for i := 0; i < scheduledCompactRecords; i++ {
+		id := fmt.Sprintf("tmp-%d", i)
+		require.NoError(t, s.schedule(Message{ID: id, DeliverAt: deliverAt}))
+		require.NoError(t, s.cancel(id))
+	}
+	assert.Less(t, s.records, scheduledCompactRecords)
+	s.close()
+
+	// 末尾写了一半的记录会被忽略，之后追加的记录在重启之后依旧可以读到
+	f, err := os.OpenFile(filepath.Join(dir, scheduledFile), os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write(encodeRecord(scheduledAdd, []byte(`{"ID":"torn"}`))[:recordHeaderSize+3])
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	s = open()
+	assert.Equal(t, 9, len(s.index))
+	require.NoError(t, s.schedule(Message{ID: "msg-10", DeliverAt: deliverAt}))
+	s.close()
+
+	s = open()
+	assert.Equal(t, 10, len(s.index))
+	assert.NotContains(t, s.index, "msg-0")
+	assert.Contains(t, s.index, "msg-10")
+}
+
+func TestScheduler_CorruptedTail(t *testing.T) {
+	valid := encodeRecord(scheduledAdd, []byte(`{"ID":"msg-0"}`))
+	torn := encodeRecord(scheduledAdd, []byte(`{"ID":"torn"}`))
+	torn[len(torn)-1] ^= 0xff
+
+	testCases := []struct {
+		name string
+		tail []byte
+	}{
+		{name: "zero filled", tail: make([]byte, 64)},
+		{name: "checksum mismatch", tail: torn},
+		{name: "checksum mismatch before zeros", tail: append(torn, make([]byte, 16)...)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, scheduledFile)
+			require.NoError(t, os.WriteFile(path, append(append([]byte{}, valid...), tc.tail...), 0o644))
+			open := func() *scheduler {
+				s := newScheduler(func(ctx context.Context, msg Message) {}, newLogConfig(nil))
+				require.NoError(t, s.load(dir))
+				t.Cleanup(s.close)
+				return s
+			}
+
+			// 崩溃时留下的损坏的尾部被截掉，不影响之前的记录
+			s := open()
+			assert.Equal(t, []string{"msg-0"}, scheduledIDs(s))
+			info, err := os.Stat(path)
+			require.NoError(t, err)
+			assert.Equal(t, s.size, info.Size())
+
+			require.NoError(t, s.schedule(Message{ID: "msg-1", DeliverAt: time.Now().Add(time.Hour)}))
+			s.close()
+			s = open()
+			assert.ElementsMatch(t, []string{"msg-0", "msg-1"}, scheduledIDs(s))
+		})
+	}
+}
+
+func scheduledIDs(s *scheduler) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0, len(s.index))
+	for id := range s.index {
+		ids = append(ids, id)
+	}
+	return ids
+}
@@ -0,0 +1,532 @@
+package queue
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSegmentBytes = 64 << 20    // 默认单个段文件的大小上限
+	DefaultSyncInterval = time.Second // 默认定时刷盘的间隔
+
+	// 每条记录的头部：数据长度(4字节)、校验和(4字节)、偏移量(8字节)
+	recordHeaderSize = 16
+	segmentSuffix    = ".log"
+)
+
+var (
+	ErrCorruptedLog      = errors.New("消息日志已损坏")
+	ErrOffsetOutOfRange  = errors.New("偏移量超出范围")
+	ErrLogClosed         = errors.New("消息日志已关闭")
+	ErrPersistenceNotSet = errors.New("没有开启持久化")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// SyncPolicy 刷盘策略，决定宕机时最多丢失多少消息
+type SyncPolicy int
+
+const (
+	// SyncInterval 每隔一段时间刷一次盘，默认策略，宕机时最多丢失一个间隔内的消息
+	SyncInterval SyncPolicy = iota
+	// SyncAlways 每条消息都刷盘，最可靠也最慢
+	SyncAlways
+	// SyncNever 交给操作系统决定什么时候刷盘，进程崩溃不会丢消息，机器宕机会丢
+	SyncNever
+)
+
+type LogOption func(*logConfig)
+
+type logConfig struct {
+	// 单个段文件的大小上限，超过之后滚动到新的段
+	segmentBytes int64
+	syncPolicy   SyncPolicy
+	syncInterval time.Duration
+	// 保留的总字节数，0表示不限制
+	retentionBytes int64
+	// 保留的时长，0表示不限制
+	retentionAge time.Duration
+	codec        Codec
+	// 获取当前时间，测试时可以替换
+	now func() time.Time
+}
+
+func newLogConfig(opts []LogOption) logConfig {
+	cfg := logConfig{
+		segmentBytes: DefaultSegmentBytes,
+		syncPolicy:   SyncInterval,
+		syncInterval: DefaultSyncInterval,
+		codec:        JSONCodec{},
+		now:          time.Now,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.syncPolicy == SyncInterval && cfg.syncInterval <= 0 {
+		// 没有间隔可以等待，每条消息都刷盘
+		cfg.syncPolicy = SyncAlways
+	}
+	return cfg
+}
+
+// LogWithSegmentBytes 设置单个段文件的大小上限，默认为DefaultSegmentBytes
+func LogWithSegmentBytes(n int64) LogOption {
+	return func(cfg *logConfig) {
+		cfg.segmentBytes = n
+	}
+}
+
+// LogWithSyncPolicy 设置刷盘策略，默认为SyncInterval
+func LogWithSyncPolicy(p SyncPolicy) LogOption {
+	return func(cfg *logConfig) {
+		cfg.syncPolicy = p
+	}
+}
+
+// LogWithSyncInterval 使用SyncInterval策略，每隔d刷一次盘，d小于等于0时每条消息都刷盘，等同于SyncAlways
+func LogWithSyncInterval(d time.Duration) LogOption {
+	return func(cfg *logConfig) {
+		cfg.syncPolicy = SyncInterval
+		cfg.syncInterval = d
+	}
+}
+
+// LogWithRetentionBytes 日志总大小超过n时删除最旧的段，正在写入的段不会被删除
+func LogWithRetentionBytes(n int64) LogOption {
+	return func(cfg *logConfig) {
+		cfg.retentionBytes = n
+	}
+}
+
+// LogWithRetentionAge 删除最后一次写入早于d之前的段，正在写入的段不会被删除
+func LogWithRetentionAge(d time.Duration) LogOption {
+	return func(cfg *logConfig) {
+		cfg.retentionAge = d
+	}
+}
+
+// LogWithCodec 设置消息的编解码器，默认为JSONCodec
+func LogWithCodec(c Codec) LogOption {
+	return func(cfg *logConfig) {
+		cfg.codec = c
+	}
+}
+
+// segment 日志段，文件名是段中第一条消息的偏移量
+type segment struct {
+	base uint64
+	path string
+	size int64
+	// 最后一次写入的时间，用于按时长清理
+	modTime time.Time
+}
+
+// segmentLog 一个topic的追加写日志，由多个段文件组成，每条消息有一个单调递增的偏移量。
+// 记录的格式是：数据长度、CRC32校验和、偏移量、编码之后的消息
+type segmentLog struct {
+	dir string
+	cfg logConfig
+
+	mu       sync.Mutex
+	segments []*segment
+	active   *os.File
+	// 下一条消息的偏移量
+	nextOffset uint64
+	// 上次刷盘之后是否有新的写入
+	dirty  bool
+	closed bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// openLog 打开dir下的日志，目录不存在时创建。
+// 会校验所有记录，最后一个段末尾写了一半的记录(进程崩溃导致)会被截断
+func openLog(dir string, cfg logConfig) (*segmentLog, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	l := &segmentLog{
+		dir:  dir,
+		cfg:  cfg,
+		stop: make(chan struct{}),
+	}
+	if err := l.recover(); err != nil {
+		return nil, err
+	}
+
+	if cfg.syncPolicy == SyncInterval || cfg.retentionAge > 0 {
+		l.wg.Add(1)
+		go l.background()
+	}
+	return l, nil
+}
+
+func (l *segmentLog) recover() error {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		base, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		l.segments = append(l.segments, &segment{
+			base:    base,
+			path:    filepath.Join(l.dir, name),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+	sort.Slice(l.segments, func(i, j int) bool {
+		return l.segments[i].base < l.segments[j].base
+	})
+
+	if len(l.segments) == 0 {
+		return l.roll(0)
+	}
+
+	for i, seg := range l.segments {
+		last := i == len(l.segments)-1
+		next, validSize, err := scanSegment(seg)
+		if err != nil {
+			return err
+		}
+		if validSize != seg.size {
+			// 只有最后一个段可能因为崩溃写了一半
+			if !last {
+				return fmt.Errorf("%w: %s", ErrCorruptedLog, seg.path)
+			}
+			if err = os.Truncate(seg.path, validSize); err != nil {
+				return err
+			}
+			seg.size = validSize
+		}
+		if last {
+			l.nextOffset = next
+		}
+	}
+
+	active, err := os.OpenFile(l.segments[len(l.segments)-1].path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	l.active = active
+	return nil
+}
+
+// scanSegment 校验段中的记录，返回下一条消息的偏移量和有效数据的长度
+func scanSegment(seg *segment) (uint64, int64, error) {
+	f, err := os.Open(seg.path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	next := seg.base
+	var validSize int64
+	r := bufio.NewReader(f)
+	for {
+		offset, _, n, err := readRecord(r)
+		if err != nil {
+			// 读到末尾、记录不完整或者校验失败，之后的数据都是无效的
+			return next, validSize, nil
+		}
+		if offset != next {
+			return next, validSize, nil
+		}
+		next++
+		validSize += n
+	}
+}
+
+// readRecord 读取一条记录，返回偏移量、消息的原始数据和记录的总长度
+func readRecord(r io.Reader) (uint64, []byte, int64, error) {
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, 0, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	sum := binary.BigEndian.Uint32(header[4:8])
+	offset := binary.BigEndian.Uint64(header[8:16])
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, 0, err
+	}
+
+	crc := crc32.Update(0, crcTable, header[8:16])
+	if crc32.Update(crc, crcTable, data) != sum {
+		return 0, nil, 0, ErrCorruptedLog
+	}
+	return offset, data, int64(recordHeaderSize + length), nil
+}
+
+func encodeRecord(offset uint64, data []byte) []byte {
+	buf := make([]byte, recordHeaderSize+len(data))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint64(buf[8:16], offset)
+	copy(buf[recordHeaderSize:], data)
+	crc := crc32.Update(0, crcTable, buf[8:16])
+	binary.BigEndian.PutUint32(buf[4:8], crc32.Update(crc, crcTable, data))
+	return buf
+}
+
+// append 追加一条消息，返回它的偏移量
+func (l *segmentLog) append(msg Message) (uint64, error) {
+	data, err := l.cfg.codec.Encode(msg)
+	if err != nil {
+		return 0, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return 0, ErrLogClosed
+	}
+
+	offset := l.nextOffset
+	record := encodeRecord(offset, data)
+	active := l.segments[len(l.segments)-1]
+	if active.size > 0 && active.size+int64(len(record)) > l.cfg.segmentBytes {
+		if err = l.roll(offset); err != nil {
+			return 0, err
+		}
+		active = l.segments[len(l.segments)-1]
+	}
+
+	if _, err = l.active.Write(record); err != nil {
+		return 0, err
+	}
+	active.size += int64(len(record))
+	active.modTime = l.cfg.now()
+	l.nextOffset++
+	l.dirty = true
+
+	if l.cfg.syncPolicy == SyncAlways {
+		if err = l.syncLocked(); err != nil {
+			return 0, err
+		}
+	}
+	return offset, nil
+}
+
+// roll 关闭当前的段，创建一个从base开始的新段，调用方需要持有l.mu
+func (l *segmentLog) roll(base uint64) error {
+	if l.active != nil {
+		if err := l.syncLocked(); err != nil {
+			return err
+		}
+		if err := l.active.Close(); err != nil {
+			return err
+		}
+	}
+
+	path := filepath.Join(l.dir, fmt.Sprintf("%020d%s", base, segmentSuffix))
+	active, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	l.active = active
+	l.segments = append(l.segments, &segment{
+		base:    base,
+		path:    path,
+		modTime: l.cfg.now(),
+	})
+	return l.applyRetentionLocked()
+}
+
+// read 从offset开始按顺序读取调用时已经写入的消息，fn返回false时停止读取。
+// 返回下一条要读取的消息的偏移量；offset之前的段被清理掉的时候从最早的消息开始读
+func (l *segmentLog) read(offset uint64, fn func(msg Message) bool) (uint64, error) {
+	l.mu.Lock()
+	end := l.nextOffset
+	segments := make([]segment, 0, len(l.segments))
+	for _, seg := range l.segments {
+		segments = append(segments, *seg)
+	}
+	l.mu.Unlock()
+
+	for i, seg := range segments {
+		if offset >= end {
+			break
+		}
+		// offset不在这个段中
+		if i+1 < len(segments) && segments[i+1].base <= offset {
+			continue
+		}
+		if offset < seg.base {
+			offset = seg.base
+		}
+
+		next, stopped, err := l.readSegment(seg, offset, end, fn)
+		if errors.Is(err, os.ErrNotExist) {
+			// 段在读取之前被清理掉了
+			continue
+		}
+		if err != nil {
+			return offset, err
+		}
+		offset = next
+		if stopped {
+			break
+		}
+	}
+	return offset, nil
+}
+
+func (l *segmentLog) readSegment(seg segment, offset, end uint64,
+	fn func(msg Message) bool) (uint64, bool, error) {
+	f, err := os.Open(seg.path)
+	if err != nil {
+		return offset, false, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(io.LimitReader(f, seg.size))
+	for offset < end {
+		off, data, _, err := readRecord(r)
+		if err == io.EOF {
+			return offset, false, nil
+		}
+		if err != nil {
+			return offset, false, fmt.Errorf("%w: %s", ErrCorruptedLog, seg.path)
+		}
+		if off < offset {
+			continue
+		}
+
+		msg, err := l.cfg.codec.Decode(data)
+		if err != nil {
+			return offset, false, err
+		}
+		msg.Offset = off
+		if !fn(msg) {
+			return offset, true, nil
+		}
+		offset = off + 1
+	}
+	return offset, false, nil
+}
+
+// offsets 返回最早的消息的偏移量和下一条消息的偏移量
+func (l *segmentLog) offsets() (uint64, uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.segments[0].base, l.nextOffset
+}
+
+func (l *segmentLog) sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	return l.syncLocked()
+}
+
+func (l *segmentLog) syncLocked() error {
+	if !l.dirty || l.cfg.syncPolicy == SyncNever {
+		return nil
+	}
+	if err := l.active.Sync(); err != nil {
+		return err
+	}
+	l.dirty = false
+	return nil
+}
+
+func (l *segmentLog) applyRetention() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	return l.applyRetentionLocked()
+}
+
+// applyRetentionLocked 按照大小和时长删除最旧的段，正在写入的段永远不会被删除
+func (l *segmentLog) applyRetentionLocked() error {
+	var total int64
+	for _, seg := range l.segments {
+		total += seg.size
+	}
+
+	now := l.cfg.now()
+	for len(l.segments) > 1 {
+		oldest := l.segments[0]
+		overSize := l.cfg.retentionBytes > 0 && total > l.cfg.retentionBytes
+		overAge := l.cfg.retentionAge > 0 && now.Sub(oldest.modTime) > l.cfg.retentionAge
+		if !overSize && !overAge {
+			break
+		}
+		if err := os.Remove(oldest.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		total -= oldest.size
+		l.segments = l.segments[1:]
+	}
+	return nil
+}
+
+// background 定时刷盘和按时长清理
+func (l *segmentLog) background() {
+	defer l.wg.Done()
+	interval := l.cfg.syncInterval
+	if l.cfg.syncPolicy != SyncInterval {
+		interval = l.cfg.retentionAge
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// 后台任务失败时下一次会重试，写入时的错误会直接返回给调用方
+			_ = l.sync()
+			_ = l.applyRetention()
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// close 刷盘并关闭日志
+func (l *segmentLog) close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.stop)
+	err := l.syncLocked()
+	if closeErr := l.active.Close(); err == nil {
+		err = closeErr
+	}
+	l.mu.Unlock()
+
+	l.wg.Wait()
+	return err
+}
@@ -0,0 +1,232 @@
+package queue
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestLog(t *testing.T, dir string, opts ...LogOption) *segmentLog {
+	log, err := openLog(dir, newLogConfig(opts))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = log.close()
+	})
+	return log
+}
+
+func appendN(t *testing.T, log *segmentLog, from, n int) {
+	for i := from; i < from+n; i++ {
+		_, err := log.append(Message{Topic: "topic", Content: fmt.Sprintf("msg-%d", i)})
+		require.NoError(t, err)
+	}
+}
+
+// readAll 读取offset之后的所有消息的内容
+func readAll(t *testing.T, log *segmentLog, offset uint64) []any {
+	var res []any
+	_, err := log.read(offset, func(msg Message) bool {
+		assert.Equal(t, offset, msg.Offset)
+		offset++
+		res = append(res, msg.Content)
+		return true
+	})
+	require.NoError(t, err)
+	return res
+}
+
+func TestSegmentLog_AppendAndRead(t *testing.T) {
+	dir := t.TempDir()
+	// 每个段只能放下两三条消息，会滚动出多个段
+	log := openTestLog(t, dir, LogWithSegmentBytes(200), LogWithSyncPolicy(SyncAlways))
+	appendN(t, log, 0, 10)
+	assert.Greater(t, len(log.segments), 1)
+
+	assert.Len(t, readAll(t, log, 0), 10)
+	assert.Equal(t, []any{"msg-8", "msg-9"}, readAll(t, log, 8))
+	assert.Empty(t, readAll(t, log, 10))
+
+	// fn返回false时停止读取
+	next, err := log.read(3, func(msg Message) bool {
+		return msg.Offset < 5
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(5), next)
+
+	// 重新打开之后偏移量接着之前的继续
+	require.NoError(t, log.close())
+	log = openTestLog(t, dir, LogWithSegmentBytes(200))
+	earliest, end := log.offsets()
+	assert.Equal(t, uint64(0), earliest)
+	assert.Equal(t, uint64(10), end)
+	appendN(t, log, 10, 1)
+	assert.Equal(t, []any{"msg-9", "msg-10"}, readAll(t, log, 9))
+}
+
+func TestSegmentLog_SyncInterval(t *testing.T) {
+	testCases := []struct {
+		name     string
+		interval time.Duration
+		want     SyncPolicy
+	}{
+		{
+			name:     "interval",
+			interval: time.Millisecond,
+			want:     SyncInterval,
+		},
+		{
+			name: "zero",
+			want: SyncAlways,
+		},
+		{
+			name:     "negative",
+			interval: -time.Second,
+			want:     SyncAlways,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			log := openTestLog(t, t.TempDir(), LogWithSyncInterval(tc.interval))
+			assert.Equal(t, tc.want, log.cfg.syncPolicy)
+			appendN(t, log, 0, 3)
+			assert.Len(t, readAll(t, log, 0), 3)
+			require.NoError(t, log.close())
+		})
+	}
+}
+
+func TestSegmentLog_Recover(t *testing.T) {
+	testCases := []struct {
+		name string
+		// corrupt 破坏最后一个段文件，模拟写到一半的时候进程崩溃
+		corrupt  func(t *testing.T, path string)
+		wantNext uint64
+	}{
+		{
+			name: "partial header",
+			corrupt: func(t *testing.T, path string) {
+				f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+				require.NoError(t, err)
+				_, err = f.Write([]byte{0, 0, 1})
+				require.NoError(t, err)
+				require.NoError(t, f.Close())
+			},
+			wantNext: 3,
+		},
+		{
+			name: "partial record",
+			corrupt: func(t *testing.T, path string) {
+				info, err := os.Stat(path)
+				require.NoError(t, err)
+				require.NoError(t, os.Truncate(path, info.Size()-2))
+			},
+			wantNext: 2,
+		},
+		{
+			name: "checksum mismatch",
+			corrupt: func(t *testing.T, path string) {
+				data, err := os.ReadFile(path)
+				require.NoError(t, err)
+				data[len(data)-1] ^= 0xff
+				require.NoError(t, os.WriteFile(path, data, 0o644))
+			},
+			wantNext: 2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			log := openTestLog(t, dir)
+			appendN(t, log, 0, 3)
+			path := log.segments[len(log.segments)-1].path
+			require.NoError(t, log.close())
+			tc.corrupt(t, path)
+
+			log = openTestLog(t, dir)
+			_, end := log.offsets()
+			assert.Equal(t, tc.wantNext, end)
+			// 截断之后可以继续正常写入
+			appendN(t, log, int(tc.wantNext), 1)
+			assert.Len(t, readAll(t, log, 0), int(tc.wantNext)+1)
+		})
+	}
+}
+
+func TestSegmentLog_CorruptedSegment(t *testing.T) {
+	dir := t.TempDir()
+	log := openTestLog(t, dir, LogWithSegmentBytes(200))
+	appendN(t, log, 0, 10)
+	path := log.segments[0].path
+	require.NoError(t, log.close())
+
+	// 不是最后一个段的数据损坏不是崩溃导致的，不能直接截断
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data[recordHeaderSize] ^= 0xff
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+	_, err = openLog(dir, newLogConfig(nil))
+	assert.ErrorIs(t, err, ErrCorruptedLog)
+}
+
+func TestSegmentLog_Retention(t *testing.T) {
+	now := time.Now()
+	testCases := []struct {
+		name string
+		opts []LogOption
+		// before 写入消息之后调整时间
+		before       func()
+		wantEarliest bool
+	}{
+		{
+			name: "retention bytes",
+			opts: []LogOption{LogWithRetentionBytes(400)},
+		},
+		{
+			name: "retention age",
+			opts: []LogOption{LogWithRetentionAge(time.Hour)},
+			before: func() {
+				now = now.Add(2 * time.Hour)
+			},
+		},
+		{
+			name:         "no retention",
+			wantEarliest: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := append([]LogOption{
+				LogWithSegmentBytes(200),
+				func(cfg *logConfig) {
+					cfg.now = func() time.Time {
+						return now
+					}
+				},
+			}, tc.opts...)
+			log := openTestLog(t, t.TempDir(), opts...)
+			appendN(t, log, 0, 10)
+			if tc.before != nil {
+				tc.before()
+			}
+			require.NoError(t, log.applyRetention())
+
+			earliest, end := log.offsets()
+			assert.Equal(t, uint64(10), end)
+			assert.Equal(t, tc.wantEarliest, earliest == 0)
+			// 被清理的段的文件也被删除了
+			files, err := filepath.Glob(filepath.Join(log.dir, "*"+segmentSuffix))
+			require.NoError(t, err)
+			assert.Len(t, files, len(log.segments))
+			// 读取被清理的偏移量时从最早的消息开始
+			assert.Len(t, readAll(t, log, earliest), int(end-earliest))
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// drainInterval Drain和Shutdown检查消息是否处理完的间隔
+const drainInterval = 10 * time.Millisecond
+
+var ErrBrokerClosed = errors.New("broker已关闭")
+
+// Subscription Subscribe和SubscribeGroup返回的订阅句柄，其他的Broker实现通过NewSubscription创建同样的句柄
+type Subscription struct {
+	ch     <-chan Message
+	errCh  <-chan ErrMessage
+	closer SubscriptionCloser
+}
+
+// SubscriptionCloser 取消订阅的方式，由创建订阅的Broker实现
+type SubscriptionCloser interface {
+	// Unsubscribe 立即取消订阅并关闭管道，可以重复调用
+	Unsubscribe()
+	// Drain 停止接收新的消息，等已经投递的消息处理完之后再关闭管道，ctx结束时依旧会取消订阅
+	Drain(ctx context.Context) error
+}
+
+// NewSubscription 创建订阅的句柄
+// @param ch 接收消息的管道，取消订阅之后需要关闭
+// @param errCh topic的错误队列
+// @param closer 取消订阅的实现
+func NewSubscription(ch <-chan Message, errCh <-chan ErrMessage, closer SubscriptionCloser) *Subscription {
+	return &Subscription{ch: ch, errCh: errCh, closer: closer}
+}
+
+// newHandle 创建订阅的句柄，调用方需要持有b.mu
+func (b *Broker) newHandle(topic, group, name string, sub *subscription) *Subscription {
+	return NewSubscription(sub.out, b.topicErrQueue[topic], &memoryCloser{
+		broker: b,
+		topic:  topic,
+		group:  group,
+		name:   name,
+		sub:    sub,
+	})
+}
+
+// C 接收消息的管道，取消订阅之后会被关闭
+func (s *Subscription) C() <-chan Message {
+	return s.ch
+}
+
+// Err topic的错误队列，同一个topic下的订阅共享，topic下所有的订阅都取消之后会被关闭
+func (s *Subscription) Err() <-chan ErrMessage {
+	return s.errCh
+}
+
+// Unsubscribe 立即取消订阅并关闭管道，管道中还没有取走的消息依旧可以读取，可以重复调用
+func (s *Subscription) Unsubscribe() {
+	s.closer.Unsubscribe()
+}
+
+// Drain 停止接收新的消息，等已经投递的消息都被取走(开启确认时是都被确认)之后再关闭管道。
+// ctx结束时依旧会取消订阅，返回ctx.Err()
+func (s *Subscription) Drain(ctx context.Context) error {
+	return s.closer.Drain(ctx)
+}
+
+// memoryCloser 取消Broker中的订阅
+type memoryCloser struct {
+	broker *Broker
+	topic  string
+	// group 消费组的名称，Subscribe订阅的队列为空
+	group string
+	// name 队列的名称，消费组中是消费者的名称
+	name string
+	sub  *subscription
+}
+
+func (c *memoryCloser) Unsubscribe() {
+	b := c.broker
+	b.mu.Lock()
+	sub, errCh := c.remove()
+	b.mu.Unlock()
+	stopSubscription(sub, errCh)
+}
+
+func (c *memoryCloser) Drain(ctx context.Context) error {
+	b := c.broker
+	b.mu.Lock()
+	sub, errCh := c.remove()
+	b.mu.Unlock()
+	if sub == nil {
+		return nil
+	}
+
+	err := waitDrained(ctx, []*subscription{sub})
+	stopSubscription(sub, errCh)
+	return err
+}
+
+// remove 把订阅从Broker中删除，已经删除时返回nil，调用方需要持有b.mu
+func (c *memoryCloser) remove() (*subscription, chan ErrMessage) {
+	if c.group == "" {
+		return c.broker.removeQueue(c.topic, c.name, c.sub)
+	}
+	return c.broker.removeMember(c.topic, c.group, c.name, c.sub)
+}
+
+// waitDrained 等待所有的订阅都没有未处理的消息，包括溢出缓冲区中的消息以及阻塞在Publish中的消息
+func waitDrained(ctx context.Context, subs []*subscription) error {
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+	for {
+		drained := true
+		for _, sub := range subs {
+			if sub.pending() > 0 {
+				drained = false
+				break
+			}
+		}
+		if drained {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Shutdown 优雅地关闭Broker：不再接受新的消息和订阅，停止投递延时消息，
+// 等所有订阅中已经投递的消息都被取走(开启确认时是都被确认)之后关闭所有的管道和日志。
+// ctx结束时不再等待，直接关闭，返回ctx.Err()。没有到期的延时消息只有开启持久化时才会保留
+func (b *Broker) Shutdown(ctx context.Context) error {
+	b.mu.Lock()
+	if b.closing {
+		b.mu.Unlock()
+		return ErrBrokerClosed
+	}
+	b.closing = true
+	b.mu.Unlock()
+
+	// 延时消息的goroutine会调用publish，需要在获取所有订阅之前停止
+	b.scheduler.close()
+
+	b.mu.Lock()
+	subs := b.allSubscriptions()
+	b.mu.Unlock()
+	err := waitDrained(ctx, subs)
+
+	// 等待的过程中可能有订阅被取消了，重新获取一次，避免重复关闭
+	b.mu.Lock()
+	subs = b.allSubscriptions()
+	errChs := make([]chan ErrMessage, 0, len(b.topicErrQueue))
+	for _, errCh := range b.topicErrQueue {
+		errChs = append(errChs, errCh)
+	}
+	logs := b.logs
+	b.brokerChain = map[string]map[string]*subscription{}
+	b.patterns = map[string]struct{}{}
+	b.groups = map[string]map[string]*consumerGroup{}
+	b.topicErrQueue = map[string]chan ErrMessage{}
+	b.logs = map[string]messageLog{}
+	b.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.stop()
+	}
+	for _, errCh := range errChs {
+		close(errCh)
+	}
+	for _, log := range logs {
+		err = errors.Join(err, log.close())
+	}
+	return err
+}
+
+// allSubscriptions 返回所有的订阅，包括消费组中的消费者，调用方需要持有b.mu
+func (b *Broker) allSubscriptions() []*subscription {
+	var subs []*subscription
+	for _, chain := range b.brokerChain {
+		for _, sub := range chain {
+			subs = append(subs, sub)
+		}
+	}
+	for _, groups := range b.groups {
+		for _, g := range groups {
+			subs = append(subs, g.members...)
+		}
+	}
+	return subs
+}
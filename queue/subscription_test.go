@@ -0,0 +1,182 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// subscribeErr 只关心订阅是否成功的时候使用
+func subscribeErr(_ *Subscription, err error) error {
+	return err
+}
+
+func TestSubscription(t *testing.T) {
+	b := NewBroker()
+	sub, err := b.Subscribe("order", "consumer", SubscribeWithCapacity(1))
+	require.NoError(t, err)
+	other, err := b.Subscribe("order", "other")
+	require.NoError(t, err)
+	sendN(t, b, "order", 0, 2)
+
+	assert.Equal(t, "msg-0", receiveMsg(t, sub.C()).Content)
+	errMsg := <-sub.Err()
+	assert.Equal(t, ErrQueueFull, errMsg.Err)
+	assert.Equal(t, "msg-1", errMsg.Content)
+
+	// 取消订阅之后管道被关闭，重复调用不会panic
+	sub.Unsubscribe()
+	sub.Unsubscribe()
+	_, ok := <-sub.C()
+	assert.False(t, ok)
+	_, ok = b.Queue("order", "consumer")
+	assert.False(t, ok)
+
+	// 旧的句柄不会取消重新订阅的同名队列
+	resub, err := b.Subscribe("order", "consumer")
+	require.NoError(t, err)
+	sub.Unsubscribe()
+	_, ok = b.Queue("order", "consumer")
+	assert.True(t, ok)
+
+	// topic下所有的订阅都取消之后错误队列被关闭
+	resub.Unsubscribe()
+	other.Unsubscribe()
+	_, ok = <-other.Err()
+	assert.False(t, ok)
+}
+
+func TestSubscription_Drain(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts []SubscribeOption
+	}{
+		{name: "without ack"},
+		{name: "with ack", opts: []SubscribeOption{SubscribeWithAck(time.Second)}},
+		{name: "spill", opts: []SubscribeOption{SubscribeWithCapacity(1), SubscribeWithOverflow(OverflowSpill)}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := NewBroker()
+			sub, err := b.Subscribe("order", "consumer", tc.opts...)
+			require.NoError(t, err)
+			group, err := b.SubscribeGroup("order", "group", "consumer", tc.opts...)
+			require.NoError(t, err)
+			// 保证两个订阅都取消之后topic依旧存在
+			_, err = b.Subscribe("order", "keeper")
+			require.NoError(t, err)
+			sendN(t, b, "order", 0, 3)
+
+			wants := [][]any{
+				{"msg-0", "msg-1", "msg-2"},
+				// sub在Drain的时候发送的消息依旧会投递给消费组
+				{"msg-0", "msg-1", "msg-2", "new-0"},
+			}
+			for i, s := range []*Subscription{sub, group} {
+				done := make(chan error)
+				go func(s *Subscription) {
+					done <- s.Drain(context.Background())
+				}(s)
+
+				// Drain之后不再接收新的消息，已经投递的消息都处理完之后才关闭管道
+				time.Sleep(20 * time.Millisecond)
+				require.NoError(t, b.Send(Message{Topic: "order", Content: fmt.Sprintf("new-%d", i)}))
+				var got []any
+				for msg := range s.C() {
+					got = append(got, msg.Content)
+					require.NoError(t, msg.Ack())
+				}
+				assert.Equal(t, wants[i], got)
+				assert.NoError(t, <-done)
+			}
+		})
+	}
+}
+
+func TestSubscription_DrainTimeout(t *testing.T) {
+	b := NewBroker()
+	sub, err := b.Subscribe("order", "consumer")
+	require.NoError(t, err)
+	sendN(t, b, "order", 0, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, sub.Drain(ctx))
+	// 超时之后依旧会关闭管道，还没有取走的消息可以继续读取
+	assert.Equal(t, []any{"msg-0"}, contents(drain(sub.C())))
+	_, ok := <-sub.C()
+	assert.False(t, ok)
+}
+
+func TestBroker_Shutdown(t *testing.T) {
+	dir := t.TempDir()
+	b := NewBroker(BrokerWithPersistence(dir))
+	sub, err := b.Subscribe("order", "consumer", SubscribeWithCapacity(1), SubscribeWithOverflow(OverflowSpill))
+	require.NoError(t, err)
+	group, err := b.SubscribeGroup("order", "group", "consumer")
+	require.NoError(t, err)
+	sendN(t, b, "order", 0, 3)
+	_, err = b.Publish(context.Background(), Message{Topic: "order", Content: "delay", Delay: time.Hour})
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		done <- b.Shutdown(context.Background())
+	}()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, ErrBrokerClosed, b.Send(Message{Topic: "order"}))
+	assert.Equal(t, ErrBrokerClosed, subscribeErr(b.Subscribe("order", "new")))
+
+	// 所有的订阅中的消息都被取走之后才会关闭管道，溢出缓冲区中的消息也会投递
+	assert.Equal(t, []any{"msg-0", "msg-1", "msg-2"}, contents(drain(group.C())))
+	var got []any
+	for msg := range sub.C() {
+		got = append(got, msg.Content)
+	}
+	assert.Equal(t, []any{"msg-0", "msg-1", "msg-2"}, got)
+	require.NoError(t, <-done)
+	_, ok := <-group.C()
+	assert.False(t, ok)
+	_, ok = <-sub.Err()
+	assert.False(t, ok)
+	assert.Equal(t, ErrBrokerClosed, b.Shutdown(context.Background()))
+
+	// 没有到期的延时消息保留在持久化的目录中
+	b = NewBroker(BrokerWithPersistence(dir))
+	defer b.Close("order")
+	sub, err = b.Subscribe("order", "consumer", SubscribeFromEarliest())
+	require.NoError(t, err)
+	assert.Equal(t, []any{"msg-0", "msg-1", "msg-2"}, receive(t, b, "order", "consumer", 3))
+	require.NoError(t, b.Cancel(getScheduledID(t, b)))
+}
+
+func getScheduledID(t *testing.T, b *Broker) string {
+	b.scheduler.mu.Lock()
+	defer b.scheduler.mu.Unlock()
+	require.Len(t, b.scheduler.index, 1)
+	for id := range b.scheduler.index {
+		return id
+	}
+	return ""
+}
+
+func TestBroker_CloseAndResubscribe(t *testing.T) {
+	b := NewBroker()
+	_, err := b.Subscribe("order", "consumer")
+	require.NoError(t, err)
+	b.Close("order")
+	b.Close("order")
+
+	// 关闭之后可以重新订阅
+	sub, err := b.Subscribe("order", "consumer")
+	require.NoError(t, err)
+	sendN(t, b, "order", 0, 1)
+	assert.Equal(t, "msg-0", receiveMsg(t, sub.C()).Content)
+	_, ok := b.ErrQueue("order")
+	assert.True(t, ok)
+}
@@ -0,0 +1,180 @@
+package queue
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var ErrUnexpectedContent = errors.New("消息的内容和Topic的类型不一致")
+
+// Handler 处理Topic中的消息，返回error表示处理失败
+type Handler[T any] func(ctx context.Context, val T) error
+
+// PayloadCodec 消息内容的编解码器，消息需要跨进程传输或者持久化时使用，
+// 解码的时候可以还原出具体的类型，不会像JSONCodec那样变成通用类型
+type PayloadCodec[T any] interface {
+	Encode(val T) ([]byte, error)
+	Decode(data []byte) (T, error)
+}
+
+// JSONPayloadCodec 使用JSON编解码消息的内容
+type JSONPayloadCodec[T any] struct{}
+
+func (JSONPayloadCodec[T]) Encode(val T) ([]byte, error) {
+	return json.Marshal(val)
+}
+
+func (JSONPayloadCodec[T]) Decode(data []byte) (T, error) {
+	var val T
+	err := json.Unmarshal(data, &val)
+	return val, err
+}
+
+type TopicOption[T any] func(t *Topic[T])
+
+// TopicWithCodec 发送之前把内容编码成[]byte，接收之后再解码，
+// 开启持久化之后重新消费历史消息时需要设置，否则解码出来的内容类型和T不一致
+func TopicWithCodec[T any](codec PayloadCodec[T]) TopicOption[T] {
+	return func(t *Topic[T]) {
+		t.codec = codec
+	}
+}
+
+// Topic 带类型的topic，发送和接收的内容都是T，不需要再做类型断言
+type Topic[T any] struct {
+	broker *Broker
+	name   string
+	// 为nil表示直接把T放进Message.Content
+	codec PayloadCodec[T]
+}
+
+func NewTopic[T any](broker *Broker, name string, opts ...TopicOption[T]) *Topic[T] {
+	t := &Topic[T]{
+		broker: broker,
+		name:   name,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// Publish 发送一条消息
+func (t *Topic[T]) Publish(ctx context.Context, val T) (*DeliveryReport, error) {
+	return t.PublishMessage(ctx, Message{}, val)
+}
+
+// PublishMessage 发送一条消息，msg中的Key、Headers、Delay等字段会保留，Topic和Content会被覆盖
+func (t *Topic[T]) PublishMessage(ctx context.Context, msg Message, val T) (*DeliveryReport, error) {
+	msg.Topic = t.name
+	msg.Content = val
+	if t.codec != nil {
+		data, err := t.codec.Encode(val)
+		if err != nil {
+			return nil, err
+		}
+		msg.Content = data
+	}
+	return t.broker.Publish(ctx, msg)
+}
+
+// Subscribe 订阅topic，在后台的goroutine中依次调用handler处理消息，ctx结束之后取消订阅。
+// handler返回error或者panic时，开启了确认(SubscribeWithAck)的订阅会Nack之后重新投递，
+// 超过最大投递次数之后进入死信队列，没有开启确认的订阅放进topic的错误队列。
+// 内容无法转换成T的消息重试也没有用，会直接放进错误队列
+func (t *Topic[T]) Subscribe(ctx context.Context, queueName string, handler Handler[T], opts ...SubscribeOption) (*Subscription, error) {
+	sub, err := t.broker.Subscribe(t.name, queueName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	go t.consume(ctx, sub, queueName, handler)
+	return sub, nil
+}
+
+// SubscribeGroup 以消费组的方式订阅topic，ctx结束之后离开消费组，处理失败时的行为和Subscribe一致
+func (t *Topic[T]) SubscribeGroup(ctx context.Context, group, consumer string, handler Handler[T], opts ...SubscribeOption) (*Subscription, error) {
+	sub, err := t.broker.SubscribeGroup(t.name, group, consumer, opts...)
+	if err != nil {
+		return nil, err
+	}
+	go t.consume(ctx, sub, consumer, handler)
+	return sub, nil
+}
+
+func (t *Topic[T]) consume(ctx context.Context, sub *Subscription, queueName string, handler Handler[T]) {
+	for {
+		select {
+		case msg, ok := <-sub.C():
+			if !ok {
+				return
+			}
+			msg.Queue = queueName
+			t.handle(ctx, handler, msg)
+		case <-ctx.Done():
+			sub.Unsubscribe()
+			return
+		}
+	}
+}
+
+func (t *Topic[T]) handle(ctx context.Context, handler Handler[T], msg Message) {
+	val, err := t.decode(msg)
+	if err != nil {
+		_ = msg.Ack()
+		t.broker.reportHandlerErr(t.name, ErrMessage{Message: msg, Err: err})
+		return
+	}
+
+	if err = t.call(ctx, handler, val); err == nil {
+		_ = msg.Ack()
+		return
+	}
+	if msg.acker != nil {
+		_ = msg.Nack()
+		return
+	}
+	t.broker.reportHandlerErr(t.name, ErrMessage{Message: msg, Err: err})
+}
+
+func (t *Topic[T]) decode(msg Message) (T, error) {
+	if t.codec == nil {
+		val, ok := msg.Content.(T)
+		if !ok {
+			return val, fmt.Errorf("%w: %T", ErrUnexpectedContent, msg.Content)
+		}
+		return val, nil
+	}
+
+	switch data := msg.Content.(type) {
+	case []byte:
+		return t.codec.Decode(data)
+	case string:
+		// 开启持久化时[]byte经过JSONCodec之后会变成base64的字符串
+		raw, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return *new(T), err
+		}
+		return t.codec.Decode(raw)
+	default:
+		return *new(T), fmt.Errorf("%w: %T", ErrUnexpectedContent, msg.Content)
+	}
+}
+
+func (t *Topic[T]) call(ctx context.Context, handler Handler[T], val T) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("处理消息时发生panic: %v", r)
+		}
+	}()
+	return handler(ctx, val)
+}
+
+// reportHandlerErr 把处理失败的消息放进topic的错误队列，错误队列已满时丢弃
+func (b *Broker) reportHandlerErr(topic string, errMsg ErrMessage) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	b.reportErr(topic, errMsg)
+}
@@ -0,0 +1,113 @@
+package queue
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	// topicSeparator 分隔topic的层级，例如orders.eu.created
+	topicSeparator = "."
+	// wildcardOne 匹配一个层级
+	wildcardOne = "*"
+	// wildcardMulti 匹配零个或者多个层级
+	wildcardMulti = "#"
+	// systemTopicPrefix 系统内部使用的topic的前缀，例如死信队列，通配符不会匹配这些topic
+	systemTopicPrefix = "%"
+)
+
+var (
+	ErrInvalidTopicPattern = errors.New("通配符必须单独占一个层级")
+	ErrPublishToPattern    = errors.New("不能向包含通配符的topic发送消息")
+)
+
+// IsTopicPattern topic中是否包含通配符
+func IsTopicPattern(topic string) bool {
+	return strings.Contains(topic, wildcardOne) || strings.Contains(topic, wildcardMulti)
+}
+
+// validatePattern 检查通配符是否单独占一个层级，例如orders.*.created，orders.#
+func validatePattern(pattern string) error {
+	for _, seg := range strings.Split(pattern, topicSeparator) {
+		if seg != wildcardOne && seg != wildcardMulti && IsTopicPattern(seg) {
+			return ErrInvalidTopicPattern
+		}
+	}
+	return nil
+}
+
+// MatchTopic 判断topic是否匹配pattern，topic使用.分隔层级，
+// *匹配一个层级，#匹配零个或者多个层级，例如orders.*.created匹配orders.eu.created，
+// orders.#匹配orders以及orders.eu.created。以%开头的系统topic只能被精确匹配
+func MatchTopic(pattern, topic string) bool {
+	if pattern == topic {
+		return true
+	}
+	if strings.HasPrefix(topic, systemTopicPrefix) {
+		return false
+	}
+	return matchSegments(strings.Split(pattern, topicSeparator), strings.Split(topic, topicSeparator))
+}
+
+func matchSegments(pattern, topic []string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case wildcardMulti:
+			// 连续的#和一个#等价
+			for len(pattern) > 0 && pattern[0] == wildcardMulti {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(topic); i++ {
+				if matchSegments(pattern, topic[i:]) {
+					return true
+				}
+			}
+			return false
+		case wildcardOne:
+			if len(topic) == 0 {
+				return false
+			}
+		default:
+			if len(topic) == 0 || pattern[0] != topic[0] {
+				return false
+			}
+		}
+		pattern, topic = pattern[1:], topic[1:]
+	}
+	return len(topic) == 0
+}
+
+// SubscribeWithHeaders 只接收Headers中包含全部键值对的消息，消费组在创建的时候(第一个消费者加入时)生效
+func SubscribeWithHeaders(headers map[string]string) SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		cfg.headers = headers
+	}
+}
+
+// matchHeaders msg的Headers是否包含want中的全部键值对
+func matchHeaders(want map[string]string, msg Message) bool {
+	for k, v := range want {
+		if val, ok := msg.Headers[k]; !ok || val != v {
+			return false
+		}
+	}
+	return true
+}
+
+// routes 返回msg.Topic需要投递的订阅表的key，包括topic本身以及匹配它的通配符，
+// 调用方需要持有b.mu
+func (b *Broker) routes(topic string) []string {
+	var res []string
+	if _, ok := b.brokerChain[topic]; ok {
+		res = append(res, topic)
+	}
+	for pattern := range b.patterns {
+		if pattern != topic && MatchTopic(pattern, topic) {
+			res = append(res, pattern)
+		}
+	}
+	return res
+}
@@ -0,0 +1,145 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchTopic(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		topic   string
+		want    bool
+	}{
+		{pattern: "orders.eu.created", topic: "orders.eu.created", want: true},
+		{pattern: "orders.*.created", topic: "orders.eu.created", want: true},
+		{pattern: "orders.*.created", topic: "orders.created", want: false},
+		{pattern: "orders.*.created", topic: "orders.eu.west.created", want: false},
+		{pattern: "orders.*", topic: "orders", want: false},
+		{pattern: "orders.#", topic: "orders", want: true},
+		{pattern: "orders.#", topic: "orders.eu.created", want: true},
+		{pattern: "orders.#", topic: "users.eu", want: false},
+		{pattern: "orders.#.created", topic: "orders.created", want: true},
+		{pattern: "orders.#.created", topic: "orders.eu.west.created", want: true},
+		{pattern: "orders.#.created", topic: "orders.eu.deleted", want: false},
+		{pattern: "#.#.created", topic: "orders.created", want: true},
+		{pattern: "#", topic: "orders.eu.created", want: true},
+		{pattern: "*.*", topic: "orders.eu", want: true},
+		// 通配符不会匹配系统topic
+		{pattern: "#", topic: DeadLetterTopic("orders"), want: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.pattern+" "+tc.topic, func(t *testing.T) {
+			assert.Equal(t, tc.want, MatchTopic(tc.pattern, tc.topic))
+		})
+	}
+}
+
+func TestBroker_Wildcard(t *testing.T) {
+	b := NewBroker()
+	require.NoError(t, subscribeErr(b.Subscribe("orders.eu.created", "exact")))
+	require.NoError(t, subscribeErr(b.Subscribe("orders.*.created", "created")))
+	require.NoError(t, subscribeErr(b.Subscribe("orders.#", "all")))
+	require.NoError(t, subscribeErr(b.SubscribeGroup("orders.#", "group", "consumer")))
+	assert.Equal(t, ErrInvalidTopicPattern, subscribeErr(b.Subscribe("orders.eu*", "invalid")))
+	assert.Equal(t, ErrInvalidTopicPattern, subscribeErr(b.SubscribeGroup("orders.#eu", "group", "invalid")))
+	assert.Error(t, subscribeErr(b.Subscribe("orders.#", "earliest", SubscribeFromEarliest())))
+
+	for _, topic := range []string{"orders.eu.created", "orders.us.created", "orders.eu.deleted"} {
+		require.NoError(t, b.Send(Message{Topic: topic, Content: topic}))
+	}
+	_, err := b.Publish(context.Background(), Message{Topic: "orders.*.created"})
+	assert.Equal(t, ErrPublishToPattern, err)
+	// 没有匹配的订阅者
+	assert.EqualError(t, b.Send(Message{Topic: "users.created"}), "topic不存在")
+
+	assert.Equal(t, []any{"orders.eu.created"}, receive(t, b, "orders.eu.created", "exact", 1))
+	assert.Equal(t, []any{"orders.eu.created", "orders.us.created"}, receive(t, b, "orders.*.created", "created", 2))
+	assert.Equal(t, []any{"orders.eu.created", "orders.us.created", "orders.eu.deleted"}, receive(t, b, "orders.#", "all", 3))
+	ch, _ := b.GroupQueue("orders.#", "group", "consumer")
+	msg := receiveMsg(t, ch)
+	assert.Equal(t, "orders.eu.created", msg.Topic)
+	assert.False(t, msg.Timestamp.IsZero())
+
+	// 通配符订阅者的队列满了之后放进通配符topic的错误队列
+	require.NoError(t, subscribeErr(b.Subscribe("users.*", "full", SubscribeWithCapacity(0))))
+	require.NoError(t, b.Send(Message{Topic: "users.created"}))
+	errCh, _ := b.ErrQueue("users.*")
+	errs := drainErr(errCh)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "users.created", errs[0].Topic)
+	assert.Equal(t, "full", errs[0].Queue)
+}
+
+func TestBroker_Routes(t *testing.T) {
+	b := NewBroker()
+	require.NoError(t, subscribeErr(b.Subscribe("orders.eu.created", "exact")))
+	created, err := b.Subscribe("orders.*.created", "created")
+	require.NoError(t, err)
+	require.NoError(t, subscribeErr(b.SubscribeGroup("orders.#", "group", "consumer")))
+	// 只有通配符的topic需要在发送时逐个匹配
+	assert.Equal(t, map[string]struct{}{"orders.*.created": {}, "orders.#": {}}, b.patterns)
+	assert.ElementsMatch(t, []string{"orders.eu.created", "orders.*.created", "orders.#"}, b.routes("orders.eu.created"))
+	assert.ElementsMatch(t, []string{"orders.*.created", "orders.#"}, b.routes("orders.us.created"))
+
+	// 取消订阅或者关闭之后不再匹配
+	created.Unsubscribe()
+	assert.ElementsMatch(t, []string{"orders.#"}, b.routes("orders.us.created"))
+	b.Close("orders.#")
+	assert.Empty(t, b.patterns)
+	assert.Empty(t, b.routes("orders.us.created"))
+	assert.Equal(t, []string{"orders.eu.created"}, b.routes("orders.eu.created"))
+}
+
+func TestBroker_HeaderFilter(t *testing.T) {
+	b := NewBroker()
+	defer b.Close("order")
+	require.NoError(t, subscribeErr(b.Subscribe("order", "all")))
+	require.NoError(t, subscribeErr(b.Subscribe("order", "eu", SubscribeWithHeaders(map[string]string{"region": "eu"}))))
+	require.NoError(t, subscribeErr(b.Subscribe("order", "eu vip", SubscribeWithHeaders(map[string]string{"region": "eu", "level": "vip"}))))
+	require.NoError(t, subscribeErr(b.SubscribeGroup("order", "us", "consumer", SubscribeWithHeaders(map[string]string{"region": "us"}))))
+
+	msgs := []Message{
+		{Topic: "order", Content: "eu", Headers: map[string]string{"region": "eu"}},
+		{Topic: "order", Content: "eu vip", Headers: map[string]string{"region": "eu", "level": "vip"}},
+		{Topic: "order", Content: "us", Headers: map[string]string{"region": "us", "level": "vip"}},
+		{Topic: "order", Content: "none"},
+	}
+	for _, msg := range msgs {
+		require.NoError(t, b.Send(msg))
+	}
+
+	assert.Equal(t, []any{"eu", "eu vip", "us", "none"}, receive(t, b, "order", "all", 4))
+	assert.Equal(t, []any{"eu", "eu vip"}, receive(t, b, "order", "eu", 2))
+	assert.Equal(t, []any{"eu vip"}, receive(t, b, "order", "eu vip", 1))
+	ch, _ := b.GroupQueue("order", "us", "consumer")
+	assert.Equal(t, "us", receiveMsg(t, ch).Content)
+	for _, name := range []string{"eu", "eu vip"} {
+		ch, _ := b.Queue("order", name)
+		assertNoMsg(t, ch, 10*time.Millisecond)
+	}
+}
+
+func TestBroker_HeaderFilterCatchUp(t *testing.T) {
+	b := NewBroker(BrokerWithPersistence(t.TempDir()))
+	defer b.Close("order")
+	require.NoError(t, b.Send(Message{Topic: "order", Content: "eu", Headers: map[string]string{"region": "eu"}}))
+	require.NoError(t, b.Send(Message{Topic: "order", Content: "us", Headers: map[string]string{"region": "us"}}))
+	require.NoError(t, subscribeErr(b.Subscribe("order", "eu", SubscribeFromEarliest(), SubscribeWithHeaders(map[string]string{"region": "eu"}))))
+
+	msg := receiveMsg(t, mustQueue(t, b, "order", "eu"))
+	assert.Equal(t, "eu", msg.Content)
+	assert.Equal(t, map[string]string{"region": "eu"}, msg.Headers)
+	assertNoMsg(t, mustQueue(t, b, "order", "eu"), 20*time.Millisecond)
+}
+
+func mustQueue(t *testing.T, b *Broker, topic, queue string) <-chan Message {
+	ch, ok := b.Queue(topic, queue)
+	require.True(t, ok)
+	return ch
+}
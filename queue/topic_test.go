@@ -0,0 +1,149 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type order struct {
+	ID    int
+	Price float64
+}
+
+// collect 返回把收到的值放进管道的handler
+func collect[T any](ch chan<- T) Handler[T] {
+	return func(ctx context.Context, val T) error {
+		ch <- val
+		return nil
+	}
+}
+
+func receiveVal[T any](t *testing.T, ch <-chan T) T {
+	select {
+	case val := <-ch:
+		return val
+	case <-time.After(time.Second):
+		t.Fatal("没有收到消息")
+		var val T
+		return val
+	}
+}
+
+func TestTopic(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts []TopicOption[order]
+	}{
+		{name: "without codec"},
+		{name: "json codec", opts: []TopicOption[order]{TopicWithCodec[order](JSONPayloadCodec[order]{})}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			topic := NewTopic[order](NewBroker(), "order", tc.opts...)
+			ch := make(chan order, 1)
+			require.NoError(t, subscribeErr(topic.Subscribe(ctx, "consumer", collect(ch))))
+			groupCh := make(chan order, 1)
+			require.NoError(t, subscribeErr(topic.SubscribeGroup(ctx, "group", "consumer", collect(groupCh))))
+
+			_, err := topic.Publish(ctx, order{ID: 1, Price: 9.9})
+			require.NoError(t, err)
+			assert.Equal(t, order{ID: 1, Price: 9.9}, receiveVal(t, ch))
+			assert.Equal(t, order{ID: 1, Price: 9.9}, receiveVal(t, groupCh))
+		})
+	}
+}
+
+func TestTopic_UnexpectedContent(t *testing.T) {
+	b := NewBroker()
+	topic := NewTopic[order](b, "order")
+	ch := make(chan order, 1)
+	require.NoError(t, subscribeErr(topic.Subscribe(context.Background(), "consumer", collect(ch))))
+	errCh, _ := b.ErrQueue("order")
+
+	require.NoError(t, b.Send(Message{Topic: "order", Content: "not an order"}))
+	errMsg := <-errCh
+	assert.ErrorIs(t, errMsg.Err, ErrUnexpectedContent)
+	assert.Equal(t, "consumer", errMsg.Queue)
+	assert.Equal(t, "not an order", errMsg.Content)
+}
+
+func TestTopic_HandlerError(t *testing.T) {
+	b := NewBroker()
+	topic := NewTopic[int](b, "order")
+	require.NoError(t, subscribeErr(topic.Subscribe(context.Background(), "consumer", func(ctx context.Context, val int) error {
+		if val == 1 {
+			panic("boom")
+		}
+		return errors.New("处理失败")
+	})))
+	errCh, _ := b.ErrQueue("order")
+
+	// 没有开启确认的时候放进错误队列
+	_, err := topic.Publish(context.Background(), 1)
+	require.NoError(t, err)
+	_, err = topic.Publish(context.Background(), 2)
+	require.NoError(t, err)
+	assert.EqualError(t, (<-errCh).Err, "处理消息时发生panic: boom")
+	assert.EqualError(t, (<-errCh).Err, "处理失败")
+}
+
+func TestTopic_Retry(t *testing.T) {
+	b := NewBroker()
+	topic := NewTopic[int](b, "order")
+	attempts := make(chan int, 10)
+	var cnt atomic.Int32
+	require.NoError(t, subscribeErr(topic.Subscribe(context.Background(), "consumer", func(ctx context.Context, val int) error {
+		attempts <- val
+		if cnt.Add(1) < 3 {
+			return errors.New("处理失败")
+		}
+		return nil
+	}, SubscribeWithAck(time.Second), SubscribeWithMaxAttempts(5))))
+
+	// 开启确认的时候处理失败会重新投递
+	_, err := topic.Publish(context.Background(), 1)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, 1, receiveVal(t, attempts))
+	}
+	select {
+	case <-attempts:
+		t.Fatal("处理成功之后不应该重新投递")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestTopic_Persistence(t *testing.T) {
+	dir := t.TempDir()
+	b := NewBroker(BrokerWithPersistence(dir))
+	topic := NewTopic[order](b, "order", TopicWithCodec[order](JSONPayloadCodec[order]{}))
+	_, err := topic.Publish(context.Background(), order{ID: 1, Price: 9.9})
+	require.NoError(t, err)
+	b.Close("order")
+
+	// 经过持久化之后依旧能还原出具体的类型
+	b = NewBroker(BrokerWithPersistence(dir))
+	topic = NewTopic[order](b, "order", TopicWithCodec[order](JSONPayloadCodec[order]{}))
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan order, 1)
+	require.NoError(t, subscribeErr(topic.Subscribe(ctx, "consumer", collect(ch), SubscribeFromEarliest())))
+	assert.Equal(t, order{ID: 1, Price: 9.9}, receiveVal(t, ch))
+
+	// ctx结束之后取消订阅
+	cancel()
+	require.Eventually(t, func() bool {
+		b.mu.RLock()
+		defer b.mu.RUnlock()
+		_, ok := b.brokerChain["order"]["consumer"]
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+}
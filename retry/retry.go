@@ -0,0 +1,42 @@
+package retry
+
+import (
+	"context"
+	"time"
+)
+
+// Do 执行fn，失败之后按照strategy重试，直到fn执行成功、strategy不再重试或者ctx结束
+// strategy不再重试时返回最后一次的错误，ctx结束时返回ctx.Err()
+func Do(ctx context.Context, fn func(ctx context.Context) error, strategy Strategy) error {
+	reset(strategy)
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+
+		interval, ok := strategy.Next(err)
+		if !ok {
+			return err
+		}
+
+		if timer == nil {
+			timer = time.NewTimer(interval)
+		} else {
+			timer.Reset(interval)
+		}
+
+		select {
+		case <-timer.C:
+			// 进行下一轮重试
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
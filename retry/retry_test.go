@@ -0,0 +1,94 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDo(t *testing.T) {
+	errFailed := errors.New("failed")
+	errFatal := errors.New("fatal")
+	testCases := []struct {
+		name     string
+		ctx      func() (context.Context, context.CancelFunc)
+		strategy Strategy
+		// 前failCnt次调用返回failErr
+		failCnt  int
+		failErr  error
+		wantErr  error
+		wantCall int
+	}{
+		{
+			name: "success",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithCancel(context.Background())
+			},
+			strategy: NewConstantStrategy(time.Millisecond),
+			wantCall: 1,
+		},
+		{
+			name: "success after retry",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithCancel(context.Background())
+			},
+			strategy: WithMaxAttempts(NewConstantStrategy(time.Millisecond), 3),
+			failCnt:  3,
+			failErr:  errFailed,
+			wantCall: 4,
+		},
+		{
+			name: "over max attempts",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithCancel(context.Background())
+			},
+			strategy: WithMaxAttempts(NewConstantStrategy(time.Millisecond), 2),
+			failCnt:  3,
+			failErr:  errFailed,
+			wantErr:  errFailed,
+			wantCall: 3,
+		},
+		{
+			name: "not retryable",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithCancel(context.Background())
+			},
+			strategy: WithClassifier(NewConstantStrategy(time.Millisecond), NotRetryOn(errFatal)),
+			failCnt:  3,
+			failErr:  errFatal,
+			wantErr:  errFatal,
+			wantCall: 1,
+		},
+		{
+			name: "context timeout",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 50*time.Millisecond)
+			},
+			strategy: NewConstantStrategy(time.Hour),
+			failCnt:  3,
+			failErr:  errFailed,
+			wantErr:  context.DeadlineExceeded,
+			wantCall: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := tc.ctx()
+			defer cancel()
+			call := 0
+			err := Do(ctx, func(ctx context.Context) error {
+				call++
+				if call <= tc.failCnt {
+					return tc.failErr
+				}
+				return nil
+			}, tc.strategy)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantCall, call)
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package retry
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// maxInterval 不限制最大间隔时，间隔增长到这里就不再增长，避免溢出之后变成负数
+const maxInterval = time.Duration(math.MaxInt64)
+
+var (
+	_ Strategy = (*ConstantStrategy)(nil)
+	_ Strategy = (*LinearStrategy)(nil)
+	_ Strategy = (*ExponentialStrategy)(nil)
+	_ Strategy = (*maxAttemptsStrategy)(nil)
+	_ Strategy = (*deadlineStrategy)(nil)
+	_ Strategy = (*classifierStrategy)(nil)
+)
+
+// ConstantStrategy 固定时间间隔的重试策略，不限制次数，需要限制次数可以配合WithMaxAttempts使用
+type ConstantStrategy struct {
+	// 时间间隔
+	Interval time.Duration
+}
+
+func NewConstantStrategy(interval time.Duration) *ConstantStrategy {
+	return &ConstantStrategy{
+		Interval: interval,
+	}
+}
+
+func (s *ConstantStrategy) Next(err error) (time.Duration, bool) {
+	return s.Interval, true
+}
+
+// LinearStrategy 线性增长的重试策略，第n次重试的间隔是Initial + (n-1) * Step，最大不超过Max
+type LinearStrategy struct {
+	// 第一次重试的间隔
+	Initial time.Duration
+	// 每次增加的间隔
+	Step time.Duration
+	// 最大的重试间隔，小于等于0表示不限制
+	Max time.Duration
+	// 已经重试的次数
+	cnt int
+}
+
+func NewLinearStrategy(initial, step, maxInterval time.Duration) *LinearStrategy {
+	return &LinearStrategy{
+		Initial: initial,
+		Step:    step,
+		Max:     maxInterval,
+	}
+}
+
+func (s *LinearStrategy) Next(err error) (time.Duration, bool) {
+	interval := s.Initial + time.Duration(s.cnt)*s.Step
+	s.cnt++
+	if s.Max > 0 && interval > s.Max {
+		interval = s.Max
+	}
+	return interval, true
+}
+
+func (s *LinearStrategy) Reset() {
+	s.cnt = 0
+}
+
+// Jitter 指数退避的抖动方式，避免大量客户端在同一时刻重试
+type Jitter uint8
+
+const (
+	// JitterNone 不加抖动
+	JitterNone Jitter = iota
+	// JitterFull 在[0, 退避间隔]之间随机
+	JitterFull
+	// JitterDecorrelated 在[Initial, 上一次间隔*3]之间随机
+	JitterDecorrelated
+)
+
+// ExponentialStrategy 指数退避的重试策略，每次重试的间隔翻倍，最大不超过Max
+type ExponentialStrategy struct {
+	// 第一次重试的间隔
+	Initial time.Duration
+	// 最大的重试间隔，小于等于0表示不限制
+	Max time.Duration
+	// 抖动方式
+	Jitter Jitter
+	// 不加抖动时的当前间隔
+	current time.Duration
+	// 上一次返回的间隔，用于JitterDecorrelated
+	prev time.Duration
+}
+
+func NewExponentialStrategy(initial, maxInterval time.Duration, jitter Jitter) *ExponentialStrategy {
+	return &ExponentialStrategy{
+		Initial: initial,
+		Max:     maxInterval,
+		Jitter:  jitter,
+	}
+}
+
+func (s *ExponentialStrategy) Next(err error) (time.Duration, bool) {
+	if s.current == 0 {
+		s.current = s.Initial
+	} else {
+		s.current = s.capped(mulCapped(s.current, 2))
+	}
+
+	var interval time.Duration
+	switch s.Jitter {
+	case JitterFull:
+		interval = randBetween(0, s.current)
+	case JitterDecorrelated:
+		if s.prev == 0 {
+			interval = s.Initial
+		} else {
+			interval = s.capped(randBetween(s.Initial, mulCapped(s.prev, 3)))
+		}
+	default:
+		interval = s.current
+	}
+	s.prev = interval
+	return interval, true
+}
+
+func (s *ExponentialStrategy) Reset() {
+	s.current = 0
+	s.prev = 0
+}
+
+// capped 限制最大间隔
+func (s *ExponentialStrategy) capped(d time.Duration) time.Duration {
+	if s.Max > 0 && d > s.Max {
+		return s.Max
+	}
+	return d
+}
+
+// mulCapped 返回d*n，溢出时返回maxInterval
+func mulCapped(d time.Duration, n int64) time.Duration {
+	if d > maxInterval/time.Duration(n) {
+		return maxInterval
+	}
+	return d * time.Duration(n)
+}
+
+// randBetween 返回[from, to]之间的随机时间
+func randBetween(from, to time.Duration) time.Duration {
+	if to <= from {
+		return from
+	}
+	n := int64(to - from)
+	// to - from已经是最大值时再加一会溢出
+	if n < math.MaxInt64 {
+		n++
+	}
+	return from + time.Duration(rand.Int63n(n))
+}
+
+// maxAttemptsStrategy 限制最大重试次数
+type maxAttemptsStrategy struct {
+	Strategy
+	maxAttempts int
+	cnt         int
+}
+
+// WithMaxAttempts 限制s最多重试maxAttempts次
+func WithMaxAttempts(s Strategy, maxAttempts int) Strategy {
+	return &maxAttemptsStrategy{
+		Strategy:    s,
+		maxAttempts: maxAttempts,
+	}
+}
+
+func (s *maxAttemptsStrategy) Next(err error) (time.Duration, bool) {
+	if s.cnt >= s.maxAttempts {
+		return 0, false
+	}
+	s.cnt++
+	return s.Strategy.Next(err)
+}
+
+func (s *maxAttemptsStrategy) Reset() {
+	s.cnt = 0
+	reset(s.Strategy)
+}
+
+// deadlineStrategy 限制重试的总时长
+type deadlineStrategy struct {
+	Strategy
+	timeout time.Duration
+	// 第一次调用Next的时间作为起点
+	start time.Time
+	now   func() time.Time
+}
+
+// WithDeadline 限制s重试的总时长，从第一次调用Next开始计时，下一次重试的时间超过timeout就不再重试
+func WithDeadline(s Strategy, timeout time.Duration) Strategy {
+	return &deadlineStrategy{
+		Strategy: s,
+		timeout:  timeout,
+		now:      time.Now,
+	}
+}
+
+func (s *deadlineStrategy) Next(err error) (time.Duration, bool) {
+	now := s.now()
+	if s.start.IsZero() {
+		s.start = now
+	}
+	interval, ok := s.Strategy.Next(err)
+	if !ok || now.Add(interval).Sub(s.start) > s.timeout {
+		return 0, false
+	}
+	return interval, true
+}
+
+func (s *deadlineStrategy) Reset() {
+	s.start = time.Time{}
+	reset(s.Strategy)
+}
+
+// classifierStrategy 根据错误类型决定是否重试
+type classifierStrategy struct {
+	Strategy
+	retryable Classifier
+}
+
+// WithClassifier 只有retryable返回true的错误才会重试，err为nil时交给s决定
+func WithClassifier(s Strategy, retryable Classifier) Strategy {
+	return &classifierStrategy{
+		Strategy:  s,
+		retryable: retryable,
+	}
+}
+
+func (s *classifierStrategy) Next(err error) (time.Duration, bool) {
+	if err != nil && !s.retryable(err) {
+		return 0, false
+	}
+	return s.Strategy.Next(err)
+}
+
+func (s *classifierStrategy) Reset() {
+	reset(s.Strategy)
+}
+
+// RetryOn 返回一个Classifier，只有errors.Is匹配targets之一的错误可以重试
+func RetryOn(targets ...error) Classifier {
+	return func(err error) bool {
+		for _, target := range targets {
+			if errors.Is(err, target) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// NotRetryOn 返回一个Classifier，errors.Is匹配targets之一的错误不会重试，其它错误都可以重试
+func NotRetryOn(targets ...error) Classifier {
+	retryOn := RetryOn(targets...)
+	return func(err error) bool {
+		return !retryOn(err)
+	}
+}
+
+func reset(s Strategy) {
+	if r, ok := s.(Resetter); ok {
+		r.Reset()
+	}
+}
@@ -0,0 +1,191 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLinearStrategy_Next(t *testing.T) {
+	s := NewLinearStrategy(time.Second, 2*time.Second, 6*time.Second)
+	want := []time.Duration{time.Second, 3 * time.Second, 5 * time.Second, 6 * time.Second, 6 * time.Second}
+	for _, w := range want {
+		interval, ok := s.Next(nil)
+		assert.True(t, ok)
+		assert.Equal(t, w, interval)
+	}
+
+	s.Reset()
+	interval, _ := s.Next(nil)
+	assert.Equal(t, time.Second, interval)
+}
+
+func TestExponentialStrategy_Next(t *testing.T) {
+	testCases := []struct {
+		name   string
+		jitter Jitter
+		check  func(t *testing.T, i int, interval time.Duration)
+	}{
+		{
+			name:   "no jitter",
+			jitter: JitterNone,
+			check: func(t *testing.T, i int, interval time.Duration) {
+				want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second}
+				if i < len(want) {
+					assert.Equal(t, want[i], interval)
+				} else {
+					assert.Equal(t, 10*time.Second, interval)
+				}
+			},
+		},
+		{
+			name:   "full jitter",
+			jitter: JitterFull,
+			check: func(t *testing.T, i int, interval time.Duration) {
+				ceil := 10 * time.Second
+				if i < 4 {
+					ceil = time.Second << i
+				}
+				assert.GreaterOrEqual(t, interval, time.Duration(0))
+				assert.LessOrEqual(t, interval, ceil)
+			},
+		},
+		{
+			name:   "decorrelated jitter",
+			jitter: JitterDecorrelated,
+			check: func(t *testing.T, i int, interval time.Duration) {
+				if i == 0 {
+					assert.Equal(t, time.Second, interval)
+					return
+				}
+				assert.GreaterOrEqual(t, interval, time.Second)
+				assert.LessOrEqual(t, interval, 10*time.Second)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewExponentialStrategy(time.Second, 10*time.Second, tc.jitter)
+			for i := 0; i < 100; i++ {
+				interval, ok := s.Next(nil)
+				assert.True(t, ok)
+				tc.check(t, i, interval)
+			}
+			s.Reset()
+			interval, _ := s.Next(nil)
+			tc.check(t, 0, interval)
+		})
+	}
+}
+
+func TestExponentialStrategy_NoMax(t *testing.T) {
+	for _, jitter := range []Jitter{JitterNone, JitterFull, JitterDecorrelated} {
+		t.Run(fmt.Sprintf("jitter %d", jitter), func(t *testing.T) {
+			// 不限制最大间隔时重试很多次也不会溢出成负数或者0
+			s := NewExponentialStrategy(time.Second, 0, jitter)
+			var last time.Duration
+			for i := 0; i < 1000; i++ {
+				interval, ok := s.Next(nil)
+				assert.True(t, ok)
+				// JitterFull可能随机到0
+				if jitter == JitterFull {
+					assert.GreaterOrEqual(t, interval, time.Duration(0))
+				} else {
+					assert.Greater(t, interval, time.Duration(0))
+				}
+				last = interval
+			}
+			if jitter == JitterNone {
+				assert.Equal(t, maxInterval, last)
+			}
+		})
+	}
+}
+
+func TestWithMaxAttempts(t *testing.T) {
+	s := WithMaxAttempts(NewConstantStrategy(time.Second), 3)
+	for i := 0; i < 3; i++ {
+		interval, ok := s.Next(nil)
+		assert.True(t, ok)
+		assert.Equal(t, time.Second, interval)
+	}
+	_, ok := s.Next(nil)
+	assert.False(t, ok)
+
+	reset(s)
+	_, ok = s.Next(nil)
+	assert.True(t, ok)
+}
+
+func TestWithDeadline(t *testing.T) {
+	now := time.Now()
+	s := WithDeadline(NewConstantStrategy(time.Second), 3*time.Second).(*deadlineStrategy)
+	s.now = func() time.Time {
+		return now
+	}
+
+	_, ok := s.Next(nil)
+	assert.True(t, ok)
+	now = now.Add(2 * time.Second)
+	_, ok = s.Next(nil)
+	assert.True(t, ok)
+	// 下一次重试的时间超过了总时长
+	now = now.Add(time.Second)
+	_, ok = s.Next(nil)
+	assert.False(t, ok)
+
+	reset(s)
+	_, ok = s.Next(nil)
+	assert.True(t, ok)
+}
+
+func TestWithClassifier(t *testing.T) {
+	errRetryable := errors.New("retryable")
+	testCases := []struct {
+		name       string
+		classifier Classifier
+		err        error
+		wantOk     bool
+	}{
+		{
+			name:       "nil error",
+			classifier: RetryOn(errRetryable),
+			wantOk:     true,
+		},
+		{
+			name:       "retry on",
+			classifier: RetryOn(errRetryable),
+			err:        errors.Join(errors.New("wrapped"), errRetryable),
+			wantOk:     true,
+		},
+		{
+			name:       "not in retry on",
+			classifier: RetryOn(errRetryable),
+			err:        context.Canceled,
+		},
+		{
+			name:       "not retry on",
+			classifier: NotRetryOn(context.Canceled),
+			err:        context.Canceled,
+		},
+		{
+			name:       "not in not retry on",
+			classifier: NotRetryOn(context.Canceled),
+			err:        errRetryable,
+			wantOk:     true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := WithClassifier(NewConstantStrategy(time.Second), tc.classifier)
+			_, ok := s.Next(tc.err)
+			assert.Equal(t, tc.wantOk, ok)
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package retry
+
+import "time"
+
+// Strategy 迭代器的模式实现重试策略
+type Strategy interface {
+	// Next 有两个返回值，第一个标识重试的间隔，第二个标识是否进行重试
+	// err是上一次执行的错误，可以为nil，例如抢锁时锁被别人持有
+	Next(err error) (time.Duration, bool)
+}
+
+// Resetter 有状态的重试策略实现该接口，调用Reset之后可以重新使用
+// Do在开始执行之前会调用Reset
+type Resetter interface {
+	Reset()
+}
+
+// Classifier 错误分类器，返回true表示err是可以重试的错误
+type Classifier func(err error) bool